@@ -0,0 +1,356 @@
+package auths
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"server/metrics"
+	"sync"
+	"time"
+
+	"github.com/bit4bit/gami"
+)
+
+const (
+	AMI_DISCONNECTED = "disconnected"
+	AMI_CONNECTING   = "connecting"
+	AMI_CONNECTED    = "connected"
+
+	AMI_ACTION_TIMEOUT    = 10 * time.Second
+	AMI_PING_INTERVAL     = 30 * time.Second
+	AMI_MIN_RETRY_DELAY   = time.Second
+	AMI_MAX_RETRY_DELAY   = time.Minute
+	AMI_MAX_QUEUED_ACTION = 1000
+)
+
+var ErrAMIDisconnected = errors.New("AMI is not connected")
+var ErrAMITimeout = errors.New("AMI action timed out")
+
+// queuedAction is an action that waits for the AMI connection to come back
+type queuedAction struct {
+	key    string
+	action string
+	params gami.Params
+}
+
+// AMIConnection keeps a logged in AMI session to Asterisk. It connects in the background, and after the connection
+// is lost it dials and logs in again with exponential backoff, so the server keeps running without telephony.
+// Actions are rejected while it is disconnected, except the ones sent with QueueAction, which are sent on reconnect.
+type AMIConnection struct {
+	address  string
+	username string
+	password string
+
+	// OnEvent is called from the connection's goroutine for every AMI event
+	OnEvent func(event *gami.AMIEvent)
+	// OnConnected is called from the connection's goroutine after every successful login
+	OnConnected func()
+
+	MinRetryDelay time.Duration
+	MaxRetryDelay time.Duration
+	PingInterval  time.Duration
+
+	client    *gami.AMIClient
+	state     string
+	lastError string
+	nextRetry time.Time
+	queue     []queuedAction
+	mutex     sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewAMIConnection(address string, username string, password string) *AMIConnection {
+	return &AMIConnection{
+		address:       address,
+		username:      username,
+		password:      password,
+		MinRetryDelay: AMI_MIN_RETRY_DELAY,
+		MaxRetryDelay: AMI_MAX_RETRY_DELAY,
+		PingInterval:  AMI_PING_INTERVAL,
+		state:         AMI_DISCONNECTED,
+	}
+}
+
+// Start connects in the background and keeps reconnecting until Stop
+func (c *AMIConnection) Start() {
+
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+
+	go c.run()
+}
+
+// Stop logs off and ends the reconnects
+func (c *AMIConnection) Stop() {
+
+	if c.stop == nil {
+		return
+	}
+
+	close(c.stop)
+	<-c.done
+}
+
+func (c *AMIConnection) run() {
+
+	defer close(c.done)
+
+	delay := c.MinRetryDelay
+
+	for {
+		client, err := c.connect()
+		if err == nil {
+			delay = c.MinRetryDelay
+			if stopped := c.serve(client); stopped {
+				return
+			}
+			continue
+		}
+
+		log.Println("AMI Connection to ", c.address, " failed, retrying in ", delay, ": ", err)
+
+		c.mutex.Lock()
+		c.lastError = err.Error()
+		c.nextRetry = time.Now().Add(delay)
+		c.mutex.Unlock()
+
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > c.MaxRetryDelay {
+			delay = c.MaxRetryDelay
+		}
+	}
+}
+
+// connect dials and logs in, then sends the actions queued while disconnected
+func (c *AMIConnection) connect() (*gami.AMIClient, error) {
+
+	c.setState(AMI_CONNECTING)
+
+	client, err := gami.Dial(c.address)
+	if err != nil {
+		c.setState(AMI_DISCONNECTED)
+		return nil, err
+	}
+
+	client.Run()
+
+	response, err := actionWithTimeout(client, "Login", gami.Params{"Username": c.username, "Secret": c.password}, AMI_ACTION_TIMEOUT)
+	if err == nil && response.Status != "Success" {
+		err = errors.New("AMI Login failed: " + response.Params["Message"])
+	}
+	if err != nil {
+		closeClient(client)
+		c.setState(AMI_DISCONNECTED)
+		return nil, err
+	}
+
+	log.Println("AMI Connected to ", c.address, " as ", c.username)
+
+	c.mutex.Lock()
+	c.client = client
+	c.state = AMI_CONNECTED
+	c.lastError = ""
+	queue := c.queue
+	c.queue = nil
+	c.mutex.Unlock()
+	metrics.AMIConnected.Set(1)
+
+	for _, queued := range queue {
+		c.send(client, queued.action, queued.params)
+	}
+
+	if c.OnConnected != nil {
+		c.OnConnected()
+	}
+
+	return client, nil
+}
+
+// serve handles the client's events until its connection is lost, it reports whether the connection was stopped
+func (c *AMIConnection) serve(client *gami.AMIClient) (stopped bool) {
+
+	ping := time.NewTicker(c.PingInterval)
+	defer ping.Stop()
+
+	lost := func(reason string) {
+		log.Println("AMI Connection lost: ", reason)
+		c.disconnected(reason)
+
+		// gami leaves the reader of a lost connection waiting for a Reconnect, the next connection is a new client
+		go closeClient(client)
+	}
+
+	for {
+		select {
+		case <-c.stop:
+			c.disconnected("stopped")
+			closeClient(client)
+			return true
+
+		case err := <-client.NetError:
+			lost(err.Error())
+			return false
+
+		case err := <-client.Error:
+			// gami only reports a closed connection on NetError, a reset one comes here over and over
+			if _, isNetError := err.(net.Error); isNetError || err == io.ErrUnexpectedEOF {
+				lost(err.Error())
+				return false
+			}
+			log.Println("AMI Error:", err)
+
+		case <-ping.C:
+			if _, err := actionWithTimeout(client, "Ping", nil, AMI_ACTION_TIMEOUT); err != nil {
+				lost("ping failed: " + err.Error())
+				return false
+			}
+
+		case event := <-client.Events:
+			log.Println("AMI Event received: ", event)
+			if c.OnEvent != nil {
+				c.OnEvent(event)
+			}
+		}
+	}
+}
+
+func (c *AMIConnection) disconnected(reason string) {
+
+	c.mutex.Lock()
+	c.client = nil
+	c.state = AMI_DISCONNECTED
+	c.lastError = reason
+	c.mutex.Unlock()
+
+	metrics.AMIConnected.Set(0)
+}
+
+func (c *AMIConnection) setState(state string) {
+
+	c.mutex.Lock()
+	c.state = state
+	c.mutex.Unlock()
+
+	if state != AMI_CONNECTED {
+		metrics.AMIConnected.Set(0)
+	}
+}
+
+// State returns the connection state and, while disconnected, why and when it retries
+func (c *AMIConnection) State() (state string, lastError string, nextRetry time.Time) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.state, c.lastError, c.nextRetry
+}
+
+func (c *AMIConnection) IsConnected() bool {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.state == AMI_CONNECTED
+}
+
+// Action sends the action now, or fails with ErrAMIDisconnected
+func (c *AMIConnection) Action(action string, params gami.Params) (*gami.AMIResponse, error) {
+	return c.ActionWithTimeout(action, params, AMI_ACTION_TIMEOUT)
+}
+
+func (c *AMIConnection) ActionWithTimeout(action string, params gami.Params, timeout time.Duration) (*gami.AMIResponse, error) {
+
+	c.mutex.Lock()
+	client := c.client
+	c.mutex.Unlock()
+
+	if client == nil {
+		return nil, ErrAMIDisconnected
+	}
+
+	return actionWithTimeout(client, action, params, timeout)
+}
+
+// QueueAction sends the action now, or once connected again. A queued action replaces the one queued with the same key,
+// so only the last membership change of a queue member is sent. It reports false if the action was sent and failed.
+func (c *AMIConnection) QueueAction(key string, action string, params gami.Params) (*gami.AMIResponse, bool) {
+
+	c.mutex.Lock()
+	client := c.client
+
+	if client == nil {
+		queued := false
+		for i := range c.queue {
+			if c.queue[i].key == key {
+				c.queue[i] = queuedAction{key: key, action: action, params: params}
+				queued = true
+			}
+		}
+
+		if !queued && len(c.queue) < AMI_MAX_QUEUED_ACTION {
+			c.queue = append(c.queue, queuedAction{key: key, action: action, params: params})
+		} else if !queued {
+			log.Println("AMI action queue is full, dropping ", action, " ", params)
+		}
+		c.mutex.Unlock()
+
+		return nil, true
+	}
+	c.mutex.Unlock()
+
+	response, err := c.send(client, action, params)
+
+	return response, err == nil
+}
+
+func (c *AMIConnection) send(client *gami.AMIClient, action string, params gami.Params) (*gami.AMIResponse, error) {
+
+	response, err := actionWithTimeout(client, action, params, AMI_ACTION_TIMEOUT)
+	if err != nil {
+		log.Println("AMI Action ", action, " error: ", err)
+	}
+
+	return response, err
+}
+
+// actionWithTimeout does not wait forever for the response of an action sent over a connection that died meanwhile
+func actionWithTimeout(client *gami.AMIClient, action string, params gami.Params, timeout time.Duration) (*gami.AMIResponse, error) {
+
+	responses, err := client.AsyncAction(action, params)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-responses:
+		if response == nil {
+			return nil, ErrAMIDisconnected
+		}
+		return response, nil
+	case <-time.After(timeout):
+		return nil, ErrAMITimeout
+	}
+}
+
+// closeClient logs off, without waiting long for a server that does not answer anymore
+func closeClient(client *gami.AMIClient) {
+
+	closed := make(chan struct{})
+	go func() {
+		client.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(AMI_ACTION_TIMEOUT):
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"log"
 	"server/db"
 	"server/types"
+	"sync"
 	"time"
 
-	"github.com/bit4bit/gami"
 	"github.com/go-ini/ini"
 )
 
@@ -17,10 +17,41 @@ var (
 	AMI_PASSWORD       string
 	AST_DB_CREDENTIALS string
 	AST_DB_NAME        string
+
+	AST_CALL_CONTEXT     string
+	AST_CALL_DIAL_PREFIX string
+	AST_CALL_TIMEOUT     int //seconds the agent has to answer a click-to-call
+
+	AMI_RECONNECT_MIN_DELAY = AMI_MIN_RETRY_DELAY
+	AMI_RECONNECT_MAX_DELAY = AMI_MAX_RETRY_DELAY
 )
 
 type AsteriskAuthenticator struct {
-	AMIClient *gami.AMIClient
+	ami *AMIConnection
+
+	// lookup checks the PJSIP credentials, it reads the Asterisk DB unless replaced
+	lookup func(username string, password string) (result *db.QueryResult, err error)
+
+	// OnPhoneStateChanged is called from the AMI event loop when an extension starts ringing, answers or hangs up
+	OnPhoneStateChanged func(id string, state types.PhoneState)
+
+	phoneChannels map[string]phoneChannel     //map[uniqueID]call leg
+	phoneStates   map[string]types.PhoneState //map[extension]state, idle extensions are left out
+	phoneMutex    sync.Mutex
+
+	// OnCallProgress is called from the AMI event loop as a call started with CallNumber progresses
+	OnCallProgress func(callID string, progress string, details string)
+
+	calls      map[string]bool //map[callID]in progress
+	callsMutex sync.Mutex
+
+	// OnVoiceAvailabilityChanged is called from the AMI event loop when the agent is paused or unpaused in a voice queue
+	OnVoiceAvailabilityChanged func(id string, state types.AgentState)
+
+	voiceQueues  map[string][]string //map[agentID or DEFAULT]queues
+	pauseReasons map[types.AgentState]string
+	members      map[string]types.AgentState //map[agentID]state of the logged in queue members
+	membersMutex sync.Mutex
 }
 
 func (a *AsteriskAuthenticator) Init() {
@@ -34,6 +65,12 @@ func (a *AsteriskAuthenticator) Init() {
 	AST_PORT = cfg.Section("asterisk-authenticator").Key("AST_PORT").String()
 	AMI_USER = cfg.Section("asterisk-authenticator").Key("AMI_USER").String()
 	AMI_PASSWORD = cfg.Section("asterisk-authenticator").Key("AMI_PASSWORD").String()
+	AST_CALL_CONTEXT = cfg.Section("asterisk-authenticator").Key("CALL_CONTEXT").MustString("from-internal")
+	AST_CALL_DIAL_PREFIX = cfg.Section("asterisk-authenticator").Key("CALL_DIAL_PREFIX").String()
+	AST_CALL_TIMEOUT = cfg.Section("asterisk-authenticator").Key("CALL_TIMEOUT").MustInt(30)
+	AMI_RECONNECT_MIN_DELAY = time.Duration(cfg.Section("asterisk-authenticator").Key("RECONNECT_MIN_DELAY").MustInt(1)) * time.Second
+	AMI_RECONNECT_MAX_DELAY = time.Duration(cfg.Section("asterisk-authenticator").Key("RECONNECT_MAX_DELAY").MustInt(60)) * time.Second
+	a.loadVoiceQueues(cfg)
 
 	cfg, err = ini.Load("conf/db_conf.ini")
 	if err != nil {
@@ -53,93 +90,121 @@ func (a *AsteriskAuthenticator) Init() {
 	a.ConnectToManager()
 }
 
-func (a *AsteriskAuthenticator) Login(username string, password string) (*types.Agent, string) {
+func (a *AsteriskAuthenticator) Login(username string, password string) (*types.Agent, []types.AgentRole, string) {
 
 	agent := types.Agent{}
-	var failedMsg string
 
-	results := db.DBConnector.ExecuteQuery(AST_DB_CREDENTIALS, AST_DB_NAME, "SELECT u.ext, u.name FROM users u INNER JOIN ps_auths p ON u.ext = p.id WHERE p.id='"+username+"' AND p.password='"+password+"'")
+	lookup := a.lookup
+	if lookup == nil {
+		lookup = lookupPJSIPAccount
+	}
+
+	result, err := lookup(username, password)
+	if err != nil {
+		return nil, nil, "Login failed: " + err.Error()
+	} else if result == nil {
+		return nil, nil, "Login failed"
+	}
+
+	if !a.joinVoiceQueues(username) {
+		return nil, nil, "Login failed"
+	}
+
+	agent.Id = result.Param1.String
+	agent.Name = result.Param2.String
+	agent.Conversations = 0
+
+	return &agent, []types.AgentRole{types.RoleAgent}, ""
+}
+
+// lookupPJSIPAccount returns the extension and name of the account, or nil for wrong credentials
+func lookupPJSIPAccount(username string, password string) (*db.QueryResult, error) {
+
+	results := db.DBConnector.ExecuteQuery(AST_DB_CREDENTIALS, AST_DB_NAME, "SELECT u.ext, u.name FROM users u INNER JOIN ps_auths p ON u.ext = p.id WHERE p.id=? AND p.password=?", username, password)
 	defer results.Close()
 
-	if results.Next() {
-		var result db.QueryResult
-		if err := results.Scan(&result.Param1, &result.Param2); err != nil {
-			failedMsg = "Login failed: " + err.Error()
-			return nil, failedMsg
-		}
-
-		params := map[string]string{"Queue": "SalesQueue", "Interface": "PJSIP/" + username}
-		success := a.SendActionToManager("QueueAdd", params)
-
-		if success {
-			agent.Id = result.Param1.String
-			agent.Name = result.Param2.String
-			agent.Conversations = 0
-		} else {
-			failedMsg = "Login failed"
-		}
+	if !results.Next() {
+		return nil, nil
+	}
+
+	var result db.QueryResult
+	if err := results.Scan(&result.Param1, &result.Param2); err != nil {
+		return nil, err
 	}
 
-	return &agent, failedMsg
+	return &result, nil
 }
 
 func (a *AsteriskAuthenticator) Logout(id string) bool {
 
-	params := map[string]string{"Queue": "SalesQueue", "Interface": "PJSIP/" + id}
-	success := a.SendActionToManager("QueueRemove", params)
-	return success
+	return a.leaveVoiceQueues(id)
 }
 
 func (a *AsteriskAuthenticator) Disconnect() {
-	a.AMIClient.Close()
+	if a.ami != nil {
+		a.ami.Stop()
+	}
 }
 
+// ConnectToManager starts the AMI connection in the background, the server keeps running while Asterisk is unreachable
 func (a *AsteriskAuthenticator) ConnectToManager() {
 
-	var err error
-	a.AMIClient, err = gami.Dial(AST_SERVER_IP + ":" + AST_PORT)
-	if err != nil {
-		log.Fatal("AMI Connection failed", err.Error())
+	a.ami = NewAMIConnection(AST_SERVER_IP+":"+AST_PORT, AMI_USER, AMI_PASSWORD)
+	a.ami.MinRetryDelay = AMI_RECONNECT_MIN_DELAY
+	a.ami.MaxRetryDelay = AMI_RECONNECT_MAX_DELAY
+	a.ami.OnEvent = a.handleAMIEvent
+	a.ami.OnConnected = a.managerConnected
+	a.ami.Start()
+}
+
+// managerConnected forgets the calls and phone states the lost connection can not report the end of anymore,
+// and puts the logged in agents back into their voice queues in case Asterisk restarted
+func (a *AsteriskAuthenticator) managerConnected() {
+
+	a.resetPhoneStates()
+	a.resetCalls()
+
+	// not on the connection's goroutine, it has to read the responses
+	go a.restoreVoiceQueues()
+}
+
+func (a *AsteriskAuthenticator) CheckHealth() (bool, string) {
+
+	if a.ami == nil {
+		return false, "AMI is not connected"
+	}
+
+	state, lastError, nextRetry := a.ami.State()
+	switch state {
+	case AMI_CONNECTED:
+		return true, "AMI is logged in as " + AMI_USER
+	case AMI_CONNECTING:
+		return false, "AMI is connecting"
 	}
 
-	a.AMIClient.Run()
-
-	go func() {
-		for {
-			select {
-			case err := <-a.AMIClient.NetError:
-				log.Println("AMI Network Error:", err)
-				<-time.After(time.Second)
-				if err := a.AMIClient.Reconnect(); err == nil {
-					a.AMIClient.Action("Events", gami.Params{"EventMask": "on"})
-				}
-
-			case err := <-a.AMIClient.Error:
-				log.Println("AMI Error:", err)
-
-			case event := <-a.AMIClient.Events:
-				log.Println("AMI Event received: ", event)
-				//a.handleAMIEvent(event) --> handle event received from Asterisk if needed
-			}
-		}
-	}()
-
-	if err := a.AMIClient.Login(AMI_USER, AMI_PASSWORD); err != nil {
-		log.Fatal("AMI Login failed", err.Error())
+	details := "AMI is disconnected"
+	if lastError != "" {
+		details += ": " + lastError
 	}
+	if wait := time.Until(nextRetry); wait > 0 {
+		details += ", retrying in " + wait.Round(time.Second).String()
+	}
+
+	return false, details
 }
 
 func (a *AsteriskAuthenticator) SendActionToManager(action string, params map[string]string) bool {
 
-	var success bool
+	if a.ami == nil {
+		return false
+	}
 
-	if ami_response, err := a.AMIClient.Action(action, params); err != nil {
-		log.Println("AMI Action error: ", ami_response.Status)
-		success = false
-	} else {
-		log.Println("AMI Action success", ami_response)
-		success = true
+	response, err := a.ami.Action(action, params)
+	if err != nil {
+		log.Println("AMI Action error: ", err)
+		return false
 	}
 
-	return success
+	log.Println("AMI Action response", response)
+	return response.Status == "Success"
 }
@@ -0,0 +1,308 @@
+package auths
+
+import (
+	"database/sql"
+	"net"
+	"net/textproto"
+	"server/db"
+	"server/types"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPJSIPAccounts are the extensions the authenticator's lookup knows, map[extension]password
+var testPJSIPAccounts = map[string]string{
+	"1001": "secret-1001",
+	"1002": "secret-1002",
+}
+
+func lookupTestAccount(username string, password string) (*db.QueryResult, error) {
+
+	if secret, ok := testPJSIPAccounts[username]; !ok || secret != password {
+		return nil, nil
+	}
+
+	return &db.QueryResult{
+		Param1: sql.NullString{String: username, Valid: true},
+		Param2: sql.NullString{String: "Agent " + username, Valid: true},
+	}, nil
+}
+
+// newTestAsteriskAuthenticator connects an authenticator to the fake server and waits for its AMI login
+func newTestAsteriskAuthenticator(t *testing.T, server *fakeAMIServer) *AsteriskAuthenticator {
+
+	host, port, err := net.SplitHostPort(server.address)
+	if err != nil {
+		t.Fatalf("invalid fake AMI server address %s: %v", server.address, err)
+	}
+
+	AST_SERVER_IP, AST_PORT = host, port
+	AMI_USER, AMI_PASSWORD = testAMIUser, testAMISecret
+	AMI_RECONNECT_MIN_DELAY, AMI_RECONNECT_MAX_DELAY = 10*time.Millisecond, 50*time.Millisecond
+
+	a := &AsteriskAuthenticator{
+		lookup: lookupTestAccount,
+		voiceQueues: map[string][]string{
+			"DEFAULT": {"SalesQueue"},
+			"1002":    {"SalesQueue", "SupportQueue"},
+		},
+		pauseReasons: map[types.AgentState]string{types.Busy: "Busy", types.Away: "Away"},
+	}
+
+	a.ConnectToManager()
+	t.Cleanup(a.Disconnect)
+
+	server.waitForAction("Login", map[string]string{"Username": testAMIUser})
+	waitFor(t, "the AMI login", a.ami.IsConnected)
+
+	return a
+}
+
+func TestAsteriskLoginJoinsVoiceQueues(t *testing.T) {
+
+	server := newFakeAMIServer(t)
+	a := newTestAsteriskAuthenticator(t, server)
+
+	agent, roles, failedMsg := a.Login("1002", "secret-1002")
+	if failedMsg != "" {
+		t.Fatalf("Login failed: %s", failedMsg)
+	}
+	if agent.Id != "1002" || agent.Name != "Agent 1002" {
+		t.Errorf("agent = %s %q, want 1002 \"Agent 1002\"", agent.Id, agent.Name)
+	}
+	if len(roles) != 1 || roles[0] != types.RoleAgent {
+		t.Errorf("roles = %v, want [%s]", roles, types.RoleAgent)
+	}
+
+	for _, queue := range []string{"SalesQueue", "SupportQueue"} {
+		server.waitForAction("QueueAdd", map[string]string{"Queue": queue, "Interface": "PJSIP/1002", "Paused": "false"})
+	}
+}
+
+func TestAsteriskLoginRejectsWrongPassword(t *testing.T) {
+
+	server := newFakeAMIServer(t)
+	a := newTestAsteriskAuthenticator(t, server)
+
+	if agent, _, failedMsg := a.Login("1001", "wrong"); agent != nil || failedMsg == "" {
+		t.Fatalf("Login with a wrong password = %v %q, want a failure", agent, failedMsg)
+	}
+
+	for _, action := range server.receivedActions() {
+		if action.name == "QueueAdd" {
+			t.Errorf("rejected login sent %s", action)
+		}
+	}
+}
+
+func TestAsteriskLoginQueueAddFailure(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		message string
+		success bool
+	}{
+		{"unknown queue", "Unable to add interface to queue: No such queue", false},
+		{"already a member", "Unable to add interface: Already there", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			server := newFakeAMIServer(t)
+			server.replies["QueueAdd"] = func(params textproto.MIMEHeader) (string, string) {
+				return "Error", test.message
+			}
+			a := newTestAsteriskAuthenticator(t, server)
+
+			_, _, failedMsg := a.Login("1001", "secret-1001")
+			if success := failedMsg == ""; success != test.success {
+				t.Errorf("Login with QueueAdd error %q failed with %q, want success %v", test.message, failedMsg, test.success)
+			}
+		})
+	}
+}
+
+func TestAsteriskLogoutLeavesVoiceQueues(t *testing.T) {
+
+	server := newFakeAMIServer(t)
+	a := newTestAsteriskAuthenticator(t, server)
+
+	if _, _, failedMsg := a.Login("1002", "secret-1002"); failedMsg != "" {
+		t.Fatalf("Login failed: %s", failedMsg)
+	}
+
+	if !a.Logout("1002") {
+		t.Fatalf("Logout failed")
+	}
+
+	for _, queue := range []string{"SalesQueue", "SupportQueue"} {
+		server.waitForAction("QueueRemove", map[string]string{"Queue": queue, "Interface": "PJSIP/1002"})
+	}
+}
+
+func TestAMIReconnectsAndRestoresVoiceQueues(t *testing.T) {
+
+	server := newFakeAMIServer(t)
+	a := newTestAsteriskAuthenticator(t, server)
+
+	if _, _, failedMsg := a.Login("1001", "secret-1001"); failedMsg != "" {
+		t.Fatalf("Login failed: %s", failedMsg)
+	}
+	if !a.SetVoiceAvailability("1001", types.Away) {
+		t.Fatalf("SetVoiceAvailability failed")
+	}
+	server.waitForAction("QueuePause", map[string]string{"Interface": "PJSIP/1001", "Paused": "true", "Reason": "Away"})
+
+	server.dropConnections()
+
+	server.waitForAction("Login", map[string]string{"Username": testAMIUser})
+	server.waitForAction("QueueAdd", map[string]string{"Queue": "SalesQueue", "Interface": "PJSIP/1001"})
+	server.waitForAction("QueuePause", map[string]string{"Interface": "PJSIP/1001", "Paused": "true", "Reason": "Away"})
+
+	if healthy, details := a.CheckHealth(); !healthy {
+		t.Errorf("CheckHealth after reconnect = %v %q, want healthy", healthy, details)
+	}
+}
+
+func TestAMIQueuesActionsWhileDisconnected(t *testing.T) {
+
+	server := newFakeAMIServer(t)
+	a := newTestAsteriskAuthenticator(t, server)
+
+	if _, _, failedMsg := a.Login("1001", "secret-1001"); failedMsg != "" {
+		t.Fatalf("Login failed: %s", failedMsg)
+	}
+
+	server.stop()
+	waitFor(t, "the AMI connection loss", func() bool { return !a.ami.IsConnected() })
+
+	if healthy, details := a.CheckHealth(); healthy || !strings.HasPrefix(details, "AMI is") {
+		t.Errorf("CheckHealth while disconnected = %v %q, want unhealthy", healthy, details)
+	}
+	if _, failedMsg := a.CallNumber("1001", "+5511999990000", "Customer"); failedMsg != "Phone system is unavailable" {
+		t.Errorf("CallNumber while disconnected failed with %q, want the phone system unavailable", failedMsg)
+	}
+	if !a.Logout("1001") {
+		t.Errorf("Logout while disconnected failed, want the QueueRemove queued")
+	}
+
+	server.receivedActions()
+	server.start()
+
+	server.waitForAction("Login", map[string]string{"Username": testAMIUser})
+	server.waitForAction("QueueRemove", map[string]string{"Queue": "SalesQueue", "Interface": "PJSIP/1001"})
+	waitFor(t, "the AMI reconnect", a.ami.IsConnected)
+
+	for _, action := range server.receivedActions() {
+		if action.name == "QueueAdd" {
+			t.Errorf("reconnect restored the logged out agent with %s", action)
+		}
+	}
+}
+
+func TestAMIEventsReportPhoneState(t *testing.T) {
+
+	var states []types.PhoneState
+	var mutex sync.Mutex
+
+	server := newFakeAMIServer(t)
+	a := newTestAsteriskAuthenticator(t, server)
+	a.OnPhoneStateChanged = func(id string, state types.PhoneState) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if id == "1001" {
+			states = append(states, state)
+		}
+	}
+
+	lastState := func(want types.PhoneState) func() bool {
+		return func() bool {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			return len(states) > 0 && states[len(states)-1] == want
+		}
+	}
+
+	server.emit("Newstate", map[string]string{"Channel": "PJSIP/1001-00000001", "ChannelState": "5", "Uniqueid": "1700000000.1"})
+	waitFor(t, "the ringing phone state", lastState(types.PhoneRinging))
+
+	server.emit("Newstate", map[string]string{"Channel": "PJSIP/1001-00000001", "ChannelState": "6", "Uniqueid": "1700000000.1"})
+	waitFor(t, "the in call phone state", lastState(types.PhoneInCall))
+
+	if state := a.GetPhoneState("1001"); state != types.PhoneInCall {
+		t.Errorf("GetPhoneState during the call = %v, want %v", state, types.PhoneInCall)
+	}
+
+	server.emit("Hangup", map[string]string{"Channel": "PJSIP/1001-00000001", "Uniqueid": "1700000000.1", "Cause": "16"})
+	waitFor(t, "the idle phone state", lastState(types.PhoneIdle))
+}
+
+func TestCallNumberValidatesNumber(t *testing.T) {
+
+	tests := []struct {
+		number    string
+		failedMsg string
+	}{
+		{"+447700900123", "Phone system is unavailable"},
+		{"+38761123456", "Phone system is unavailable"},
+		{"061123456", "Phone system is unavailable"},
+		{"whatsapp:+447700900123", "Invalid phone number whatsapp:+447700900123"},
+		{"1001&Exten=9999", "Invalid phone number 1001&Exten=9999"},
+	}
+
+	// not connected, so a valid number fails only for the missing AMI connection
+	a := &AsteriskAuthenticator{}
+
+	for _, test := range tests {
+		if _, failedMsg := a.CallNumber("1001", test.number, "Customer"); failedMsg != test.failedMsg {
+			t.Errorf("CallNumber(%q) failed with %q, want %q", test.number, failedMsg, test.failedMsg)
+		}
+	}
+}
+
+func TestLookupPJSIPAccount(t *testing.T) {
+
+	resetTestAgentsDB(t, nil)
+	testAgentsDB.mutex.Lock()
+	testAgentsDB.pjsipAccounts = map[string]string{"1001": "secret-1001"}
+	testAgentsDB.mutex.Unlock()
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		found    bool
+	}{
+		{"valid credentials", "1001", "secret-1001", true},
+		{"wrong password", "1001", "secret-1002", false},
+		{"injected password", "1001", "' OR '1'='1", false},
+		{"injected username", "1001' -- ", "anything", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			result, err := lookupPJSIPAccount(test.username, test.password)
+			if err != nil {
+				t.Fatalf("lookupPJSIPAccount(%q) failed: %v", test.username, err)
+			}
+
+			if found := result != nil; found != test.found {
+				t.Errorf("lookupPJSIPAccount(%q, %q) found %v, want %v", test.username, test.password, found, test.found)
+			} else if found && (result.Param1.String != test.username || result.Param2.String != "Agent "+test.username) {
+				t.Errorf("lookupPJSIPAccount(%q) = %s %q", test.username, result.Param1.String, result.Param2.String)
+			}
+		})
+	}
+
+	for _, query := range testAgentsDB.queries {
+		if strings.Contains(query, "'") {
+			t.Errorf("query %q has a value in its text, want only placeholders", query)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package auths
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bit4bit/gami"
+	"github.com/google/uuid"
+)
+
+// progress of a call originated with CallNumber
+const (
+	CALL_AGENT_RINGING    = "agent_ringing"
+	CALL_AGENT_ANSWERED   = "agent_answered"
+	CALL_CUSTOMER_RINGING = "customer_ringing"
+	CALL_CUSTOMER_ANSWER  = "customer_answered"
+	CALL_FAILED           = "failed"
+	CALL_ENDED            = "ended"
+)
+
+var phoneNumberPattern = regexp.MustCompile(`^\+?[0-9]+$`)
+
+// CallNumber rings the agent's extension and, once answered, dials the number through CALL_CONTEXT.
+// The agent's channel gets the returned call id as its unique id, so the AMI events of the call can be told apart.
+func (a *AsteriskAuthenticator) CallNumber(id string, number string, callerName string) (string, string) {
+
+	if !phoneNumberPattern.MatchString(number) {
+		return "", "Invalid phone number " + number
+	}
+
+	if a.ami == nil || !a.ami.IsConnected() {
+		return "", "Phone system is unavailable"
+	}
+
+	callID := uuid.New().String()
+
+	a.callsMutex.Lock()
+	if a.calls == nil {
+		a.calls = make(map[string]bool)
+	}
+	a.calls[callID] = true
+	a.callsMutex.Unlock()
+
+	params := gami.Params{
+		"Channel":   "PJSIP/" + id,
+		"Context":   AST_CALL_CONTEXT,
+		"Exten":     AST_CALL_DIAL_PREFIX + strings.TrimPrefix(number, "+"),
+		"Priority":  "1",
+		"Timeout":   strconv.Itoa(AST_CALL_TIMEOUT * 1000),
+		"CallerID":  strings.NewReplacer("\r", "", "\n", "", "\"", "").Replace(callerName) + " <" + number + ">",
+		"ChannelId": callID,
+		"Async":     "false",
+	}
+
+	// a synchronous Originate answers once the agent picked up or the call failed, an async one would
+	// send an OriginateResponse event gami mistakes for the response of an action it already forgot
+	go func() {
+		response, err := a.ami.ActionWithTimeout("Originate", params, time.Duration(AST_CALL_TIMEOUT)*time.Second+AMI_ACTION_TIMEOUT)
+		if err != nil && a.endCall(callID) {
+			a.callProgress(callID, CALL_FAILED, err.Error())
+		} else if err == nil && response.Status != "Success" && a.endCall(callID) {
+			a.callProgress(callID, CALL_FAILED, response.Params["Message"])
+		}
+	}()
+
+	log.Println("Originating call ", callID, " from extension ", id, " to ", number)
+
+	return callID, ""
+}
+
+// handleCallEvent reports the progress of the calls started with CallNumber
+func (a *AsteriskAuthenticator) handleCallEvent(amiEvent *gami.AMIEvent) {
+
+	callID := amiEvent.Params["Uniqueid"]
+
+	a.callsMutex.Lock()
+	isCall := a.calls[callID]
+	a.callsMutex.Unlock()
+
+	if !isCall {
+		return
+	}
+
+	switch amiEvent.ID {
+
+	case "Newstate":
+		switch amiEvent.Params["Channelstate"] {
+		case AST_STATE_RING, AST_STATE_RINGING:
+			a.callProgress(callID, CALL_AGENT_RINGING, "")
+		case AST_STATE_UP:
+			a.callProgress(callID, CALL_AGENT_ANSWERED, "")
+		}
+
+	case "DialBegin":
+		a.callProgress(callID, CALL_CUSTOMER_RINGING, "")
+
+	case "DialEnd":
+		if status := amiEvent.Params["Dialstatus"]; status == "ANSWER" {
+			a.callProgress(callID, CALL_CUSTOMER_ANSWER, "")
+		} else {
+			a.callProgress(callID, CALL_FAILED, strings.ToLower(status))
+		}
+
+	case "Hangup":
+		if a.endCall(callID) {
+			a.callProgress(callID, CALL_ENDED, amiEvent.Params["Cause-Txt"])
+		}
+	}
+}
+
+// resetCalls ends the calls in progress when the AMI connection was lost
+func (a *AsteriskAuthenticator) resetCalls() {
+
+	a.callsMutex.Lock()
+	calls := a.calls
+	a.calls = make(map[string]bool)
+	a.callsMutex.Unlock()
+
+	for callID := range calls {
+		a.callProgress(callID, CALL_FAILED, "connection to the phone system was lost")
+	}
+}
+
+// endCall forgets the call and reports whether it was still in progress
+func (a *AsteriskAuthenticator) endCall(callID string) bool {
+
+	a.callsMutex.Lock()
+	defer a.callsMutex.Unlock()
+
+	inProgress := a.calls[callID]
+	delete(a.calls, callID)
+
+	return inProgress
+}
+
+func (a *AsteriskAuthenticator) callProgress(callID string, progress string, details string) {
+
+	log.Println("Call ", callID, " progress ", progress, " ", details)
+
+	if a.OnCallProgress != nil {
+		a.OnCallProgress(callID, progress, details)
+	}
+}
@@ -0,0 +1,168 @@
+package auths
+
+import (
+	"log"
+	"server/types"
+	"strings"
+
+	"github.com/bit4bit/gami"
+	"github.com/bit4bit/gami/event"
+)
+
+// AMI channel states, see ast_state_type in Asterisk
+const (
+	AST_STATE_DIALING = "3"
+	AST_STATE_RING    = "4"
+	AST_STATE_RINGING = "5"
+	AST_STATE_UP      = "6"
+)
+
+// phoneChannel is a call leg of an agent's extension
+type phoneChannel struct {
+	extension string
+	state     types.PhoneState
+}
+
+// handleAMIEvent tracks the call legs of the agents' extensions and reports every change of an extension's phone state
+func (a *AsteriskAuthenticator) handleAMIEvent(amiEvent *gami.AMIEvent) {
+
+	a.handleCallEvent(amiEvent)
+	a.handleQueueEvent(amiEvent)
+
+	switch e := event.New(amiEvent).(type) {
+
+	case event.Newstate:
+		state, ok := phoneStateOf(e.ChannelState)
+		if extension := extensionOf(e.Channel); ok && extension != "" {
+			a.setPhoneChannel(e.UniqueID, extension, state)
+		}
+
+	case event.AgentConnect:
+		// the queue caller's unique id, its hangup ends the call for the member too
+		if extension := extensionOf(e.Member); extension != "" {
+			a.setPhoneChannel(e.UniqueID, extension, types.PhoneInCall)
+		}
+
+	case event.Hangup:
+		a.removePhoneChannel(e.UniqueID)
+	}
+}
+
+func phoneStateOf(channelState string) (types.PhoneState, bool) {
+
+	switch channelState {
+	case AST_STATE_DIALING, AST_STATE_RING, AST_STATE_RINGING:
+		return types.PhoneRinging, true
+	case AST_STATE_UP:
+		return types.PhoneInCall, true
+	}
+
+	return types.PhoneIdle, false
+}
+
+// extensionOf returns the extension of a PJSIP or SIP channel or interface, e.g. 1001 for PJSIP/1001-0000001a
+func extensionOf(channel string) string {
+
+	parts := strings.SplitN(channel, "/", 2)
+	if len(parts) != 2 || (parts[0] != "PJSIP" && parts[0] != "SIP") {
+		return ""
+	}
+
+	if i := strings.LastIndex(parts[1], "-"); i > 0 {
+		return parts[1][:i]
+	}
+
+	return parts[1]
+}
+
+func (a *AsteriskAuthenticator) setPhoneChannel(uniqueID string, extension string, state types.PhoneState) {
+
+	a.phoneMutex.Lock()
+	if a.phoneChannels == nil {
+		a.phoneChannels = make(map[string]phoneChannel)
+	}
+	a.phoneChannels[uniqueID] = phoneChannel{extension: extension, state: state}
+	changed, extensionState := a.updatePhoneState(extension)
+	a.phoneMutex.Unlock()
+
+	if changed {
+		a.notifyPhoneState(extension, extensionState)
+	}
+}
+
+func (a *AsteriskAuthenticator) removePhoneChannel(uniqueID string) {
+
+	a.phoneMutex.Lock()
+	channel, ok := a.phoneChannels[uniqueID]
+	delete(a.phoneChannels, uniqueID)
+	changed, extensionState := false, types.PhoneIdle
+	if ok {
+		changed, extensionState = a.updatePhoneState(channel.extension)
+	}
+	a.phoneMutex.Unlock()
+
+	if changed {
+		a.notifyPhoneState(channel.extension, extensionState)
+	}
+}
+
+// updatePhoneState sets the extension's state to the busiest of its call legs, the caller holds phoneMutex
+func (a *AsteriskAuthenticator) updatePhoneState(extension string) (bool, types.PhoneState) {
+
+	state := types.PhoneIdle
+	for _, channel := range a.phoneChannels {
+		if channel.extension == extension && channel.state > state {
+			state = channel.state
+		}
+	}
+
+	if a.phoneStates == nil {
+		a.phoneStates = make(map[string]types.PhoneState)
+	}
+	if a.phoneStates[extension] == state {
+		return false, state
+	}
+
+	if state == types.PhoneIdle {
+		delete(a.phoneStates, extension)
+	} else {
+		a.phoneStates[extension] = state
+	}
+
+	return true, state
+}
+
+func (a *AsteriskAuthenticator) notifyPhoneState(extension string, state types.PhoneState) {
+
+	log.Println("Phone state of extension ", extension, " changed to ", state)
+
+	if a.OnPhoneStateChanged != nil {
+		a.OnPhoneStateChanged(extension, state)
+	}
+}
+
+// resetPhoneStates makes every extension idle again
+func (a *AsteriskAuthenticator) resetPhoneStates() {
+
+	a.phoneMutex.Lock()
+	extensions := make([]string, 0, len(a.phoneStates))
+	for extension := range a.phoneStates {
+		extensions = append(extensions, extension)
+	}
+	a.phoneChannels = make(map[string]phoneChannel)
+	a.phoneStates = make(map[string]types.PhoneState)
+	a.phoneMutex.Unlock()
+
+	for _, extension := range extensions {
+		a.notifyPhoneState(extension, types.PhoneIdle)
+	}
+}
+
+// GetPhoneState returns the current phone state of the agent's extension
+func (a *AsteriskAuthenticator) GetPhoneState(id string) types.PhoneState {
+
+	a.phoneMutex.Lock()
+	defer a.phoneMutex.Unlock()
+
+	return a.phoneStates[id]
+}
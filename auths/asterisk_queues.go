@@ -0,0 +1,172 @@
+package auths
+
+import (
+	"log"
+	"server/types"
+	"strings"
+
+	"github.com/bit4bit/gami"
+	"github.com/go-ini/ini"
+)
+
+// loadVoiceQueues reads the voice queues of the agents and the pause reasons of the chat presence states
+func (a *AsteriskAuthenticator) loadVoiceQueues(cfg *ini.File) {
+
+	a.voiceQueues = make(map[string][]string)
+	for _, key := range cfg.Section("voice-queues").Keys() {
+		a.voiceQueues[key.Name()] = key.Strings(",")
+	}
+	if _, ok := a.voiceQueues["DEFAULT"]; !ok {
+		a.voiceQueues["DEFAULT"] = []string{"SalesQueue"}
+	}
+
+	section := cfg.Section("asterisk-authenticator")
+	a.pauseReasons = map[types.AgentState]string{
+		types.Busy: section.Key("PAUSE_REASON_BUSY").MustString("Busy"),
+		types.Away: section.Key("PAUSE_REASON_AWAY").MustString("Away"),
+	}
+}
+
+// agentVoiceQueues returns the queues configured for the agent, or the DEFAULT ones
+func (a *AsteriskAuthenticator) agentVoiceQueues(id string) []string {
+
+	if queues, ok := a.voiceQueues[id]; ok {
+		return queues
+	}
+
+	return a.voiceQueues["DEFAULT"]
+}
+
+// queueAction sends a queue action for the agent's interface, or queues it until AMI is connected again.
+// The member already being in the wanted state is no failure.
+func (a *AsteriskAuthenticator) queueAction(key string, action string, params gami.Params) bool {
+
+	if a.ami == nil {
+		return false
+	}
+
+	response, success := a.ami.QueueAction(key, action, params)
+	if !success {
+		return false
+	}
+
+	if response != nil && response.Status != "Success" {
+		message := response.Params["Message"]
+		log.Println("AMI Action ", action, " ", params, " failed: ", message)
+		return strings.Contains(message, "Already there") || strings.Contains(message, "Not there")
+	}
+
+	return true
+}
+
+func (a *AsteriskAuthenticator) joinVoiceQueues(id string) bool {
+
+	a.membersMutex.Lock()
+	if a.members == nil {
+		a.members = make(map[string]types.AgentState)
+	}
+	a.members[id] = types.Available
+	a.membersMutex.Unlock()
+
+	success := true
+	for _, queue := range a.agentVoiceQueues(id) {
+		params := gami.Params{"Queue": queue, "Interface": "PJSIP/" + id, "Paused": "false"}
+		success = a.queueAction("member:"+queue+":"+id, "QueueAdd", params) && success
+	}
+
+	return success
+}
+
+func (a *AsteriskAuthenticator) leaveVoiceQueues(id string) bool {
+
+	a.membersMutex.Lock()
+	delete(a.members, id)
+	a.membersMutex.Unlock()
+
+	success := true
+	for _, queue := range a.agentVoiceQueues(id) {
+		params := gami.Params{"Queue": queue, "Interface": "PJSIP/" + id}
+		success = a.queueAction("member:"+queue+":"+id, "QueueRemove", params) && success
+	}
+
+	return success
+}
+
+// SetVoiceAvailability pauses the agent in its voice queues while it is busy or away in chat, with the state's reason
+func (a *AsteriskAuthenticator) SetVoiceAvailability(id string, state types.AgentState) bool {
+
+	if state == types.Offline {
+		return true
+	}
+
+	a.membersMutex.Lock()
+	if _, ok := a.members[id]; ok {
+		a.members[id] = state
+	}
+	a.membersMutex.Unlock()
+
+	success := true
+	for _, queue := range a.agentVoiceQueues(id) {
+		// a new map per action, gami stores the ActionID in it
+		params := gami.Params{"Queue": queue, "Interface": "PJSIP/" + id, "Paused": "false"}
+		if state != types.Available {
+			params["Paused"] = "true"
+			params["Reason"] = a.pauseReasons[state]
+		}
+		success = a.queueAction("pause:"+queue+":"+id, "QueuePause", params) && success
+	}
+
+	return success
+}
+
+// restoreVoiceQueues adds the logged in agents to their voice queues again, with the pause of their chat state
+func (a *AsteriskAuthenticator) restoreVoiceQueues() {
+
+	a.membersMutex.Lock()
+	members := make(map[string]types.AgentState, len(a.members))
+	for id, state := range a.members {
+		members[id] = state
+	}
+	a.membersMutex.Unlock()
+
+	for id, state := range members {
+		a.joinVoiceQueues(id)
+		if state != types.Available {
+			a.SetVoiceAvailability(id, state)
+		}
+	}
+}
+
+// handleQueueEvent reports pauses and unpauses made from the phone or the Asterisk side as chat presence states
+func (a *AsteriskAuthenticator) handleQueueEvent(amiEvent *gami.AMIEvent) {
+
+	// QueueMemberPause since Asterisk 12, QueueMemberPaused before
+	if amiEvent.ID != "QueueMemberPause" && amiEvent.ID != "QueueMemberPaused" {
+		return
+	}
+
+	memberInterface := amiEvent.Params["Interface"]
+	if memberInterface == "" {
+		memberInterface = amiEvent.Params["Location"]
+	}
+
+	extension := extensionOf(memberInterface)
+	if extension == "" || a.OnVoiceAvailabilityChanged == nil {
+		return
+	}
+
+	state := types.Available
+	if amiEvent.Params["Paused"] == "1" {
+		reason := amiEvent.Params["Pausedreason"]
+		if reason == "" {
+			reason = amiEvent.Params["Reason"]
+		}
+
+		state = types.Away
+		if reason == a.pauseReasons[types.Busy] {
+			state = types.Busy
+		}
+	}
+
+	a.OnVoiceAvailabilityChanged(extension, state)
+}
@@ -0,0 +1,211 @@
+package auths
+
+import (
+	"server/types"
+	"strings"
+	"sync"
+)
+
+// ChainAuthenticator tries its authenticators in order and stops at the first one that accepts the agent.
+// It remembers which one that was, so the agent's logout goes to the same backend.
+type ChainAuthenticator struct {
+	names          []string
+	authenticators []LoginAuthenticator
+	backends       map[string]int //map[agentID]index of the authenticator
+	mutex          sync.Mutex
+}
+
+func NewChainAuthenticator(names []string, authenticators []LoginAuthenticator) *ChainAuthenticator {
+	return &ChainAuthenticator{names: names, authenticators: authenticators, backends: make(map[string]int)}
+}
+
+func (c *ChainAuthenticator) Init() {
+	for _, authenticator := range c.authenticators {
+		authenticator.Init()
+	}
+}
+
+func (c *ChainAuthenticator) Login(username string, password string) (*types.Agent, []types.AgentRole, string) {
+
+	failedMsg := "Login failed"
+
+	for i, authenticator := range c.authenticators {
+
+		agent, roles, msg := authenticator.Login(username, password)
+		if agent != nil && agent.Id != "" && msg == "" {
+			agent.AuthBackend = c.names[i]
+
+			c.mutex.Lock()
+			c.backends[agent.Id] = i
+			c.mutex.Unlock()
+
+			return agent, roles, ""
+		}
+
+		if msg != "" {
+			failedMsg = msg
+		}
+	}
+
+	return nil, nil, failedMsg
+}
+
+func (c *ChainAuthenticator) Logout(id string) bool {
+
+	c.mutex.Lock()
+	index, ok := c.backends[id]
+	c.mutex.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	success := c.authenticators[index].Logout(id)
+	if success {
+		c.mutex.Lock()
+		delete(c.backends, id)
+		c.mutex.Unlock()
+	}
+
+	return success
+}
+
+func (c *ChainAuthenticator) Disconnect() {
+	for _, authenticator := range c.authenticators {
+		authenticator.Disconnect()
+	}
+}
+
+// GetBackend returns the name of the authenticator that logged the agent in
+func (c *ChainAuthenticator) GetBackend(id string) string {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if index, ok := c.backends[id]; ok {
+		return c.names[index]
+	}
+
+	return ""
+}
+
+// HasBackend reports whether the authenticator with the name is part of the chain
+func (c *ChainAuthenticator) HasBackend(name string) bool {
+	for _, backend := range c.names {
+		if backend == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckHealth reports the health of every authenticator in the chain that depends on an external service
+func (c *ChainAuthenticator) CheckHealth() (bool, string) {
+
+	healthy := true
+	var details []string
+
+	for i, authenticator := range c.authenticators {
+		if checker, ok := authenticator.(HealthChecker); ok {
+			backendHealthy, backendDetails := checker.CheckHealth()
+			healthy = healthy && backendHealthy
+			details = append(details, c.names[i]+": "+backendDetails)
+		}
+	}
+
+	return healthy, strings.Join(details, "; ")
+}
+
+// GetPhoneState asks the authenticator that logged the agent in, agents of other backends have no phone
+func (c *ChainAuthenticator) GetPhoneState(id string) types.PhoneState {
+
+	c.mutex.Lock()
+	index, ok := c.backends[id]
+	c.mutex.Unlock()
+
+	if !ok {
+		return types.PhoneIdle
+	}
+
+	if provider, isProvider := c.authenticators[index].(PhoneStateProvider); isProvider {
+		return provider.GetPhoneState(id)
+	}
+
+	return types.PhoneIdle
+}
+
+// CallNumber places the call through the authenticator that logged the agent in
+func (c *ChainAuthenticator) CallNumber(id string, number string, callerName string) (string, string) {
+
+	c.mutex.Lock()
+	index, ok := c.backends[id]
+	c.mutex.Unlock()
+
+	if ok {
+		if dialer, isDialer := c.authenticators[index].(Dialer); isDialer {
+			return dialer.CallNumber(id, number, callerName)
+		}
+	}
+
+	return "", "Agent has no phone extension"
+}
+
+// SetVoiceAvailability pauses or unpauses the agent in the voice queues of the authenticator that logged it in
+func (c *ChainAuthenticator) SetVoiceAvailability(id string, state types.AgentState) bool {
+
+	c.mutex.Lock()
+	index, ok := c.backends[id]
+	c.mutex.Unlock()
+
+	if ok {
+		if member, isMember := c.authenticators[index].(VoiceQueueMember); isMember {
+			return member.SetVoiceAvailability(id, state)
+		}
+	}
+
+	return true
+}
+
+// directory returns the first authenticator in the chain that manages its own agent accounts
+func (c *ChainAuthenticator) directory() AgentDirectory {
+	for _, authenticator := range c.authenticators {
+		if directory, ok := authenticator.(AgentDirectory); ok {
+			return directory
+		}
+	}
+
+	return nil
+}
+
+func (c *ChainAuthenticator) CreateAgent(id string, name string, password string, roles []types.AgentRole) string {
+	if directory := c.directory(); directory != nil {
+		return directory.CreateAgent(id, name, password, roles)
+	}
+
+	return "Login authenticator does not manage agent accounts"
+}
+
+func (c *ChainAuthenticator) SetAgentDisabled(id string, disabled bool) string {
+	if directory := c.directory(); directory != nil {
+		return directory.SetAgentDisabled(id, disabled)
+	}
+
+	return "Login authenticator does not manage agent accounts"
+}
+
+func (c *ChainAuthenticator) SetAgentRoles(id string, roles []types.AgentRole) string {
+	if directory := c.directory(); directory != nil {
+		return directory.SetAgentRoles(id, roles)
+	}
+
+	return "Login authenticator does not manage agent accounts"
+}
+
+func (c *ChainAuthenticator) ResetPassword(id string, password string) string {
+	if directory := c.directory(); directory != nil {
+		return directory.ResetPassword(id, password)
+	}
+
+	return "Login authenticator does not manage agent accounts"
+}
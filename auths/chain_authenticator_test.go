@@ -0,0 +1,177 @@
+package auths
+
+import (
+	"reflect"
+	"server/types"
+	"testing"
+)
+
+// stubAuthenticator accepts the agents of its password map and records what the chain asks of it
+type stubAuthenticator struct {
+	passwords map[string]string //map[agentID]password
+	logins    []string
+	logouts   []string
+}
+
+func (s *stubAuthenticator) Init() {}
+
+func (s *stubAuthenticator) Login(username string, password string) (*types.Agent, []types.AgentRole, string) {
+
+	s.logins = append(s.logins, username)
+
+	if secret, ok := s.passwords[username]; !ok || secret != password {
+		return nil, nil, "Wrong password"
+	}
+
+	return &types.Agent{Id: username, Name: "Agent " + username}, []types.AgentRole{types.RoleAgent}, ""
+}
+
+func (s *stubAuthenticator) Logout(id string) bool {
+	s.logouts = append(s.logouts, id)
+	return true
+}
+
+func (s *stubAuthenticator) Disconnect() {}
+
+// stubPhoneAuthenticator also has phones, like the asterisk backend
+type stubPhoneAuthenticator struct {
+	stubAuthenticator
+	calls       []string
+	voiceStates map[string]types.AgentState
+	phoneStates map[string]types.PhoneState
+}
+
+func (s *stubPhoneAuthenticator) CallNumber(id string, number string, callerName string) (string, string) {
+	s.calls = append(s.calls, id+" "+number)
+	return "call-" + id, ""
+}
+
+func (s *stubPhoneAuthenticator) SetVoiceAvailability(id string, state types.AgentState) bool {
+	s.voiceStates[id] = state
+	return true
+}
+
+func (s *stubPhoneAuthenticator) GetPhoneState(id string) types.PhoneState {
+	return s.phoneStates[id]
+}
+
+// newTestChain chains a local-like authenticator with 1001 and 1002, then a phone one with 1002 and 1003
+func newTestChain() (*ChainAuthenticator, *stubAuthenticator, *stubPhoneAuthenticator) {
+
+	local := &stubAuthenticator{passwords: map[string]string{"1001": "local-1001", "1002": "local-1002"}}
+	phone := &stubPhoneAuthenticator{
+		stubAuthenticator: stubAuthenticator{passwords: map[string]string{"1002": "phone-1002", "1003": "phone-1003"}},
+		voiceStates:       make(map[string]types.AgentState),
+		phoneStates:       map[string]types.PhoneState{"1003": types.PhoneInCall},
+	}
+
+	return NewChainAuthenticator([]string{"local", "asterisk"}, []LoginAuthenticator{local, phone}), local, phone
+}
+
+func TestChainLoginOrder(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		backend  string
+		asked    []string
+	}{
+		{"first backend accepts", "1001", "local-1001", "local", []string{"local"}},
+		{"falls through to the second backend", "1003", "phone-1003", "asterisk", []string{"local", "asterisk"}},
+		{"first backend wins for a shared agent", "1002", "local-1002", "local", []string{"local"}},
+		{"shared agent with the second backend's password", "1002", "phone-1002", "asterisk", []string{"local", "asterisk"}},
+		{"no backend accepts", "1001", "wrong", "", []string{"local", "asterisk"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			chain, local, phone := newTestChain()
+
+			agent, _, failedMsg := chain.Login(test.username, test.password)
+
+			if test.backend == "" {
+				if agent != nil || failedMsg != "Wrong password" {
+					t.Errorf("Login = %v %q, want the last backend's failure", agent, failedMsg)
+				}
+			} else if failedMsg != "" || agent.AuthBackend != test.backend || chain.GetBackend(test.username) != test.backend {
+				t.Errorf("Login failed with %q on backend %q, want %s", failedMsg, chain.GetBackend(test.username), test.backend)
+			}
+
+			var asked []string
+			if len(local.logins) > 0 {
+				asked = append(asked, "local")
+			}
+			if len(phone.logins) > 0 {
+				asked = append(asked, "asterisk")
+			}
+			if !reflect.DeepEqual(asked, test.asked) {
+				t.Errorf("Login asked %v, want %v", asked, test.asked)
+			}
+		})
+	}
+}
+
+func TestChainLogoutGoesToLoginBackend(t *testing.T) {
+
+	chain, local, phone := newTestChain()
+
+	if chain.Logout("1001") {
+		t.Errorf("Logout of an agent that is not logged in succeeded")
+	}
+
+	for username, password := range map[string]string{"1001": "local-1001", "1003": "phone-1003"} {
+		if _, _, failedMsg := chain.Login(username, password); failedMsg != "" {
+			t.Fatalf("Login of %s failed: %s", username, failedMsg)
+		}
+	}
+
+	if !chain.Logout("1003") || !chain.Logout("1001") {
+		t.Fatalf("Logout failed")
+	}
+
+	if !reflect.DeepEqual(local.logouts, []string{"1001"}) || !reflect.DeepEqual(phone.logouts, []string{"1003"}) {
+		t.Errorf("local logged out %v and asterisk %v, want [1001] and [1003]", local.logouts, phone.logouts)
+	}
+	if backend := chain.GetBackend("1003"); backend != "" {
+		t.Errorf("logged out agent still has the backend %q", backend)
+	}
+}
+
+func TestChainDelegatesPhoneFeatures(t *testing.T) {
+
+	chain, _, phone := newTestChain()
+
+	for username, password := range map[string]string{"1001": "local-1001", "1003": "phone-1003"} {
+		if _, _, failedMsg := chain.Login(username, password); failedMsg != "" {
+			t.Fatalf("Login of %s failed: %s", username, failedMsg)
+		}
+	}
+
+	// the agent of the phone backend
+	if callID, failedMsg := chain.CallNumber("1003", "+38761123456", "Customer"); callID != "call-1003" || failedMsg != "" {
+		t.Errorf("CallNumber = %q %q, want the phone backend's call", callID, failedMsg)
+	}
+	if !chain.SetVoiceAvailability("1003", types.Away) || phone.voiceStates["1003"] != types.Away {
+		t.Errorf("SetVoiceAvailability did not reach the phone backend, states %v", phone.voiceStates)
+	}
+	if state := chain.GetPhoneState("1003"); state != types.PhoneInCall {
+		t.Errorf("GetPhoneState = %v, want %v", state, types.PhoneInCall)
+	}
+
+	// the agent of the backend without phones
+	if _, failedMsg := chain.CallNumber("1001", "+38761123456", "Customer"); failedMsg != "Agent has no phone extension" {
+		t.Errorf("CallNumber of a local agent failed with %q, want no phone extension", failedMsg)
+	}
+	if !chain.SetVoiceAvailability("1001", types.Away) {
+		t.Errorf("SetVoiceAvailability of a local agent failed, want nothing to do")
+	}
+	if state := chain.GetPhoneState("1001"); state != types.PhoneIdle {
+		t.Errorf("GetPhoneState of a local agent = %v, want %v", state, types.PhoneIdle)
+	}
+
+	if len(phone.calls) != 1 || len(phone.voiceStates) != 1 {
+		t.Errorf("phone backend got calls %v and states %v, want only the agent 1003's", phone.calls, phone.voiceStates)
+	}
+}
@@ -0,0 +1,245 @@
+package auths
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/textproto"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAMIUser   = "omnichannel"
+	testAMISecret = "ami-secret"
+	testTimeout   = 5 * time.Second
+)
+
+// amiAction is an action the fake server received
+type amiAction struct {
+	name   string
+	params textproto.MIMEHeader
+}
+
+// amiReply scripts the response to an action, an empty status is a Success
+type amiReply func(params textproto.MIMEHeader) (status string, message string)
+
+// fakeAMIServer is an in-process stand-in for the Asterisk Manager Interface. It speaks the line based
+// Manager protocol gami expects, checks the Login, answers the other actions as scripted in replies
+// and sends events to every connected client.
+type fakeAMIServer struct {
+	t        *testing.T
+	address  string
+	listener net.Listener
+	replies  map[string]amiReply //map[action]reply
+	actions  chan amiAction
+	conns    []net.Conn
+	mutex    sync.Mutex
+}
+
+func newFakeAMIServer(t *testing.T) *fakeAMIServer {
+
+	server := &fakeAMIServer{t: t, address: "127.0.0.1:0", replies: make(map[string]amiReply), actions: make(chan amiAction, 1000)}
+	server.start()
+	t.Cleanup(server.stop)
+
+	return server
+}
+
+// start listens on the server's address, again on the same port after a stop
+func (s *fakeAMIServer) start() {
+
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		s.t.Fatalf("fake AMI server failed to listen: %v", err)
+	}
+
+	s.mutex.Lock()
+	s.listener = listener
+	s.address = listener.Addr().String()
+	s.mutex.Unlock()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			s.mutex.Lock()
+			s.conns = append(s.conns, conn)
+			s.mutex.Unlock()
+
+			go s.serve(conn)
+		}
+	}()
+}
+
+// stop closes the listener and every connection, like an Asterisk that went down
+func (s *fakeAMIServer) stop() {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+// dropConnections closes the connections but keeps listening, like a network failure
+func (s *fakeAMIServer) dropConnections() {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeAMIServer) serve(conn net.Conn) {
+
+	defer conn.Close()
+
+	s.write(conn, "Asterisk Call Manager/5.0.1\r\n")
+
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	loggedIn := false
+
+	for {
+		params, err := reader.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+
+		action := amiAction{name: params.Get("Action"), params: params}
+		status, message := "Success", ""
+
+		switch {
+		case action.name == "Login":
+			loggedIn = params.Get("Username") == testAMIUser && params.Get("Secret") == testAMISecret
+			if !loggedIn {
+				status, message = "Error", "Authentication failed"
+			}
+		case action.name == "Logoff":
+			status, message = "Goodbye", "Thanks for all the fish."
+		case !loggedIn:
+			status, message = "Error", "Permission denied"
+		default:
+			s.mutex.Lock()
+			reply := s.replies[action.name]
+			s.mutex.Unlock()
+
+			if reply != nil {
+				if status, message = reply(params); status == "" {
+					status = "Success"
+				}
+			}
+		}
+
+		s.actions <- action
+		s.write(conn, "Response: "+status+"\r\nActionID: "+params.Get("Actionid")+"\r\nMessage: "+message+"\r\n\r\n")
+
+		if action.name == "Logoff" {
+			return
+		}
+	}
+}
+
+func (s *fakeAMIServer) write(conn net.Conn, data string) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	conn.Write([]byte(data))
+}
+
+// emit sends an event to every connected client
+func (s *fakeAMIServer) emit(event string, params map[string]string) {
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	data := "Event: " + event + "\r\nPrivilege: call,all\r\n"
+	for _, key := range keys {
+		data += key + ": " + params[key] + "\r\n"
+	}
+	data += "\r\n"
+
+	s.mutex.Lock()
+	conns := append([]net.Conn(nil), s.conns...)
+	s.mutex.Unlock()
+
+	for _, conn := range conns {
+		s.write(conn, data)
+	}
+}
+
+// waitForAction returns the next received action with the name and params, skipping the others
+func (s *fakeAMIServer) waitForAction(name string, params map[string]string) amiAction {
+
+	timeout := time.After(testTimeout)
+
+	for {
+		select {
+		case action := <-s.actions:
+			if action.name == name && matchParams(action.params, params) {
+				return action
+			}
+		case <-timeout:
+			s.t.Fatalf("fake AMI server did not receive %s %v", name, params)
+			return amiAction{}
+		}
+	}
+}
+
+// receivedActions returns the actions received so far
+func (s *fakeAMIServer) receivedActions() []amiAction {
+
+	var actions []amiAction
+	for {
+		select {
+		case action := <-s.actions:
+			actions = append(actions, action)
+		default:
+			return actions
+		}
+	}
+}
+
+func matchParams(header textproto.MIMEHeader, params map[string]string) bool {
+	for key, value := range params {
+		if header.Get(key) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// waitFor polls the condition until it holds or the test times out
+func waitFor(t *testing.T, description string, condition func() bool) {
+
+	deadline := time.Now().Add(testTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (a amiAction) String() string {
+	return fmt.Sprintf("%s %v", a.name, a.params)
+}
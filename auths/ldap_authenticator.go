@@ -0,0 +1,203 @@
+package auths
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"server/types"
+	"strings"
+
+	"github.com/go-ini/ini"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	LDAP_SEARCH_TIMEOUT = 10 //seconds
+	LDAP_USERNAME       = "{username}"
+)
+
+// ldapConnection is the part of *ldap.Conn the authenticator uses, so it can run against a stand-in directory
+type ldapConnection interface {
+	Bind(username string, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	StartTLS(config *tls.Config) error
+	Close()
+}
+
+// LDAPAuthenticator logs agents in with their directory credentials (LDAP or Active Directory).
+// The user is looked up with the service account, then bound with its own DN and password.
+// Group membership decides who may log in and which role the agent gets.
+type LDAPAuthenticator struct {
+	url            string
+	startTLS       bool
+	tlsConfig      *tls.Config
+	bindDN         string
+	bindPassword   string
+	baseDN         string
+	userFilter     string
+	idAttribute    string
+	nameAttribute  string
+	groupAttribute string
+	agentGroups    []string
+	roleGroups     map[types.AgentRole][]string //map[role]groupDNs
+
+	dial func() (ldapConnection, error)
+}
+
+func (l *LDAPAuthenticator) Init() {
+
+	// without a configuration the dial fails and logins report the directory as unavailable
+	l.dial = func() (ldapConnection, error) {
+		return ldap.DialURL(l.url, ldap.DialWithTLSConfig(l.tlsConfig))
+	}
+
+	cfg, err := ini.Load("conf/login_auth_conf.ini")
+	if err != nil {
+		log.Println("Failed to read login_auth_conf file: ", err)
+		return
+	}
+
+	section := cfg.Section("ldap-authenticator")
+
+	l.url = section.Key("URL").String()
+	l.startTLS = section.Key("START_TLS").MustBool(false)
+	l.bindDN = section.Key("BIND_DN").String()
+	l.bindPassword = section.Key("BIND_PASSWORD").String()
+	l.baseDN = section.Key("BASE_DN").String()
+	l.userFilter = section.Key("USER_FILTER").MustString("(&(objectClass=user)(sAMAccountName=" + LDAP_USERNAME + "))")
+	l.idAttribute = section.Key("ID_ATTRIBUTE").MustString("sAMAccountName")
+	l.nameAttribute = section.Key("NAME_ATTRIBUTE").MustString("displayName")
+	l.groupAttribute = section.Key("GROUP_ATTRIBUTE").MustString("memberOf")
+	l.agentGroups = section.Key("AGENT_GROUPS").Strings(";")
+	l.roleGroups = map[types.AgentRole][]string{
+		types.RoleSupervisor: section.Key("SUPERVISOR_GROUPS").Strings(";"),
+		types.RoleAdmin:      section.Key("ADMIN_GROUPS").Strings(";"),
+	}
+
+	l.tlsConfig = &tls.Config{InsecureSkipVerify: section.Key("INSECURE_SKIP_VERIFY").MustBool(false)}
+	if serverURL, err := url.Parse(l.url); err == nil {
+		l.tlsConfig.ServerName = serverURL.Hostname()
+	}
+
+	if caCert := section.Key("CA_CERT").String(); caCert != "" {
+		if pem, err := ioutil.ReadFile(caCert); err == nil {
+			l.tlsConfig.RootCAs = x509.NewCertPool()
+			l.tlsConfig.RootCAs.AppendCertsFromPEM(pem)
+		} else {
+			log.Println("Failed to read LDAP CA certificate: ", err)
+		}
+	}
+}
+
+func (l *LDAPAuthenticator) connect() (ldapConnection, error) {
+
+	conn, err := l.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	if l.startTLS {
+		if err := conn.StartTLS(l.tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func (l *LDAPAuthenticator) Login(username string, password string) (*types.Agent, []types.AgentRole, string) {
+
+	// an empty password would be an unauthenticated bind, which most servers accept
+	if username == "" || password == "" {
+		return nil, nil, "Login failed"
+	}
+
+	conn, err := l.connect()
+	if err != nil {
+		log.Println("LDAP Connection failed: ", err)
+		return nil, nil, "Login failed: directory is unavailable"
+	}
+	defer conn.Close()
+
+	if l.bindDN != "" {
+		if err := conn.Bind(l.bindDN, l.bindPassword); err != nil {
+			log.Println("LDAP service account bind failed: ", err)
+			return nil, nil, "Login failed: directory is unavailable"
+		}
+	}
+
+	request := ldap.NewSearchRequest(l.baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, LDAP_SEARCH_TIMEOUT, false,
+		strings.ReplaceAll(l.userFilter, LDAP_USERNAME, ldap.EscapeFilter(username)),
+		[]string{l.idAttribute, l.nameAttribute, l.groupAttribute}, nil)
+
+	result, err := conn.Search(request)
+	if err != nil {
+		log.Println("LDAP Search failed: ", err)
+		return nil, nil, "Login failed"
+	}
+
+	if len(result.Entries) != 1 {
+		return nil, nil, "Login failed"
+	}
+
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		return nil, nil, "Login failed"
+	}
+
+	groups := entry.GetAttributeValues(l.groupAttribute)
+
+	roles := l.mapRoles(groups)
+
+	if len(l.agentGroups) > 0 && !memberOfAny(groups, l.agentGroups) && len(roles) == 1 {
+		return nil, nil, "Login failed: agent is not a member of the allowed groups"
+	}
+
+	agent := types.Agent{Id: entry.GetAttributeValue(l.idAttribute), Name: entry.GetAttributeValue(l.nameAttribute)}
+	if agent.Id == "" {
+		agent.Id = username
+	}
+	if agent.Name == "" {
+		agent.Name = agent.Id
+	}
+
+	return &agent, roles, ""
+}
+
+// mapRoles gives the agent every role one of its groups is mapped to, besides the agent role
+func (l *LDAPAuthenticator) mapRoles(groups []string) []types.AgentRole {
+
+	roles := []types.AgentRole{types.RoleAgent}
+
+	for _, role := range []types.AgentRole{types.RoleSupervisor, types.RoleAdmin} {
+		if memberOfAny(groups, l.roleGroups[role]) {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles
+}
+
+// memberOfAny compares group DNs case insensitively, as directories do
+func memberOfAny(groups []string, allowed []string) bool {
+	for _, group := range groups {
+		for _, allowedGroup := range allowed {
+			if strings.EqualFold(strings.TrimSpace(group), strings.TrimSpace(allowedGroup)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (l *LDAPAuthenticator) Logout(id string) bool {
+	return true
+}
+
+func (l *LDAPAuthenticator) Disconnect() {
+}
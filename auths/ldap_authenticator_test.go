@@ -0,0 +1,242 @@
+package auths
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"reflect"
+	"server/types"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	testBaseDN       = "DC=example,DC=com"
+	testBindDN       = "CN=omnichannel,OU=Service Accounts,DC=example,DC=com"
+	testBindPassword = "service-secret"
+	testUserFilter   = "(&(objectClass=user)(sAMAccountName={username}))"
+
+	testAgentsGroup      = "CN=Omnichannel Agents,OU=Groups,DC=example,DC=com"
+	testSupervisorsGroup = "CN=Omnichannel Supervisors,OU=Groups,DC=example,DC=com"
+	testAdminsGroup      = "CN=Omnichannel Admins,OU=Groups,DC=example,DC=com"
+)
+
+type directoryUser struct {
+	dn       string
+	password string
+	account  string
+	name     string
+	groups   []string
+}
+
+// fakeDirectory is an in-process stand-in for an Active Directory server
+type fakeDirectory struct {
+	users     []directoryUser
+	startTLS  bool
+	dialError error
+}
+
+type fakeConnection struct {
+	directory *fakeDirectory
+	boundDN   string
+	closed    bool
+}
+
+func (d *fakeDirectory) dial() (ldapConnection, error) {
+	if d.dialError != nil {
+		return nil, d.dialError
+	}
+
+	return &fakeConnection{directory: d}, nil
+}
+
+func (c *fakeConnection) Bind(username string, password string) error {
+
+	// like real servers, an empty password is an unauthenticated bind that succeeds
+	if password == "" {
+		c.boundDN = ""
+		return nil
+	}
+
+	if username == testBindDN && password == testBindPassword {
+		c.boundDN = username
+		return nil
+	}
+
+	for _, user := range c.directory.users {
+		if strings.EqualFold(user.dn, username) && user.password == password {
+			c.boundDN = user.dn
+			return nil
+		}
+	}
+
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+}
+
+func (c *fakeConnection) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+
+	if c.boundDN != testBindDN {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("search requires the service account"))
+	}
+
+	result := &ldap.SearchResult{}
+
+	for _, user := range c.directory.users {
+		if request.Filter != strings.ReplaceAll(testUserFilter, LDAP_USERNAME, ldap.EscapeFilter(user.account)) {
+			continue
+		}
+
+		result.Entries = append(result.Entries, ldap.NewEntry(user.dn, map[string][]string{
+			"sAMAccountName": {user.account},
+			"displayName":    {user.name},
+			"memberOf":       user.groups,
+		}))
+	}
+
+	return result, nil
+}
+
+func (c *fakeConnection) StartTLS(config *tls.Config) error {
+	c.directory.startTLS = true
+	return nil
+}
+
+func (c *fakeConnection) Close() {
+	c.closed = true
+}
+
+func newTestAuthenticator(directory *fakeDirectory) *LDAPAuthenticator {
+	return &LDAPAuthenticator{
+		bindDN:         testBindDN,
+		bindPassword:   testBindPassword,
+		baseDN:         testBaseDN,
+		userFilter:     testUserFilter,
+		idAttribute:    "sAMAccountName",
+		nameAttribute:  "displayName",
+		groupAttribute: "memberOf",
+		agentGroups:    []string{testAgentsGroup},
+		roleGroups: map[types.AgentRole][]string{
+			types.RoleSupervisor: {testSupervisorsGroup},
+			types.RoleAdmin:      {testAdminsGroup},
+		},
+		dial: directory.dial,
+	}
+}
+
+func newTestDirectory() *fakeDirectory {
+	return &fakeDirectory{users: []directoryUser{
+		{dn: "CN=Ana Agent,OU=Staff,DC=example,DC=com", password: "ana-pass", account: "ana", name: "Ana Agent", groups: []string{testAgentsGroup}},
+		{dn: "CN=Sam Supervisor,OU=Staff,DC=example,DC=com", password: "sam-pass", account: "sam", name: "Sam Supervisor", groups: []string{testAgentsGroup, strings.ToLower(testSupervisorsGroup)}},
+		{dn: "CN=Ada Admin,OU=Staff,DC=example,DC=com", password: "ada-pass", account: "ada", name: "Ada Admin", groups: []string{testSupervisorsGroup, testAdminsGroup}},
+		{dn: "CN=Olga Outsider,OU=Staff,DC=example,DC=com", password: "olga-pass", account: "olga", name: "Olga Outsider"},
+	}}
+}
+
+func TestLDAPLoginMapsGroupsToRoles(t *testing.T) {
+
+	authenticator := newTestAuthenticator(newTestDirectory())
+
+	tests := []struct {
+		username string
+		password string
+		roles    []types.AgentRole
+		name     string
+	}{
+		{"ana", "ana-pass", []types.AgentRole{types.RoleAgent}, "Ana Agent"},
+		{"sam", "sam-pass", []types.AgentRole{types.RoleAgent, types.RoleSupervisor}, "Sam Supervisor"},
+		{"ada", "ada-pass", []types.AgentRole{types.RoleAgent, types.RoleSupervisor, types.RoleAdmin}, "Ada Admin"},
+	}
+
+	for _, test := range tests {
+		agent, roles, failedMsg := authenticator.Login(test.username, test.password)
+		if failedMsg != "" || agent == nil {
+			t.Fatalf("Login(%q) failed: %q", test.username, failedMsg)
+		}
+		if agent.Id != test.username || agent.Name != test.name || !reflect.DeepEqual(roles, test.roles) {
+			t.Errorf("Login(%q) = %+v, %v, want id %q, name %q, roles %v", test.username, agent, roles, test.username, test.name, test.roles)
+		}
+	}
+}
+
+func TestLDAPLoginRejectsInvalidCredentials(t *testing.T) {
+
+	authenticator := newTestAuthenticator(newTestDirectory())
+
+	tests := []struct {
+		description string
+		username    string
+		password    string
+	}{
+		{"wrong password", "ana", "wrong"},
+		{"empty password", "ana", ""},
+		{"unknown user", "nobody", "ana-pass"},
+		{"filter injection", "*", "ana-pass"},
+		{"not in agent groups", "olga", "olga-pass"},
+	}
+
+	for _, test := range tests {
+		if agent, _, failedMsg := authenticator.Login(test.username, test.password); agent != nil || failedMsg == "" {
+			t.Errorf("%s: Login(%q) = %+v, %q, want failure", test.description, test.username, agent, failedMsg)
+		}
+	}
+}
+
+func TestLDAPLoginWithoutAgentGroupsAllowsEveryUser(t *testing.T) {
+
+	authenticator := newTestAuthenticator(newTestDirectory())
+	authenticator.agentGroups = nil
+
+	agent, roles, failedMsg := authenticator.Login("olga", "olga-pass")
+	if failedMsg != "" || agent == nil || !reflect.DeepEqual(roles, []types.AgentRole{types.RoleAgent}) {
+		t.Fatalf("Login(olga) = %+v, %q, want an agent", agent, failedMsg)
+	}
+}
+
+func TestLDAPLoginUsesStartTLS(t *testing.T) {
+
+	directory := newTestDirectory()
+	authenticator := newTestAuthenticator(directory)
+	authenticator.startTLS = true
+
+	if _, _, failedMsg := authenticator.Login("ana", "ana-pass"); failedMsg != "" {
+		t.Fatalf("Login failed: %q", failedMsg)
+	}
+	if !directory.startTLS {
+		t.Error("StartTLS was not requested")
+	}
+}
+
+func TestLDAPLoginFailsWhenDirectoryIsUnavailable(t *testing.T) {
+
+	directory := newTestDirectory()
+	directory.dialError = errors.New("connection refused")
+	authenticator := newTestAuthenticator(directory)
+
+	if agent, _, failedMsg := authenticator.Login("ana", "ana-pass"); agent != nil || failedMsg == "" {
+		t.Fatalf("Login = %+v, %q, want failure", agent, failedMsg)
+	}
+
+	authenticator = newTestAuthenticator(newTestDirectory())
+	authenticator.bindPassword = "wrong"
+
+	if agent, _, failedMsg := authenticator.Login("ana", "ana-pass"); agent != nil || failedMsg == "" {
+		t.Fatalf("Login with a wrong service password = %+v, %q, want failure", agent, failedMsg)
+	}
+}
+
+func TestLDAPLoginWithoutConfiguration(t *testing.T) {
+
+	// conf/login_auth_conf.ini can not be found from an empty directory
+	dir, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	t.Cleanup(func() { os.Chdir(dir) })
+
+	authenticator := &LDAPAuthenticator{}
+	authenticator.Init()
+
+	if agent, _, failedMsg := authenticator.Login("ana", "ana-pass"); agent != nil || failedMsg != "Login failed: directory is unavailable" {
+		t.Fatalf("Login = %+v, %q, want the directory to be unavailable", agent, failedMsg)
+	}
+}
@@ -0,0 +1,248 @@
+package auths
+
+import (
+	"log"
+	"server/db"
+	"server/types"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ini/ini"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	MIN_PASSWORD_LENGTH = 8
+)
+
+var (
+	LOCAL_DB_CREDENTIALS string
+	LOCAL_DB_NAME        string
+)
+
+// AgentDirectory is implemented by authenticators that manage their own agent accounts
+type AgentDirectory interface {
+	CreateAgent(id string, name string, password string, roles []types.AgentRole) (failedMsg string)
+	SetAgentDisabled(id string, disabled bool) (failedMsg string)
+	SetAgentRoles(id string, roles []types.AgentRole) (failedMsg string)
+	ResetPassword(id string, password string) (failedMsg string)
+}
+
+// LocalAuthenticator checks agents against the agents table in the omni DB, for agents without an Asterisk extension.
+// Passwords are stored only as bcrypt hashes.
+type LocalAuthenticator struct{}
+
+func (l *LocalAuthenticator) Init() {
+
+	cfg, err := ini.Load("conf/db_conf.ini")
+	if err != nil {
+		log.Println("Failed to read db_conf file: ", err)
+		return
+	}
+
+	dbUser := cfg.Section("omni-db").Key("DB_USER").String()
+	dbPass := cfg.Section("omni-db").Key("DB_PASSWORD").String()
+	dbConnType := cfg.Section("omni-db").Key("DB_CONNECTION_TYPE").String()
+	dbServerIP := cfg.Section("omni-db").Key("DB_SERVER_IP").String()
+	dbPort := cfg.Section("omni-db").Key("DB_PORT").String()
+
+	LOCAL_DB_CREDENTIALS = dbUser + ":" + dbPass + "@" + dbConnType + "(" + dbServerIP + ":" + dbPort + ")"
+	LOCAL_DB_NAME = cfg.Section("omni-db").Key("DB_NAME").String()
+
+	queryAgentsTable := `CREATE TABLE IF NOT EXISTS agents(id VARCHAR(256) primary key, name TEXT, password_hash VARCHAR(256), disabled BOOLEAN DEFAULT false, created_timestamp BIGINT)`
+
+	db.DBConnector.CreateDB(LOCAL_DB_CREDENTIALS, LOCAL_DB_NAME)
+	db.DBConnector.CreateTable(LOCAL_DB_CREDENTIALS, LOCAL_DB_NAME, queryAgentsTable)
+	db.DBConnector.AddColumn(LOCAL_DB_CREDENTIALS, LOCAL_DB_NAME, "agents", "roles", "VARCHAR(256) DEFAULT '"+string(types.RoleAgent)+"'")
+
+	l.bootstrapAdmin()
+}
+
+// bootstrapAdmin creates the first account from login_auth_conf.ini while the agents table is still empty
+func (l *LocalAuthenticator) bootstrapAdmin() {
+
+	cfg, err := ini.Load("conf/login_auth_conf.ini")
+	if err != nil {
+		log.Println("Failed to read login_auth_conf file: ", err)
+		return
+	}
+
+	adminID := cfg.Section("local-authenticator").Key("BOOTSTRAP_ADMIN").String()
+	adminPassword := cfg.Section("local-authenticator").Key("BOOTSTRAP_PASSWORD").String()
+	if adminID == "" || adminPassword == "" {
+		return
+	}
+
+	results := db.DBConnector.ExecuteQuery(LOCAL_DB_CREDENTIALS, LOCAL_DB_NAME, "SELECT COUNT(*) FROM agents")
+	defer results.Close()
+
+	var count int
+	if results.Next() {
+		results.Scan(&count)
+	}
+
+	if count == 0 {
+		if failedMsg := l.CreateAgent(adminID, adminID, adminPassword, []types.AgentRole{types.RoleAdmin}); failedMsg != "" {
+			log.Println("Failed to create the bootstrap admin: ", failedMsg)
+		}
+	}
+}
+
+func (l *LocalAuthenticator) Login(username string, password string) (*types.Agent, []types.AgentRole, string) {
+
+	results := db.DBConnector.ExecuteQuery(LOCAL_DB_CREDENTIALS, LOCAL_DB_NAME, "SELECT id, name, password_hash, disabled, roles FROM agents WHERE id=?", username)
+	defer results.Close()
+
+	if !results.Next() {
+		return nil, nil, "Login failed"
+	}
+
+	var agent types.Agent
+	var passwordHash string
+	var disabled bool
+	var roles string
+
+	if err := results.Scan(&agent.Id, &agent.Name, &passwordHash, &disabled, &roles); err != nil {
+		return nil, nil, "Login failed: " + err.Error()
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+		return nil, nil, "Login failed"
+	}
+
+	if disabled {
+		return nil, nil, "Agent is disabled"
+	}
+
+	return &agent, parseRoles(roles), ""
+}
+
+// normalizeRoles removes duplicates, every agent has at least the agent role
+func normalizeRoles(roles []types.AgentRole) []types.AgentRole {
+
+	normalized := []types.AgentRole{types.RoleAgent}
+	seen := map[types.AgentRole]bool{types.RoleAgent: true}
+
+	for _, role := range roles {
+		if role != "" && !seen[role] {
+			seen[role] = true
+			normalized = append(normalized, role)
+		}
+	}
+
+	return normalized
+}
+
+func parseRoles(roles string) []types.AgentRole {
+
+	var parsed []types.AgentRole
+	for _, role := range strings.Split(roles, ",") {
+		parsed = append(parsed, types.AgentRole(strings.TrimSpace(role)))
+	}
+
+	return normalizeRoles(parsed)
+}
+
+func formatRoles(roles []types.AgentRole) string {
+
+	var names []string
+	for _, role := range roles {
+		names = append(names, string(role))
+	}
+
+	return strings.Join(names, ",")
+}
+
+func (l *LocalAuthenticator) Logout(id string) bool {
+	return true
+}
+
+func (l *LocalAuthenticator) Disconnect() {
+}
+
+func (l *LocalAuthenticator) agentExists(id string) bool {
+
+	results := db.DBConnector.ExecuteQuery(LOCAL_DB_CREDENTIALS, LOCAL_DB_NAME, "SELECT id FROM agents WHERE id=?", id)
+	defer results.Close()
+
+	return results.Next()
+}
+
+func hashPassword(password string) (string, string) {
+
+	if len(password) < MIN_PASSWORD_LENGTH {
+		return "", "Password must have at least " + strconv.Itoa(MIN_PASSWORD_LENGTH) + " characters"
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "Failed to hash password: " + err.Error()
+	}
+
+	return string(hash), ""
+}
+
+func (l *LocalAuthenticator) CreateAgent(id string, name string, password string, roles []types.AgentRole) string {
+
+	if id == "" {
+		return "Agent id is required"
+	}
+
+	if l.agentExists(id) {
+		return "Agent already exists"
+	}
+
+	passwordHash, failedMsg := hashPassword(password)
+	if failedMsg != "" {
+		return failedMsg
+	}
+
+	query := "INSERT INTO agents(id, name, password_hash, roles, disabled, created_timestamp) VALUES(?, ?, ?, ?, false, ?)"
+
+	results := db.DBConnector.ExecuteQuery(LOCAL_DB_CREDENTIALS, LOCAL_DB_NAME, query, id, name, passwordHash, formatRoles(normalizeRoles(roles)), time.Now().UnixMilli())
+	defer results.Close()
+
+	return ""
+}
+
+func (l *LocalAuthenticator) SetAgentDisabled(id string, disabled bool) string {
+
+	if !l.agentExists(id) {
+		return "Agent not found"
+	}
+
+	results := db.DBConnector.ExecuteQuery(LOCAL_DB_CREDENTIALS, LOCAL_DB_NAME, "UPDATE agents SET disabled=? WHERE id=?", disabled, id)
+	defer results.Close()
+
+	return ""
+}
+
+func (l *LocalAuthenticator) SetAgentRoles(id string, roles []types.AgentRole) string {
+
+	if !l.agentExists(id) {
+		return "Agent not found"
+	}
+
+	results := db.DBConnector.ExecuteQuery(LOCAL_DB_CREDENTIALS, LOCAL_DB_NAME, "UPDATE agents SET roles=? WHERE id=?", formatRoles(normalizeRoles(roles)), id)
+	defer results.Close()
+
+	return ""
+}
+
+func (l *LocalAuthenticator) ResetPassword(id string, password string) string {
+
+	if !l.agentExists(id) {
+		return "Agent not found"
+	}
+
+	passwordHash, failedMsg := hashPassword(password)
+	if failedMsg != "" {
+		return failedMsg
+	}
+
+	results := db.DBConnector.ExecuteQuery(LOCAL_DB_CREDENTIALS, LOCAL_DB_NAME, "UPDATE agents SET password_hash=? WHERE id=?", passwordHash, id)
+	defer results.Close()
+
+	return ""
+}
@@ -0,0 +1,181 @@
+package auths
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"server/types"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeAgentsDB is an in-process stand-in for the agents table, registered as the mysql driver the db package opens.
+// It only answers the placeholder queries of the LocalAuthenticator and records every query it runs.
+type fakeAgentsDB struct {
+	agents        map[string][]driver.Value //map[id]id, name, password_hash, disabled, roles
+	pjsipAccounts map[string]string         //map[extension]password of the asterisk ps_auths table
+	queries       []string
+	mutex         sync.Mutex
+}
+
+var testAgentsDB = &fakeAgentsDB{}
+
+func init() {
+	sql.Register("mysql", testAgentsDB)
+}
+
+func (f *fakeAgentsDB) Open(name string) (driver.Conn, error) {
+	return fakeAgentsConn{f}, nil
+}
+
+type fakeAgentsConn struct {
+	db *fakeAgentsDB
+}
+
+func (c fakeAgentsConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeAgentsStmt{c.db, query}, nil
+}
+
+func (c fakeAgentsConn) Close() error {
+	return nil
+}
+
+func (c fakeAgentsConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type fakeAgentsStmt struct {
+	db    *fakeAgentsDB
+	query string
+}
+
+func (s fakeAgentsStmt) Close() error {
+	return nil
+}
+
+func (s fakeAgentsStmt) NumInput() int {
+	return strings.Count(s.query, "?")
+}
+
+func (s fakeAgentsStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("exec is not supported")
+}
+
+func (s fakeAgentsStmt) Query(args []driver.Value) (driver.Rows, error) {
+
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+
+	s.db.queries = append(s.db.queries, s.query)
+
+	rows := &fakeAgentsRows{}
+
+	switch s.query {
+	case "SELECT id, name, password_hash, disabled, roles FROM agents WHERE id=?":
+		rows.columns = []string{"id", "name", "password_hash", "disabled", "roles"}
+		if agent, ok := s.db.agents[args[0].(string)]; ok {
+			rows.values = append(rows.values, agent)
+		}
+	case "SELECT id FROM agents WHERE id=?":
+		rows.columns = []string{"id"}
+		if _, ok := s.db.agents[args[0].(string)]; ok {
+			rows.values = append(rows.values, []driver.Value{args[0]})
+		}
+	case "SELECT u.ext, u.name FROM users u INNER JOIN ps_auths p ON u.ext = p.id WHERE p.id=? AND p.password=?":
+		rows.columns = []string{"ext", "name"}
+		if secret, ok := s.db.pjsipAccounts[args[0].(string)]; ok && secret == args[1].(string) {
+			rows.values = append(rows.values, []driver.Value{args[0], "Agent " + args[0].(string)})
+		}
+	default:
+		return nil, errors.New("unexpected query " + s.query)
+	}
+
+	return rows, nil
+}
+
+type fakeAgentsRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeAgentsRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeAgentsRows) Close() error {
+	return nil
+}
+
+func (r *fakeAgentsRows) Next(dest []driver.Value) error {
+
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+
+	return nil
+}
+
+// resetTestAgentsDB fills the agents table with one agent per password
+func resetTestAgentsDB(t *testing.T, passwords map[string]string) {
+
+	testAgentsDB.mutex.Lock()
+	defer testAgentsDB.mutex.Unlock()
+
+	testAgentsDB.agents = make(map[string][]driver.Value)
+	testAgentsDB.queries = nil
+
+	for id, password := range passwords {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatalf("failed to hash the password of %s: %v", id, err)
+		}
+		testAgentsDB.agents[id] = []driver.Value{id, "Agent " + id, string(hash), false, "agent"}
+	}
+}
+
+func TestLocalLogin(t *testing.T) {
+
+	resetTestAgentsDB(t, map[string]string{"jdoe": "jdoe-password"})
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		success  bool
+	}{
+		{"valid credentials", "jdoe", "jdoe-password", true},
+		{"wrong password", "jdoe", "wrong-password", false},
+		{"unknown agent", "nobody", "jdoe-password", false},
+		{"injected username", "x' UNION SELECT 'x','x','" + "$2a$04$attackerhash" + "',0,'admin' -- ", "jdoe-password", false},
+		{"injected condition", "jdoe' OR '1'='1", "jdoe-password", false},
+	}
+
+	l := &LocalAuthenticator{}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			agent, roles, failedMsg := l.Login(test.username, test.password)
+			if success := failedMsg == ""; success != test.success {
+				t.Fatalf("Login(%q) failed with %q, want success %v", test.username, failedMsg, test.success)
+			}
+
+			if test.success && (agent.Id != test.username || len(roles) != 1 || roles[0] != types.RoleAgent) {
+				t.Errorf("Login(%q) = %s %v, want %s [%s]", test.username, agent.Id, roles, test.username, types.RoleAgent)
+			}
+		})
+	}
+
+	for _, query := range testAgentsDB.queries {
+		if strings.Contains(query, "'") {
+			t.Errorf("query %q has a value in its text, want only placeholders", query)
+		}
+	}
+}
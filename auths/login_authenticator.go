@@ -4,7 +4,27 @@ import "server/types"
 
 type LoginAuthenticator interface {
 	Init()
-	Login(username string, password string) (agent *types.Agent, failedMsg string)
+	Login(username string, password string) (agent *types.Agent, roles []types.AgentRole, failedMsg string)
 	Logout(userId string) (success bool)
 	Disconnect()
 }
+
+// HealthChecker is implemented by authenticators that depend on an external service
+type HealthChecker interface {
+	CheckHealth() (healthy bool, details string)
+}
+
+// PhoneStateProvider is implemented by authenticators that know whether the agent's phone is on a call
+type PhoneStateProvider interface {
+	GetPhoneState(userId string) types.PhoneState
+}
+
+// VoiceQueueMember is implemented by authenticators that put the agents in voice queues
+type VoiceQueueMember interface {
+	SetVoiceAvailability(userId string, state types.AgentState) (success bool)
+}
+
+// Dialer is implemented by authenticators that can place phone calls from the agent's extension
+type Dialer interface {
+	CallNumber(userId string, number string, callerName string) (callID string, failedMsg string)
+}
@@ -11,4 +11,5 @@ type Channel interface {
 	GetMessageInfo(data map[string]interface{}) (messageText string, msgTimestamp uint)
 	GetMessageStatus(data map[string]interface{}) types.MessageStatus
 	SendMessage(senderUniqueID string, messageText string, autoreply bool)
+	CheckConfiguration() (failedMsg string)
 }
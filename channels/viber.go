@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"server/metrics"
 	"server/types"
 
 	"github.com/go-ini/ini"
@@ -23,6 +24,14 @@ func (v Viber) Init() {
 	VIBER_AUTH_TOKEN = cfg.Section("viber").Key("token").String()
 }
 
+func (v Viber) CheckConfiguration() string {
+	if VIBER_AUTH_TOKEN == "" {
+		return "Viber token is not configured"
+	}
+
+	return ""
+}
+
 func (v Viber) GetSenderInfo(data map[string]interface{}) (string, string) {
 
 	var senderUniqueID string
@@ -115,7 +124,16 @@ func (v Viber) SendMessage(customerID string, messageText string, autoreply bool
 	response, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Println("Failed to send message to Viber", err)
+		metrics.MessagesSent.Inc("viber", metrics.RESULT_FAILURE)
+		return
 	}
 
 	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		log.Println("Failed to send message to Viber, status: ", response.Status)
+		metrics.MessagesSent.Inc("viber", metrics.RESULT_FAILURE)
+	} else {
+		metrics.MessagesSent.Inc("viber", metrics.RESULT_SUCCESS)
+	}
 }
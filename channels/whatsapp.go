@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"server/metrics"
 	"server/types"
 	"strings"
 	"time"
@@ -30,6 +31,16 @@ func (v WhatsApp) Init() {
 	WHATSAPP_NUMBER = cfg.Section("whatsapp").Key("number").String()
 }
 
+func (v WhatsApp) CheckConfiguration() string {
+	if WHATSAPP_SID == "" || WHATSAPP_AUTH_TOKEN == "" {
+		return "WhatsApp sid and token are not configured"
+	} else if WHATSAPP_NUMBER == "" {
+		return "WhatsApp number is not configured"
+	}
+
+	return ""
+}
+
 func (v WhatsApp) ParseReceivedData(body []byte) (string, map[string]interface{}) {
 
 	data := make(map[string]interface{})
@@ -135,10 +146,19 @@ func (w WhatsApp) SendMessage(customerID string, messageText string, autoreply b
 	response, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Println("Failed to send whatsapp message", err)
+		metrics.MessagesSent.Inc("whatsapp", metrics.RESULT_FAILURE)
+		return
 	}
 
 	//outputAsBytes, _ := ioutil.ReadAll(response.Body)
 	//log.Println(string(outputAsBytes))
 
 	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		log.Println("Failed to send whatsapp message, status: ", response.Status)
+		metrics.MessagesSent.Inc("whatsapp", metrics.RESULT_FAILURE)
+	} else {
+		metrics.MessagesSent.Inc("whatsapp", metrics.RESULT_SUCCESS)
+	}
 }
@@ -1,8 +1,11 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"log"
+	"server/metrics"
+	"time"
 )
 
 var DBConnector DBCONNECTION
@@ -25,12 +28,20 @@ func (d *DBCONNECTION) OpenDB(dbCredentials string, dbName string) {
 	}
 }
 
-func (d *DBCONNECTION) ExecuteQuery(dbCredentials string, dbName string, queryString string) *sql.Rows {
+// ExecuteQuery runs the query, values from the request belong in args for the query's ? placeholders
+func (d *DBCONNECTION) ExecuteQuery(dbCredentials string, dbName string, queryString string, args ...interface{}) *sql.Rows {
 
-	d.OpenDB(dbCredentials, dbName)
-	defer d.DB.Close()
+	// a handle of its own, queries run concurrently from the handlers and the background checks
+	db, error := sql.Open("mysql", dbCredentials+"/"+dbName)
+	if error != nil {
+		log.Println("Failed to open DB: ", error.Error())
+		return nil
+	}
+	defer db.Close()
 
-	queryResults, error := d.DB.Query(queryString)
+	start := time.Now()
+	queryResults, error := db.Query(queryString, args...)
+	metrics.DBQueryDuration.Observe(time.Since(start).Seconds(), dbName)
 
 	if error != nil {
 		log.Println("DB Query Error: ", error.Error(), queryString)
@@ -39,6 +50,42 @@ func (d *DBCONNECTION) ExecuteQuery(dbCredentials string, dbName string, querySt
 	return queryResults
 }
 
+// Execute runs a statement that returns no rows and reports its error, values from the request belong in args
+func (d *DBCONNECTION) Execute(dbCredentials string, dbName string, queryString string, args ...interface{}) error {
+
+	db, err := sql.Open("mysql", dbCredentials+"/"+dbName)
+	if err != nil {
+		log.Println("Failed to open DB: ", err.Error())
+		return err
+	}
+	defer db.Close()
+
+	start := time.Now()
+	_, err = db.Exec(queryString, args...)
+	metrics.DBQueryDuration.Observe(time.Since(start).Seconds(), dbName)
+
+	if err != nil {
+		log.Println("DB Query Error: ", err.Error(), queryString)
+	}
+
+	return err
+}
+
+// Ping checks the connection on its own handle, so it does not interfere with the queries running on d.DB
+func (d *DBCONNECTION) Ping(dbCredentials string, dbName string, timeout time.Duration) error {
+
+	db, err := sql.Open("mysql", dbCredentials+"/"+dbName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return db.PingContext(ctx)
+}
+
 func (d *DBCONNECTION) CreateDB(dbCredentials string, dbName string) {
 
 	var err error
@@ -68,3 +115,22 @@ func (d *DBCONNECTION) CreateTable(dbCredentials string, dbName string, query st
 
 	d.DB.Close()
 }
+
+func (d *DBCONNECTION) AddColumn(dbCredentials string, dbName string, table string, column string, definition string) {
+
+	d.OpenDB(dbCredentials, dbName)
+	defer d.DB.Close()
+
+	var count int
+	query := "SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA=? AND TABLE_NAME=? AND COLUMN_NAME=?"
+	if err := d.DB.QueryRow(query, dbName, table, column).Scan(&count); err != nil {
+		log.Println("Error when checking table columns", err)
+		return
+	}
+
+	if count == 0 {
+		if _, err := d.DB.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition); err != nil {
+			log.Println("Error when adding a new column", err)
+		}
+	}
+}
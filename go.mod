@@ -4,8 +4,15 @@ go 1.17
 
 require (
 	github.com/bit4bit/gami v0.0.0-20170610060903-a57db6fa69cb
+	github.com/go-ldap/ldap/v3 v3.4.4
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/google/uuid v1.3.0
+	golang.org/x/crypto v0.1.0
+)
+
+require (
+	github.com/Azure/go-ntlmssp v0.0.0-20220621081337-cb9428e4ac1e // indirect
+	github.com/go-asn1-ber/asn1-ber v1.5.4 // indirect
 )
 
 require (
@@ -0,0 +1,272 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds in seconds used by latency histograms
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metric is anything that can write itself in the Prometheus text exposition format
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registry      []metric
+	registryMutex sync.Mutex
+)
+
+func register(m metric) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	registry = append(registry, m)
+}
+
+// Handler serves every registered metric in the Prometheus text format
+func Handler(w http.ResponseWriter, req *http.Request) {
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	registryMutex.Lock()
+	metrics := append([]metric(nil), registry...)
+	registryMutex.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+func writeHeader(w io.Writer, name string, help string, metricType string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// formatLabels renders {name="value",...}, or "" when there are no labels
+func formatLabels(names []string, values []string) string {
+
+	if len(names) == 0 {
+		return ""
+	}
+
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	var pairs []string
+	for i := range names {
+		pairs = append(pairs, names[i]+`="`+escaper.Replace(values[i])+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelKey joins the label values into a map key, missing values are empty
+func labelKey(names []string, values []string) (string, []string) {
+
+	normalized := make([]string, len(names))
+	copy(normalized, values)
+
+	return strings.Join(normalized, "\xff"), normalized
+}
+
+// CounterVec is a counter partitioned by label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+	series map[string][]string
+	mutex  sync.Mutex
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]float64), series: make(map[string][]string)}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter, a counter never goes down so negative values are ignored
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+
+	if !(value >= 0) {
+		return
+	}
+
+	key, values := labelKey(c.labels, labelValues)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.values[key] += value
+	c.series[key] = values
+}
+
+func (c *CounterVec) write(w io.Writer) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+
+	var keys []string
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, c.series[key]), formatValue(c.values[key]))
+	}
+}
+
+// Gauge is a single value that can go up and down
+type Gauge struct {
+	name  string
+	help  string
+	value float64
+	mutex sync.Mutex
+}
+
+func NewGauge(name string, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(value float64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.value = value
+}
+
+func (g *Gauge) Add(value float64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.value += value
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+func (g *Gauge) write(w io.Writer) {
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.value))
+}
+
+// GaugeFunc is a gauge whose value is computed when the metrics are scraped
+type GaugeFunc struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+func NewGaugeFunc(name string, help string, value func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, value: value}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.value()))
+}
+
+type histogram struct {
+	labelValues []string
+	counts      []uint64 //per bucket, not cumulative
+	count       uint64
+	sum         float64
+}
+
+// HistogramVec counts observations into buckets, partitioned by label values
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*histogram
+	mutex   sync.Mutex
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+
+	key, values := labelKey(h.labels, labelValues)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	series := h.series[key]
+	if series == nil {
+		series = &histogram{labelValues: values, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+			break
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+
+	var keys []string
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := h.series[key]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += series.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, append(append([]string(nil), series.labelValues...), formatValue(bound))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, append(append([]string(nil), series.labelValues...), "+Inf")), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, series.labelValues), formatValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, series.labelValues), series.count)
+	}
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func output(m metric) string {
+
+	var buffer bytes.Buffer
+	m.write(&buffer)
+
+	return buffer.String()
+}
+
+func TestCounterVecEscapesLabels(t *testing.T) {
+
+	counter := NewCounterVec("test_escaped_total", "Counts with \\ and\nnew lines.", "channel")
+	counter.Inc(`a"b`)
+	counter.Inc(`c\d`)
+	counter.Inc("e\nf")
+
+	want := "# HELP test_escaped_total Counts with \\\\ and\\nnew lines.\n" +
+		"# TYPE test_escaped_total counter\n" +
+		`test_escaped_total{channel="a\"b"} 1` + "\n" +
+		`test_escaped_total{channel="c\\d"} 1` + "\n" +
+		`test_escaped_total{channel="e\nf"} 1` + "\n"
+
+	if got := output(counter); got != want {
+		t.Errorf("counter output =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCounterVecIsMonotonic(t *testing.T) {
+
+	counter := NewCounterVec("test_monotonic_total", "Monotonic counter.", "channel", "direction")
+	counter.Add(2, "whatsapp", "in")
+	counter.Add(-5, "whatsapp", "in")
+	counter.Add(math.NaN(), "whatsapp", "in")
+	counter.Add(0.5, "whatsapp", "in")
+	counter.Inc("viber")
+
+	want := "# HELP test_monotonic_total Monotonic counter.\n" +
+		"# TYPE test_monotonic_total counter\n" +
+		`test_monotonic_total{channel="viber",direction=""} 1` + "\n" +
+		`test_monotonic_total{channel="whatsapp",direction="in"} 2.5` + "\n"
+
+	if got := output(counter); got != want {
+		t.Errorf("counter output =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+
+	histogram := NewHistogramVec("test_duration_seconds", "Durations.", []float64{0.1, 1, 10}, "db")
+	for _, value := range []float64{0.05, 0.1, 0.5, 20} {
+		histogram.Observe(value, "omni")
+	}
+	histogram.Observe(2, "asterisk")
+
+	want := "# HELP test_duration_seconds Durations.\n" +
+		"# TYPE test_duration_seconds histogram\n" +
+		`test_duration_seconds_bucket{db="asterisk",le="0.1"} 0` + "\n" +
+		`test_duration_seconds_bucket{db="asterisk",le="1"} 0` + "\n" +
+		`test_duration_seconds_bucket{db="asterisk",le="10"} 1` + "\n" +
+		`test_duration_seconds_bucket{db="asterisk",le="+Inf"} 1` + "\n" +
+		`test_duration_seconds_sum{db="asterisk"} 2` + "\n" +
+		`test_duration_seconds_count{db="asterisk"} 1` + "\n" +
+		`test_duration_seconds_bucket{db="omni",le="0.1"} 2` + "\n" +
+		`test_duration_seconds_bucket{db="omni",le="1"} 3` + "\n" +
+		`test_duration_seconds_bucket{db="omni",le="10"} 3` + "\n" +
+		`test_duration_seconds_bucket{db="omni",le="+Inf"} 4` + "\n" +
+		`test_duration_seconds_sum{db="omni"} 20.65` + "\n" +
+		`test_duration_seconds_count{db="omni"} 4` + "\n"
+
+	if got := output(histogram); got != want {
+		t.Errorf("histogram output =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGauges(t *testing.T) {
+
+	gauge := NewGauge("test_connected", "Connected.")
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+
+	if got, want := output(gauge), "# HELP test_connected Connected.\n# TYPE test_connected gauge\ntest_connected 1\n"; got != want {
+		t.Errorf("gauge output =\n%s\nwant\n%s", got, want)
+	}
+
+	gaugeFunc := NewGaugeFunc("test_waiting", "Waiting.", func() float64 { return 3 })
+	if got, want := output(gaugeFunc), "# HELP test_waiting Waiting.\n# TYPE test_waiting gauge\ntest_waiting 3\n"; got != want {
+		t.Errorf("gauge func output =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+
+	NewCounterVec("test_handler_total", "Served by the handler.").Inc()
+
+	recorder := httptest.NewRecorder()
+	Handler(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4" {
+		t.Errorf("Content-Type = %q, want the Prometheus text format", contentType)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, "# TYPE test_handler_total counter\ntest_handler_total 1\n") {
+		t.Errorf("handler output does not contain test_handler_total:\n%s", body)
+	}
+}
@@ -0,0 +1,19 @@
+package metrics
+
+// Metrics shared by the packages of the server, gauges describing the server's state are registered by the services package
+var (
+	WebhookRequests = NewCounterVec("omnichannel_webhook_requests_total", "Webhook requests received from the channels.", "channel")
+	WebhookDuration = NewHistogramVec("omnichannel_webhook_request_duration_seconds", "Time spent handling a webhook request.", DefaultBuckets, "channel")
+
+	MessagesSent = NewCounterVec("omnichannel_outbound_messages_total", "Messages sent to the channels by result.", "channel", "result")
+
+	DBQueryDuration = NewHistogramVec("omnichannel_db_query_duration_seconds", "Time spent executing DB queries.", DefaultBuckets, "database")
+
+	AMIConnected = NewGauge("omnichannel_ami_connected", "Whether the Asterisk Manager Interface connection is logged in.")
+	TCPClients   = NewGauge("omnichannel_tcp_clients", "Connected TCP clients.")
+)
+
+const (
+	RESULT_SUCCESS = "success"
+	RESULT_FAILURE = "failure"
+)
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"server/auths"
+	"server/types"
+)
+
+func (s *TCPServer) handleAdminRequest(con net.Conn, action string, parsedData map[string]interface{}) {
+
+	directory, ok := s.loginAuthenticator.(auths.AgentDirectory)
+	if !ok {
+		parsedData["success"] = 0
+		parsedData["failed_message"] = "Login authenticator does not manage agent accounts"
+		return
+	}
+
+	failedMsg := ""
+	agentId := parsedData["agentID"].(string)
+
+	if action == CMD_CREATE_AGENT {
+
+		name, _ := parsedData["name"].(string)
+		password := parsedData["password"].(string)
+
+		var roles []types.AgentRole
+		if roles, failedMsg = parseRolesArgument(parsedData["roles"]); failedMsg == "" {
+			failedMsg = directory.CreateAgent(agentId, name, password, roles)
+		}
+
+	} else if action == CMD_DISABLE_AGENT {
+
+		if failedMsg = directory.SetAgentDisabled(agentId, true); failedMsg == "" && s.GetAgent(agentId) != nil && s.LogoffAgent(agentId) {
+			Audit.Record(AUDIT_FORCED_LOGOUT, agentId, "", remoteIP(con), "agent disabled")
+		}
+
+	} else if action == CMD_ENABLE_AGENT {
+
+		failedMsg = directory.SetAgentDisabled(agentId, false)
+
+	} else if action == CMD_SET_AGENT_ROLES {
+
+		var roles []types.AgentRole
+		if roles, failedMsg = parseRolesArgument(parsedData["roles"]); failedMsg == "" {
+			failedMsg = directory.SetAgentRoles(agentId, roles)
+		}
+
+		if agent := s.GetAgent(agentId); agent != nil && failedMsg == "" {
+			agent.Roles = s.GetRoles(agentId, roles)
+			parsedData["roles"] = agent.Roles
+		}
+
+	} else if action == CMD_RESET_AGENT_PASSWORD {
+
+		password := parsedData["password"].(string)
+
+		failedMsg = directory.ResetPassword(agentId, password)
+	}
+
+	// never echo the password back to the client
+	delete(parsedData, "password")
+
+	if failedMsg == "" {
+		parsedData["success"] = 1
+	} else {
+		parsedData["success"] = 0
+		parsedData["failed_message"] = failedMsg
+	}
+}
+
+// parseRolesArgument reads the optional list of role names sent with a command
+func parseRolesArgument(argument interface{}) ([]types.AgentRole, string) {
+
+	var roles []types.AgentRole
+
+	values, _ := argument.([]interface{})
+	for _, value := range values {
+		role := types.AgentRole(fmt.Sprint(value))
+		if !knownRoles[role] {
+			return nil, "Unknown role " + string(role)
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, ""
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"log"
+	"server/db"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AUDIT_LOGIN           = "login"
+	AUDIT_LOGIN_FAILED    = "login_failed"
+	AUDIT_LOGIN_LOCKED    = "login_locked"
+	AUDIT_LOGOUT          = "logout"
+	AUDIT_FORCED_LOGOUT   = "forced_logout"
+	AUDIT_CONNECTION_LOST = "connection_lost"
+
+	AUDIT_DEFAULT_LIMIT = 100
+	AUDIT_MAX_LIMIT     = 1000
+)
+
+var Audit AuditLog
+
+// AuditEntry is a row of the audit_log table
+type AuditEntry struct {
+	Id        int    `json:"id"`
+	Timestamp uint   `json:"timestamp"`
+	Event     string `json:"event"`
+	AgentId   string `json:"agent_id"`
+	Username  string `json:"username"`
+	RemoteIP  string `json:"remote_ip"`
+	Details   string `json:"details"`
+}
+
+// AuditFilter narrows an audit log query, empty fields match everything
+type AuditFilter struct {
+	AgentId string
+	Event   string
+	From    uint
+	To      uint
+	Limit   int
+}
+
+// AuditLog records the logins, logouts, failed attempts and forced disconnects of agents in the audit_log table
+type AuditLog struct{}
+
+// Record stores an audit event
+func (a *AuditLog) Record(event string, agentID string, username string, ip string, details string) {
+
+	query := "INSERT INTO audit_log(timestamp, event, agent_id, username, remote_ip, details) VALUES(?, ?, ?, ?, ?, ?)"
+
+	if err := db.DBConnector.Execute(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, query, time.Now().UnixMilli(), event, agentID, username, ip, details); err != nil {
+		log.Println("Failed to record audit event: ", err)
+	}
+
+	log.Println("Audit: ", event, " agent ", agentID, " username ", username, " from ", ip, " ", details)
+}
+
+// Query returns the newest audit entries matching the filter
+func (a *AuditLog) Query(filter AuditFilter) []AuditEntry {
+
+	var entries []AuditEntry
+
+	conditions := []string{"1=1"}
+	var args []interface{}
+	if filter.AgentId != "" {
+		conditions = append(conditions, "(agent_id=? OR username=?)")
+		args = append(args, filter.AgentId, filter.AgentId)
+	}
+	if filter.Event != "" {
+		conditions = append(conditions, "event=?")
+		args = append(args, filter.Event)
+	}
+	if filter.From != 0 {
+		conditions = append(conditions, "timestamp>=?")
+		args = append(args, uint64(filter.From))
+	}
+	if filter.To != 0 {
+		conditions = append(conditions, "timestamp<?")
+		args = append(args, uint64(filter.To))
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = AUDIT_DEFAULT_LIMIT
+	} else if filter.Limit > AUDIT_MAX_LIMIT {
+		filter.Limit = AUDIT_MAX_LIMIT
+	}
+
+	query := "SELECT id, timestamp, event, agent_id, username, remote_ip, details FROM audit_log WHERE " + strings.Join(conditions, " AND ") +
+		" ORDER BY timestamp DESC, id DESC LIMIT " + strconv.Itoa(filter.Limit)
+
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, query, args...)
+	defer results.Close()
+
+	for results.Next() {
+		var entry AuditEntry
+		if err := results.Scan(&entry.Id, &entry.Timestamp, &entry.Event, &entry.AgentId, &entry.Username, &entry.RemoteIP, &entry.Details); err != nil {
+			log.Println("Failed to read audit entry: ", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
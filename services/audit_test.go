@@ -0,0 +1,55 @@
+package services
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAuditRecord(t *testing.T) {
+
+	testDB.reset()
+	t.Cleanup(testDB.reset)
+
+	Audit.Record(AUDIT_LOGIN_FAILED, "", "o'brien\\", "10.0.0.1", "bad password for 'admin'")
+
+	recorded := testDB.argsOf("INSERT INTO audit_log")
+	if len(recorded) != 1 {
+		t.Fatalf("recorded %d audit events, want 1", len(recorded))
+	}
+	if want := []driver.Value{AUDIT_LOGIN_FAILED, "", "o'brien\\", "10.0.0.1", "bad password for 'admin'"}; !reflect.DeepEqual(recorded[0][1:], want) {
+		t.Errorf("audit event stored as %v, want %v", recorded[0][1:], want)
+	}
+}
+
+func TestAuditQuery(t *testing.T) {
+
+	testDB.reset()
+	t.Cleanup(testDB.reset)
+
+	testDB.answer("FROM audit_log", []string{"id", "timestamp", "event", "agent_id", "username", "remote_ip", "details"},
+		[]driver.Value{int64(2), int64(2000), AUDIT_LOGOUT, "1001", "maria", "10.0.0.1", ""},
+		[]driver.Value{int64(1), int64(1000), AUDIT_LOGIN, "1001", "maria", "10.0.0.1", ""})
+
+	entries := Audit.Query(AuditFilter{AgentId: "x' OR '1'='1", Event: AUDIT_LOGIN, From: 1000, To: 3000, Limit: 5000})
+	if len(entries) != 2 || entries[0].Id != 2 || entries[1].Event != AUDIT_LOGIN {
+		t.Errorf("Query returned %+v", entries)
+	}
+
+	queried := testDB.argsOf("FROM audit_log")
+	if len(queried) != 1 {
+		t.Fatalf("ran %d audit queries, want 1", len(queried))
+	}
+	if want := []driver.Value{"x' OR '1'='1", "x' OR '1'='1", AUDIT_LOGIN, int64(1000), int64(3000)}; !reflect.DeepEqual(queried[0], want) {
+		t.Errorf("audit query arguments = %v, want %v", queried[0], want)
+	}
+
+	testDB.mutex.Lock()
+	defer testDB.mutex.Unlock()
+	for _, statement := range testDB.statements {
+		if strings.Contains(statement, "audit_log") && (strings.Contains(statement, "'") || !strings.HasSuffix(statement, "LIMIT 1000")) {
+			t.Errorf("audit query %q does not use arguments or the maximum limit", statement)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"log"
+	"server/types"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+const (
+	AUTOREPLY_GREETING       = "greeting"
+	AUTOREPLY_OUT_OF_HOURS   = "out-of-hours"
+	AUTOREPLY_QUEUE_POSITION = "queue-position"
+)
+
+var AutoReplies AutoReplyEngine
+
+// AutoReplyEngine picks the automatic message for a conversation from the templates in autoreply_conf.ini.
+// Templates are looked up from the most specific section with a TEXT to the least specific one:
+// [kind.channel.language], [kind.language], [kind.channel] and [kind].
+type AutoReplyEngine struct {
+	Enabled               bool
+	QueuePositionInterval time.Duration
+	cfg                   *ini.File
+	lastPositions         map[string]int //map[conversationID]position
+}
+
+func (a *AutoReplyEngine) Init() {
+
+	a.lastPositions = make(map[string]int)
+
+	var err error
+	a.cfg, err = ini.Load("conf/autoreply_conf.ini")
+	if err != nil {
+		log.Println("Failed to read autoreply_conf file: ", err)
+		a.cfg = ini.Empty()
+	}
+
+	general := a.cfg.Section("general")
+	a.Enabled = general.Key("ENABLED").MustBool(true)
+	a.QueuePositionInterval = time.Duration(general.Key("QUEUE_POSITION_INTERVAL").MustInt(0)) * time.Second
+
+	if a.Enabled && a.QueuePositionInterval > 0 {
+		go a.sendQueuePositions()
+	}
+}
+
+// GetTemplate returns the text configured for the kind of auto-reply, or "" if it is missing or disabled
+func (a *AutoReplyEngine) GetTemplate(kind string, conversation *types.Conversation) string {
+
+	if !a.Enabled || a.cfg == nil {
+		return ""
+	}
+
+	channel := types.ChannelNames[conversation.Type]
+	language := conversation.Requirements[SKILL_LANGUAGE]
+
+	var sectionNames []string
+	if language != "" {
+		sectionNames = append(sectionNames, kind+"."+channel+"."+language, kind+"."+language)
+	}
+	sectionNames = append(sectionNames, kind+"."+channel, kind)
+
+	for _, name := range sectionNames {
+		section, err := a.cfg.GetSection(name)
+		if err != nil {
+			continue
+		}
+
+		// only the section's own keys, ini would inherit missing ones from the dotted parent sections
+		keys := section.KeysHash()
+
+		if _, set := keys["ENABLED"]; set && !section.Key("ENABLED").MustBool(true) {
+			return ""
+		}
+
+		// a section may only override other keys, the text then comes from a less specific one
+		if text, set := keys["TEXT"]; set {
+			return text
+		}
+	}
+
+	return ""
+}
+
+// GetGreeting returns the first automatic reply for a new conversation, depending on whether we are open
+func (a *AutoReplyEngine) GetGreeting(conversation *types.Conversation) string {
+
+	kind := AUTOREPLY_GREETING
+	if !Calendars.IsOpen(conversation, time.Now()) {
+		kind = AUTOREPLY_OUT_OF_HOURS
+	}
+
+	return Omnichannel.ExpandPlaceholders(a.GetTemplate(kind, conversation), conversation, nil)
+}
+
+// sendQueuePositions periodically tells waiting customers their position in the queue when it changes
+func (a *AutoReplyEngine) sendQueuePositions() {
+
+	for range time.Tick(a.QueuePositionInterval) {
+
+		conversations := Omnichannel.GetUnassignedConversations()
+		QueueManager.SortByPriority(conversations)
+
+		positions := make(map[string]int) //map[queue]lastPosition
+		waiting := make(map[string]bool)
+
+		for _, conversation := range conversations {
+			positions[conversation.Queue]++
+			position := positions[conversation.Queue]
+			waiting[conversation.Id] = true
+
+			changed := a.lastPositions[conversation.Id] != position
+			a.lastPositions[conversation.Id] = position
+
+			if !changed {
+				continue
+			}
+
+			text := a.GetTemplate(AUTOREPLY_QUEUE_POSITION, conversation)
+			if text == "" {
+				continue
+			}
+
+			text = strings.ReplaceAll(text, "{{queue.position}}", strconv.Itoa(position))
+			text = Omnichannel.ExpandPlaceholders(text, conversation, nil)
+
+			message := types.Message{Type: types.Text, Text: text, Timestamp: uint(time.Now().UnixMilli()), Status: types.Sent}
+			Omnichannel.sendMessage(conversation.Id, message, true)
+		}
+
+		for conversationID := range a.lastPositions {
+			if !waiting[conversationID] {
+				delete(a.lastPositions, conversationID)
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"server/types"
+	"testing"
+
+	"github.com/go-ini/ini"
+)
+
+const testAutoreplyConf = `
+[greeting]
+TEXT = "Hello"
+
+[greeting.whatsapp]
+TEXT = "Hello from WhatsApp"
+
+[greeting.de]
+TEXT = "Hallo"
+
+[greeting.whatsapp.de]
+TEXT = "Hallo von WhatsApp"
+
+[greeting.viber.de]
+DELAY = "5"
+
+[greeting.fr]
+ENABLED = "false"
+
+[greeting.whatsapp.fr]
+TEXT = "Bonjour"
+
+[queue-position.whatsapp]
+TEXT = "You are number {{queue.position}}"
+`
+
+func TestGetTemplate(t *testing.T) {
+
+	cfg, err := ini.Load([]byte(testAutoreplyConf))
+	if err != nil {
+		t.Fatalf("invalid test config: %v", err)
+	}
+	engine := &AutoReplyEngine{Enabled: true, cfg: cfg}
+
+	tests := []struct {
+		name     string
+		kind     string
+		channel  types.ChannelType
+		language string
+		text     string
+	}{
+		{"channel and language", AUTOREPLY_GREETING, types.WhatsApp, "de", "Hallo von WhatsApp"},
+		{"language", AUTOREPLY_GREETING, types.Viber, "en", "Hello"},
+		{"section without text falls back", AUTOREPLY_GREETING, types.Viber, "de", "Hallo"},
+		{"channel", AUTOREPLY_GREETING, types.WhatsApp, "", "Hello from WhatsApp"},
+		{"kind", AUTOREPLY_GREETING, types.Viber, "", "Hello"},
+		{"disabled for the language", AUTOREPLY_GREETING, types.Viber, "fr", ""},
+		{"enabled by a more specific section", AUTOREPLY_GREETING, types.WhatsApp, "fr", "Bonjour"},
+		{"only for another channel", AUTOREPLY_QUEUE_POSITION, types.Viber, "", ""},
+		{"missing kind", AUTOREPLY_OUT_OF_HOURS, types.WhatsApp, "de", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			conversation := &types.Conversation{Id: "c1", Type: test.channel, Requirements: map[string]string{}}
+			if test.language != "" {
+				conversation.Requirements[SKILL_LANGUAGE] = test.language
+			}
+
+			if text := engine.GetTemplate(test.kind, conversation); text != test.text {
+				t.Errorf("GetTemplate(%s) = %q, want %q", test.kind, text, test.text)
+			}
+		})
+	}
+
+	engine.Enabled = false
+	if text := engine.GetTemplate(AUTOREPLY_GREETING, &types.Conversation{Type: types.WhatsApp}); text != "" {
+		t.Errorf("disabled auto-replies returned %q", text)
+	}
+}
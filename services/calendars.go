@@ -0,0 +1,253 @@
+package services
+
+import (
+	"log"
+	"server/db"
+	"server/types"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+const (
+	DEFERRED_CHECK_INTERVAL = time.Minute
+	HOLIDAY_DATE_FORMAT     = "2006-01-02"
+)
+
+var Calendars CalendarManager
+
+type openingInterval struct {
+	open  int //minutes after midnight
+	close int //minutes after midnight
+}
+
+// BusinessCalendar is a weekly schedule in a timezone with a list of holidays on which we are closed all day
+type BusinessCalendar struct {
+	Name     string
+	Location *time.Location
+	Schedule map[time.Weekday][]openingInterval
+	Holidays map[string]bool //map[YYYY-MM-DD]closed
+}
+
+type CalendarManager struct {
+	Calendars        map[string]*BusinessCalendar //map[calendarName]calendar
+	ChannelCalendars map[types.ChannelType]string //map[channelType]calendarName
+	DefaultCalendar  string
+}
+
+var weekdayKeys = map[time.Weekday]string{
+	time.Monday: "MONDAY", time.Tuesday: "TUESDAY", time.Wednesday: "WEDNESDAY", time.Thursday: "THURSDAY",
+	time.Friday: "FRIDAY", time.Saturday: "SATURDAY", time.Sunday: "SUNDAY",
+}
+
+func (c *CalendarManager) Init() {
+
+	c.Calendars = make(map[string]*BusinessCalendar)
+	c.ChannelCalendars = make(map[types.ChannelType]string)
+
+	cfg, err := ini.Load("conf/calendars_conf.ini")
+	if err != nil {
+		log.Println("Failed to read calendars_conf file: ", err)
+		return
+	}
+
+	for _, section := range cfg.Sections() {
+		if section.Name() == ini.DefaultSection {
+			c.DefaultCalendar = section.Key("DEFAULT_CALENDAR").String()
+			continue
+		}
+
+		calendar := BusinessCalendar{Name: section.Name(), Location: time.Local, Schedule: make(map[time.Weekday][]openingInterval), Holidays: make(map[string]bool)}
+
+		if timezone := section.Key("TIMEZONE").String(); timezone != "" {
+			if location, err := time.LoadLocation(timezone); err == nil {
+				calendar.Location = location
+			} else {
+				log.Println("Unknown timezone in calendar ", calendar.Name, ": ", err)
+			}
+		}
+
+		for weekday, key := range weekdayKeys {
+			for _, hours := range section.Key(key).Strings(",") {
+				if interval := strings.Split(hours, "-"); len(interval) == 2 {
+					calendar.Schedule[weekday] = append(calendar.Schedule[weekday], openingInterval{open: parseClock(interval[0], 0), close: parseClock(interval[1], 24*60)})
+				}
+			}
+		}
+
+		for _, holiday := range section.Key("HOLIDAYS").Strings(",") {
+			calendar.Holidays[holiday] = true
+		}
+
+		c.Calendars[calendar.Name] = &calendar
+	}
+
+	if cfg, err := ini.Load("conf/channels_conf.ini"); err == nil {
+		for channelType, channelName := range types.ChannelNames {
+			if calendarName := cfg.Section(channelName).Key("CALENDAR").String(); calendarName != "" {
+				c.ChannelCalendars[channelType] = calendarName
+			}
+		}
+	}
+
+	go c.releaseDeferredConversations()
+}
+
+// parseClock converts "HH:MM" to minutes after midnight
+func parseClock(clock string, fallback int) int {
+
+	parts := strings.Split(strings.TrimSpace(clock), ":")
+	if len(parts) != 2 {
+		return fallback
+	}
+
+	hours, err1 := strconv.Atoi(parts[0])
+	minutes, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return fallback
+	}
+
+	return hours*60 + minutes
+}
+
+// IsOpen reports whether the time falls inside the weekly schedule and is not a holiday
+func (b *BusinessCalendar) IsOpen(t time.Time) bool {
+
+	local := t.In(b.Location)
+
+	if b.Holidays[local.Format(HOLIDAY_DATE_FORMAT)] {
+		return false
+	}
+
+	minutes := local.Hour()*60 + local.Minute()
+	for _, interval := range b.Schedule[local.Weekday()] {
+		if minutes >= interval.open && minutes < interval.close {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BusinessTimeBetween counts only the time we are open, so timers are paused outside business hours
+func (b *BusinessCalendar) BusinessTimeBetween(start time.Time, end time.Time) time.Duration {
+
+	var total time.Duration
+
+	for day := start.In(b.Location); day.Before(end); {
+		midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, b.Location)
+
+		if !b.Holidays[day.Format(HOLIDAY_DATE_FORMAT)] {
+			for _, interval := range b.Schedule[day.Weekday()] {
+				// by the wall clock, on a daylight saving change the day is not 24 hours long
+				opening := time.Date(day.Year(), day.Month(), day.Day(), interval.open/60, interval.open%60, 0, 0, b.Location)
+				closing := time.Date(day.Year(), day.Month(), day.Day(), interval.close/60, interval.close%60, 0, 0, b.Location)
+
+				if opening.Before(start) {
+					opening = start
+				}
+				if closing.After(end) {
+					closing = end
+				}
+				if closing.After(opening) {
+					total += closing.Sub(opening)
+				}
+			}
+		}
+
+		day = midnight.AddDate(0, 0, 1)
+	}
+
+	return total
+}
+
+// GetCalendar returns the calendar of the conversation's queue, then of its channel, then the default one; nil means always open
+func (c *CalendarManager) GetCalendar(conversation *types.Conversation) *BusinessCalendar {
+
+	if queue := QueueManager.Queues[conversation.Queue]; queue != nil && queue.Calendar != "" {
+		if calendar := c.Calendars[queue.Calendar]; calendar != nil {
+			return calendar
+		}
+	}
+
+	if calendar := c.Calendars[c.ChannelCalendars[conversation.Type]]; calendar != nil {
+		return calendar
+	}
+
+	return c.Calendars[c.DefaultCalendar]
+}
+
+func (c *CalendarManager) IsOpen(conversation *types.Conversation, t time.Time) bool {
+
+	calendar := c.GetCalendar(conversation)
+	if calendar == nil {
+		return true
+	}
+
+	return calendar.IsOpen(t)
+}
+
+// BusinessTimeBetween measures a timer for the conversation, excluding closed hours of its calendar
+func (c *CalendarManager) BusinessTimeBetween(conversation *types.Conversation, start time.Time, end time.Time) time.Duration {
+
+	calendar := c.GetCalendar(conversation)
+	if calendar == nil {
+		return end.Sub(start)
+	}
+
+	return calendar.BusinessTimeBetween(start, end)
+}
+
+// ShouldDefer reports whether a new conversation has to wait for opening hours before it is routed
+func (c *CalendarManager) ShouldDefer(conversation *types.Conversation) bool {
+
+	queue := QueueManager.Queues[conversation.Queue]
+	if queue == nil || !queue.DeferOutOfHours {
+		return false
+	}
+
+	return !c.IsOpen(conversation, time.Now())
+}
+
+// releaseDeferredConversations routes deferred conversations once their calendar opens
+func (c *CalendarManager) releaseDeferredConversations() {
+
+	for range time.Tick(DEFERRED_CHECK_INTERVAL) {
+		for _, conversation := range Omnichannel.GetDeferredConversations() {
+			if c.IsOpen(conversation, time.Now()) {
+				log.Println("Releasing deferred conversation ", conversation.Id)
+				Omnichannel.UndeferConversation(conversation)
+				Router.RouteConversation(conversation)
+			}
+		}
+	}
+}
+
+func (o *OmniChannel) GetDeferredConversations() []*types.Conversation {
+
+	o.conversationsMutex.RLock()
+	defer o.conversationsMutex.RUnlock()
+
+	var conversations []*types.Conversation
+
+	for i := range o.ActiveConversations {
+		if o.ActiveConversations[i].State == types.Deferred {
+			conversations = append(conversations, o.ActiveConversations[i])
+		}
+	}
+
+	return conversations
+}
+
+func (o *OmniChannel) UndeferConversation(conversation *types.Conversation) {
+
+	o.conversationsMutex.Lock()
+	conversation.State = types.Unassigned
+	conversation.Queued_Timestamp = uint(time.Now().UnixMilli())
+	o.conversationsMutex.Unlock()
+
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "UPDATE conversations SET state="+strconv.Itoa(int(types.Unassigned))+" WHERE id='"+conversation.Id+"'")
+	defer results.Close()
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// newTestCalendar is open 09:00-17:00 on the given weekdays in Europe/Sarajevo, closed on 2026-05-01
+func newTestCalendar(t *testing.T, weekdays ...time.Weekday) *BusinessCalendar {
+
+	location, err := time.LoadLocation("Europe/Sarajevo")
+	if err != nil {
+		t.Fatalf("failed to load the calendar timezone: %v", err)
+	}
+
+	calendar := &BusinessCalendar{Name: "test", Location: location, Schedule: make(map[time.Weekday][]openingInterval), Holidays: map[string]bool{"2026-05-01": true}}
+	for _, weekday := range weekdays {
+		calendar.Schedule[weekday] = []openingInterval{{open: parseClock("09:00", 0), close: parseClock("17:00", 24*60)}}
+	}
+
+	return calendar
+}
+
+func TestBusinessTimeBetween(t *testing.T) {
+
+	workdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	everyDay := append(workdays, time.Saturday, time.Sunday)
+
+	tests := []struct {
+		name     string
+		weekdays []time.Weekday
+		start    string
+		end      string
+		want     time.Duration
+	}{
+		{"within opening hours", workdays, "2026-03-02 10:00", "2026-03-02 11:30", 90 * time.Minute},
+		{"before opening", workdays, "2026-03-02 07:00", "2026-03-02 10:00", time.Hour},
+		{"holiday", workdays, "2026-05-01 08:00", "2026-05-01 18:00", 0},
+		{"weekend", workdays, "2026-03-07 08:00", "2026-03-08 18:00", 0},
+		{"overnight close", workdays, "2026-03-02 16:00", "2026-03-03 10:00", 2 * time.Hour},
+		{"over a weekend", workdays, "2026-03-06 16:00", "2026-03-09 10:00", 2 * time.Hour},
+		{"daylight saving starts", everyDay, "2026-03-29 08:00", "2026-03-29 17:00", 8 * time.Hour},
+		{"daylight saving ends", everyDay, "2026-10-25 08:00", "2026-10-25 12:00", 3 * time.Hour},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			calendar := newTestCalendar(t, test.weekdays...)
+
+			start, err1 := time.ParseInLocation("2006-01-02 15:04", test.start, calendar.Location)
+			end, err2 := time.ParseInLocation("2006-01-02 15:04", test.end, calendar.Location)
+			if err1 != nil || err2 != nil {
+				t.Fatalf("invalid test interval %s - %s", test.start, test.end)
+			}
+
+			if got := calendar.BusinessTimeBetween(start, end); got != test.want {
+				t.Errorf("BusinessTimeBetween(%s, %s) = %v, want %v", test.start, test.end, got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsOpen(t *testing.T) {
+
+	calendar := newTestCalendar(t, time.Monday, time.Friday)
+
+	tests := []struct {
+		at   string
+		open bool
+	}{
+		{"2026-03-02 09:00", true},
+		{"2026-03-02 16:59", true},
+		{"2026-03-02 17:00", false},
+		{"2026-03-03 12:00", false},
+		{"2026-05-01 12:00", false},
+	}
+
+	for _, test := range tests {
+		at, err := time.ParseInLocation("2006-01-02 15:04", test.at, calendar.Location)
+		if err != nil {
+			t.Fatalf("invalid test time %s", test.at)
+		}
+
+		if open := calendar.IsOpen(at.UTC()); open != test.open {
+			t.Errorf("IsOpen(%s) = %v, want %v", test.at, open, test.open)
+		}
+	}
+}
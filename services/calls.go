@@ -0,0 +1,137 @@
+package services
+
+import (
+	"log"
+	"server/auths"
+	"server/types"
+	"strings"
+	"sync"
+	"time"
+)
+
+// channels whose contact id is the customer's phone number
+var phoneChannels = []types.ChannelType{types.WhatsApp}
+
+var Calls CallManager
+
+// customerCall is a click-to-call in progress
+type customerCall struct {
+	conversationID string
+	agentID        string
+	number         string
+	answered       time.Time
+}
+
+// CallManager places voice calls from a chat conversation to the customer's phone and reports their progress to the agent
+type CallManager struct {
+	calls map[string]*customerCall //map[callID]call
+	mutex sync.Mutex
+}
+
+// phoneNumberOf returns the number to dial for a phone channel contact, in +E.164 form unless the channel
+// stored it as a local number, e.g. +447700900123 for whatsapp:+447700900123
+func phoneNumberOf(contactID string) string {
+
+	number := strings.TrimPrefix(strings.TrimSpace(contactID), "whatsapp:")
+
+	return strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(number)
+}
+
+// CallCustomer rings the agent's extension and then the customer's phone number from their contacts
+func (c *CallManager) CallCustomer(conversationID string, agentID string) (callID string, failedMsg string) {
+
+	conversation := Omnichannel.FindActiveConversationByID(conversationID)
+	if conversation == nil {
+		conversation = Omnichannel.FindConversationByID(conversationID)
+	}
+	if conversation == nil {
+		return "", "Conversation not found"
+	}
+
+	number := ""
+	for _, channelType := range phoneChannels {
+		if number = phoneNumberOf(Omnichannel.FindCustomerUniqueIdByChannel(conversation.CustomerID, channelType)); number != "" {
+			break
+		}
+	}
+	if number == "" {
+		return "", "Customer has no phone number"
+	}
+
+	dialer, ok := TcpServer.loginAuthenticator.(auths.Dialer)
+	if !ok {
+		return "", "Login authenticator can not place calls"
+	}
+
+	callerName := number
+	if customer := Omnichannel.FindCustomerByID(conversation.CustomerID); customer != nil && customer.Name != "" {
+		callerName = customer.Name
+	}
+
+	// held until the call is registered, so its first progress waits for it
+	c.mutex.Lock()
+	if c.calls == nil {
+		c.calls = make(map[string]*customerCall)
+	}
+	if callID, failedMsg = dialer.CallNumber(agentID, number, callerName); failedMsg == "" {
+		c.calls[callID] = &customerCall{conversationID: conversationID, agentID: agentID, number: number}
+	}
+	c.mutex.Unlock()
+
+	if failedMsg != "" {
+		return "", failedMsg
+	}
+
+	message := Omnichannel.createEventMessage(EVENT_CALL_STARTED, uint(time.Now().UnixMilli()))
+	message.Text = number
+	message.Author = agentID
+	Omnichannel.AddNewMessage(conversationID, message)
+
+	return callID, ""
+}
+
+// CallProgress is called by the Asterisk authenticator as a call progresses, the call ends with failed or ended
+func (c *CallManager) CallProgress(callID string, progress string, details string) {
+
+	c.mutex.Lock()
+	call := c.calls[callID]
+	if call != nil {
+		if progress == auths.CALL_CUSTOMER_ANSWER {
+			call.answered = time.Now()
+		}
+		if progress == auths.CALL_FAILED || progress == auths.CALL_ENDED {
+			delete(c.calls, callID)
+		}
+	}
+	c.mutex.Unlock()
+
+	if call == nil {
+		return
+	}
+
+	jsonData := make(map[string]interface{})
+	jsonData["event"] = EVENT_CALL_PROGRESS
+	jsonData["callID"] = callID
+	jsonData["conversationID"] = call.conversationID
+	jsonData["progress"] = progress
+	jsonData["details"] = details
+	TcpServer.SendEventToAgents(jsonData, call.agentID)
+	Monitor.Publish(call.conversationID, jsonData)
+
+	if progress == auths.CALL_FAILED || progress == auths.CALL_ENDED {
+
+		message := Omnichannel.createEventMessage(EVENT_CALL_ENDED, uint(time.Now().UnixMilli()))
+		message.Author = call.agentID
+		if call.answered.IsZero() {
+			message.Text = "not answered"
+			if details != "" {
+				message.Text += ": " + details
+			}
+		} else {
+			message.Text = "duration " + time.Since(call.answered).Round(time.Second).String()
+		}
+		Omnichannel.AddNewMessage(call.conversationID, message)
+
+		log.Println("Call ", callID, " to ", call.number, " ended, ", message.Text)
+	}
+}
@@ -0,0 +1,24 @@
+package services
+
+import "testing"
+
+func TestPhoneNumberOf(t *testing.T) {
+
+	tests := []struct {
+		contactID string
+		number    string
+	}{
+		{"whatsapp:+447700900123", "+447700900123"},
+		{"whatsapp:+14155550123", "+14155550123"},
+		{"whatsapp:+38761123456", "+38761123456"},
+		{"061123456", "061123456"},
+		{" whatsapp:+49 30 1234-5678 ", "+493012345678"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if number := phoneNumberOf(test.contactID); number != test.number {
+			t.Errorf("phoneNumberOf(%q) = %q, want %q", test.contactID, number, test.number)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"net"
+	"regexp"
+	"server/db"
+	"server/types"
+	"strconv"
+	"strings"
+)
+
+var placeholderPattern = regexp.MustCompile(`{{\s*([a-zA-Z_]+)\.([a-zA-Z_]+)\s*}}`)
+
+func (o *OmniChannel) GetCannedResponses(agent *types.Agent) []*types.CannedResponse {
+
+	var cannedResponses []*types.CannedResponse
+
+	TcpServer.agentsMutex.RLock()
+	queues := append([]string(nil), agent.Queues...)
+	TcpServer.agentsMutex.RUnlock()
+
+	owners := "?"
+	args := []interface{}{agent.Id}
+	for _, queue := range queues {
+		owners += ",?"
+		args = append(args, queue)
+	}
+	args = append(args, agent.Id)
+
+	query := "SELECT id, scope, owner, shortcut, title, body FROM canned_responses WHERE scope=" + strconv.Itoa(int(types.GlobalScope)) +
+		" OR (scope=" + strconv.Itoa(int(types.QueueScope)) + " AND owner IN (" + owners + "))" +
+		" OR (scope=" + strconv.Itoa(int(types.PersonalScope)) + " AND owner=?)" +
+		" ORDER BY scope DESC, shortcut"
+
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, query, args...)
+	defer results.Close()
+
+	for results.Next() {
+		var cannedResponse types.CannedResponse
+		if err := results.Scan(&cannedResponse.Id, &cannedResponse.Scope, &cannedResponse.Owner, &cannedResponse.Shortcut, &cannedResponse.Title, &cannedResponse.Body); err == nil {
+			cannedResponses = append(cannedResponses, &cannedResponse)
+		}
+	}
+
+	return cannedResponses
+}
+
+func (o *OmniChannel) GetCannedResponse(id int) *types.CannedResponse {
+
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "SELECT id, scope, owner, shortcut, title, body FROM canned_responses WHERE id="+strconv.Itoa(id))
+	defer results.Close()
+
+	if results.Next() {
+		var cannedResponse types.CannedResponse
+		if err := results.Scan(&cannedResponse.Id, &cannedResponse.Scope, &cannedResponse.Owner, &cannedResponse.Shortcut, &cannedResponse.Title, &cannedResponse.Body); err == nil {
+			return &cannedResponse
+		}
+	}
+
+	return nil
+}
+
+// FindCannedResponse looks up a shortcut visible to the agent, personal responses win over queue and global ones
+func (o *OmniChannel) FindCannedResponse(agent *types.Agent, shortcut string) *types.CannedResponse {
+
+	shortcut = strings.TrimPrefix(shortcut, "/")
+
+	for _, cannedResponse := range o.GetCannedResponses(agent) {
+		if cannedResponse.Shortcut == shortcut {
+			return cannedResponse
+		}
+	}
+
+	return nil
+}
+
+func (o *OmniChannel) AddCannedResponse(cannedResponse *types.CannedResponse) (failedMsg string) {
+
+	query := "INSERT INTO canned_responses(scope, owner, shortcut, title, body) VALUES(?, ?, ?, ?, ?)"
+
+	if err := db.DBConnector.Execute(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, query, int(cannedResponse.Scope), cannedResponse.Owner, cannedResponse.Shortcut, cannedResponse.Title, cannedResponse.Body); err != nil {
+		return "Failed to save canned response: " + err.Error()
+	}
+
+	return ""
+}
+
+func (o *OmniChannel) UpdateCannedResponse(cannedResponse *types.CannedResponse) (failedMsg string) {
+
+	query := "UPDATE canned_responses SET shortcut=?, title=?, body=? WHERE id=?"
+
+	if err := db.DBConnector.Execute(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, query, cannedResponse.Shortcut, cannedResponse.Title, cannedResponse.Body, cannedResponse.Id); err != nil {
+		return "Failed to save canned response: " + err.Error()
+	}
+
+	return ""
+}
+
+func (o *OmniChannel) DeleteCannedResponse(id int) (failedMsg string) {
+
+	if err := db.DBConnector.Execute(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "DELETE FROM canned_responses WHERE id=?", id); err != nil {
+		return "Failed to delete canned response: " + err.Error()
+	}
+
+	return ""
+}
+
+// CanEditCannedResponse allows agents to manage their personal responses, global and queue responses need a supervisor
+func CanEditCannedResponse(agent *types.Agent, cannedResponse *types.CannedResponse) bool {
+
+	if agent == nil {
+		return false
+	}
+
+	if cannedResponse.Scope == types.PersonalScope {
+		return cannedResponse.Owner == agent.Id
+	}
+
+	return agent.HasRole(types.RoleSupervisor) || agent.HasRole(types.RoleAdmin)
+}
+
+// ExpandPlaceholders replaces {{customer.*}}, {{agent.*}} and {{conversation.*}} placeholders with the conversation's data.
+// Unknown placeholders and customer attributes the customer does not have are left as they are.
+func (o *OmniChannel) ExpandPlaceholders(text string, conversation *types.Conversation, agent *types.Agent) string {
+
+	return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+
+		parts := placeholderPattern.FindStringSubmatch(placeholder)
+		object, field := strings.ToLower(parts[1]), strings.ToLower(parts[2])
+
+		switch object {
+		case "customer":
+			var customer *types.Customer
+			if conversation != nil {
+				customer = o.FindCustomerByID(conversation.CustomerID)
+			}
+			if field == "name" {
+				if customer == nil {
+					return ""
+				}
+				return customer.Name
+			}
+			if customer != nil {
+				if value, ok := customer.Attributes[field]; ok {
+					return value
+				}
+			}
+		case "agent":
+			if field != "name" && field != "id" {
+				break
+			}
+			if agent == nil {
+				return ""
+			} else if field == "name" {
+				return agent.Name
+			}
+			return agent.Id
+		case "conversation":
+			if field != "id" && field != "queue" && field != "channel" {
+				break
+			}
+			if conversation == nil {
+				return ""
+			} else if field == "id" {
+				return conversation.Id
+			} else if field == "queue" {
+				return conversation.Queue
+			}
+			return types.ChannelNames[conversation.Type]
+		}
+
+		return placeholder
+	})
+}
+
+func (s *TCPServer) handleCannedResponseRequest(con net.Conn, action string, parsedData map[string]interface{}) {
+
+	agent := s.GetAgentBySocket(con)
+	if agent == nil {
+		parsedData["success"] = 0
+		parsedData["failed_message"] = "Agent is not logged in"
+		return
+	}
+
+	failedMsg := ""
+
+	if action == CMD_GET_CANNED_RESPONSES {
+
+		parsedData["canned_responses"] = Omnichannel.GetCannedResponses(agent)
+
+	} else if action == CMD_FIND_CANNED_RESPONSE {
+
+		shortcut := parsedData["shortcut"].(string)
+
+		if cannedResponse := Omnichannel.FindCannedResponse(agent, shortcut); cannedResponse != nil {
+			parsedData["canned_response"] = cannedResponse
+		} else {
+			failedMsg = "Canned response not found"
+		}
+
+	} else if action == CMD_ADD_CANNED_RESPONSE {
+
+		cannedResponse := types.CannedResponse{
+			Scope:    types.CannedResponseScope(parsedData["scope"].(float64)),
+			Shortcut: strings.TrimPrefix(parsedData["shortcut"].(string), "/"),
+			Title:    parsedData["title"].(string),
+			Body:     parsedData["body"].(string),
+		}
+
+		if cannedResponse.Scope == types.PersonalScope {
+			cannedResponse.Owner = agent.Id
+		} else if cannedResponse.Scope == types.QueueScope {
+			cannedResponse.Owner, _ = parsedData["queue"].(string)
+		}
+
+		if cannedResponse.Scope == types.QueueScope && QueueManager.Queues[cannedResponse.Owner] == nil {
+			failedMsg = "Unknown queue"
+		} else if !CanEditCannedResponse(agent, &cannedResponse) {
+			failedMsg = "Permission denied"
+		} else {
+			failedMsg = Omnichannel.AddCannedResponse(&cannedResponse)
+		}
+
+	} else if action == CMD_UPDATE_CANNED_RESPONSE || action == CMD_DELETE_CANNED_RESPONSE {
+
+		id := int(parsedData["id"].(float64))
+
+		if cannedResponse := Omnichannel.GetCannedResponse(id); cannedResponse == nil {
+			failedMsg = "Canned response not found"
+		} else if !CanEditCannedResponse(agent, cannedResponse) {
+			failedMsg = "Permission denied"
+		} else if action == CMD_DELETE_CANNED_RESPONSE {
+			failedMsg = Omnichannel.DeleteCannedResponse(id)
+		} else {
+			if shortcut, ok := parsedData["shortcut"].(string); ok {
+				cannedResponse.Shortcut = strings.TrimPrefix(shortcut, "/")
+			}
+			if title, ok := parsedData["title"].(string); ok {
+				cannedResponse.Title = title
+			}
+			if body, ok := parsedData["body"].(string); ok {
+				cannedResponse.Body = body
+			}
+
+			failedMsg = Omnichannel.UpdateCannedResponse(cannedResponse)
+		}
+	}
+
+	if failedMsg == "" {
+		parsedData["success"] = 1
+	} else {
+		parsedData["success"] = 0
+		parsedData["failed_message"] = failedMsg
+	}
+}
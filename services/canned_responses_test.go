@@ -0,0 +1,102 @@
+package services
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"server/types"
+	"testing"
+)
+
+// setupCannedResponses gives the agent a conversation with a known customer and canned responses of every scope
+func setupCannedResponses(t *testing.T) (*types.Agent, *fakeChannel) {
+
+	agent := newTestAgent("1001", "sales")
+	agent.Name = "Maria"
+	conversation := newTestConversation("c1", "sales")
+	conversation.State, conversation.ConnectedAgent = types.Assigned, agent.Id
+
+	setupRouting(t, LeastBusyStrategy{}, []*types.Agent{agent}, conversation)
+	useFakeAuthenticator(t)
+	Permissions.Init()
+	channel := useFakeChannel(t)
+
+	customers := Omnichannel.Customers
+	Omnichannel.Customers = []*types.Customer{{Id: conversation.CustomerID, Name: "Ana",
+		Contacts: []types.CustomerContact{{Channel_Type: types.WhatsApp, Channel_Id: "351910000000"}}}}
+	t.Cleanup(func() { Omnichannel.Customers = customers })
+
+	testDB.answer("FROM conversations WHERE id=?", []string{"customer_id", "type", "connected_agent", "created_timestamp", "state", "queue"},
+		[]driver.Value{conversation.CustomerID, int64(types.WhatsApp), agent.Id, int64(1), int64(types.Assigned), "sales"})
+	testDB.answer("FROM canned_responses", []string{"id", "scope", "owner", "shortcut", "title", "body"},
+		[]driver.Value{int64(3), int64(types.PersonalScope), agent.Id, "hello", "Hello", "Hi {{customer.name}}, {{agent.name}} here"},
+		[]driver.Value{int64(2), int64(types.QueueScope), "sales", "hello", "Hello", "Hello from sales"},
+		[]driver.Value{int64(1), int64(types.GlobalScope), "", "bye", "Bye", "Goodbye {{customer.name}}"})
+
+	return agent, channel
+}
+
+func TestFindCannedResponse(t *testing.T) {
+
+	agent, _ := setupCannedResponses(t)
+
+	tests := []struct {
+		shortcut string
+		want     int
+	}{
+		{"/hello", 3},
+		{"hello", 3},
+		{"/bye", 1},
+		{"/unknown", 0},
+	}
+
+	for _, test := range tests {
+		id := 0
+		if cannedResponse := Omnichannel.FindCannedResponse(agent, test.shortcut); cannedResponse != nil {
+			id = cannedResponse.Id
+		}
+		if id != test.want {
+			t.Errorf("FindCannedResponse(%q) = %d, want %d", test.shortcut, id, test.want)
+		}
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		command   map[string]interface{}
+		sent      []string
+		failedMsg string
+	}{
+		{"typed text", map[string]interface{}{"text": "Your code is {{customer.name}}"}, []string{"Your code is {{customer.name}}"}, ""},
+		{"shortcut", map[string]interface{}{"shortcut": "/hello"}, []string{"Hi Ana, Maria here"}, ""},
+		{"unknown shortcut", map[string]interface{}{"shortcut": "/unknown", "text": ""}, nil, "Canned response not found"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			agent, channel := setupCannedResponses(t)
+
+			command := map[string]interface{}{"action": CMD_SEND_MESSAGE, "conversationID": "c1", "agentID": agent.Id}
+			for key, value := range test.command {
+				command[key] = value
+			}
+			reply := sendCommand(t, agent, command)
+
+			if test.failedMsg != "" && (reply["success"] != float64(0) || reply["failed_message"] != test.failedMsg) {
+				t.Errorf("reply = %v, want success 0 with %q", reply, test.failedMsg)
+			}
+			if test.failedMsg == "" && reply["failed_message"] != nil {
+				t.Errorf("reply = %v, want the message sent", reply)
+			}
+
+			if sent := channel.sentMessages(); !reflect.DeepEqual(sent, test.sent) {
+				t.Errorf("sent %q, want %q", sent, test.sent)
+			}
+			if stored := testDB.count("INSERT INTO messages"); stored != len(test.sent) {
+				t.Errorf("stored %d messages, want %d", stored, len(test.sent))
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"server/types"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeDB stands in for the mysql driver the db package opens. Statements succeed without rows unless the test
+// gave an answer or a failure for them, the statements and their arguments are recorded so tests can check what was written.
+type fakeDB struct {
+	statements []string
+	args       [][]driver.Value
+	answers    []fakeAnswer
+	failures   []string
+	mutex      sync.Mutex
+}
+
+// fakeAnswer are the rows returned to the queries containing text
+type fakeAnswer struct {
+	text    string
+	columns []string
+	rows    [][]driver.Value
+}
+
+var testDB = &fakeDB{}
+
+func init() {
+	sql.Register("mysql", testDB)
+}
+
+func (f *fakeDB) Open(name string) (driver.Conn, error) {
+	return fakeDBConn{f}, nil
+}
+
+// count returns how many recorded statements contain the text
+func (f *fakeDB) count(text string) int {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	count := 0
+	for _, statement := range f.statements {
+		if strings.Contains(statement, text) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// argsOf returns the arguments of every recorded statement that contains the text
+func (f *fakeDB) argsOf(text string) [][]driver.Value {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var args [][]driver.Value
+	for i, statement := range f.statements {
+		if strings.Contains(statement, text) {
+			args = append(args, f.args[i])
+		}
+	}
+
+	return args
+}
+
+// answer makes the queries containing the text return the rows
+func (f *fakeDB) answer(text string, columns []string, rows ...[]driver.Value) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.answers = append(f.answers, fakeAnswer{text, columns, rows})
+}
+
+// fail makes the statements containing the text return an error
+func (f *fakeDB) fail(text string) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.failures = append(f.failures, text)
+}
+
+func (f *fakeDB) reset() {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.statements = nil
+	f.args = nil
+	f.answers = nil
+	f.failures = nil
+}
+
+type fakeDBConn struct {
+	db *fakeDB
+}
+
+func (c fakeDBConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeDBStmt{c.db, query}, nil
+}
+
+func (c fakeDBConn) Close() error {
+	return nil
+}
+
+// Ping is recorded as a PING statement, so tests can fail it like any other statement
+func (c fakeDBConn) Ping(ctx context.Context) error {
+	_, err := fakeDBStmt{c.db, "PING"}.record(nil)
+	return err
+}
+
+func (c fakeDBConn) Begin() (driver.Tx, error) {
+	return fakeDBTx{}, nil
+}
+
+type fakeDBTx struct{}
+
+func (fakeDBTx) Commit() error {
+	return nil
+}
+
+func (fakeDBTx) Rollback() error {
+	return nil
+}
+
+type fakeDBStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeDBStmt) Close() error {
+	return nil
+}
+
+func (s fakeDBStmt) NumInput() int {
+	return -1
+}
+
+// record remembers the statement and returns the rows or the error the test gave for it
+func (s fakeDBStmt) record(args []driver.Value) (*fakeDBRows, error) {
+
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+
+	s.db.statements = append(s.db.statements, s.query)
+	s.db.args = append(s.db.args, args)
+
+	for _, text := range s.db.failures {
+		if strings.Contains(s.query, text) {
+			return nil, errors.New("fake statement failure")
+		}
+	}
+
+	for _, answer := range s.db.answers {
+		if strings.Contains(s.query, answer.text) {
+			return &fakeDBRows{columns: answer.columns, rows: append([][]driver.Value(nil), answer.rows...)}, nil
+		}
+	}
+
+	return &fakeDBRows{}, nil
+}
+
+func (s fakeDBStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if _, err := s.record(args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeDBStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.record(args)
+}
+
+type fakeDBRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeDBRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeDBRows) Close() error {
+	return nil
+}
+
+func (r *fakeDBRows) Next(dest []driver.Value) error {
+
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
+
+// fakeSocket is an agent's connection that keeps the events sent to the agent
+type fakeSocket struct {
+	events []map[string]interface{}
+	mutex  sync.Mutex
+}
+
+func (s *fakeSocket) Write(data []byte) (int, error) {
+
+	var event map[string]interface{}
+	if err := json.Unmarshal(data, &event); err == nil {
+		s.mutex.Lock()
+		s.events = append(s.events, event)
+		s.mutex.Unlock()
+	}
+
+	return len(data), nil
+}
+
+// received returns the conversation ids of the events of the type sent to the agent
+func (s *fakeSocket) received(eventType string) []string {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var conversationIDs []string
+	for _, event := range s.events {
+		if event["event"] == eventType {
+			conversationID, _ := event["conversationID"].(string)
+			conversationIDs = append(conversationIDs, conversationID)
+		}
+	}
+
+	return conversationIDs
+}
+
+// eventsOf returns the events of the type sent to the agent
+func (s *fakeSocket) eventsOf(eventType string) []map[string]interface{} {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var events []map[string]interface{}
+	for _, event := range s.events {
+		if event["event"] == eventType {
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// fakeAuthenticator accepts every login and records the logouts
+type fakeAuthenticator struct {
+	logouts []string
+	mutex   sync.Mutex
+}
+
+func (a *fakeAuthenticator) Init() {}
+
+func (a *fakeAuthenticator) Login(username string, password string) (*types.Agent, []types.AgentRole, string) {
+	return &types.Agent{Id: username, Name: "Agent " + username}, []types.AgentRole{types.RoleAgent}, ""
+}
+
+func (a *fakeAuthenticator) Logout(id string) bool {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.logouts = append(a.logouts, id)
+	return true
+}
+
+func (a *fakeAuthenticator) Disconnect() {}
+
+func (a *fakeAuthenticator) loggedOut() []string {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return append([]string(nil), a.logouts...)
+}
+
+func (s *fakeSocket) Read(data []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (s *fakeSocket) Close() error {
+	return nil
+}
+
+func (s *fakeSocket) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8010}
+}
+
+func (s *fakeSocket) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+}
+
+func (s *fakeSocket) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (s *fakeSocket) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (s *fakeSocket) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// fakeChannel records the messages sent to customers
+type fakeChannel struct {
+	sent  []string
+	mutex sync.Mutex
+}
+
+func (c *fakeChannel) Init() {}
+
+func (c *fakeChannel) ParseReceivedData(body []byte) (string, map[string]interface{}) {
+	return "", nil
+}
+
+func (c *fakeChannel) GetSenderInfo(data map[string]interface{}) (string, string) {
+	return "", ""
+}
+
+func (c *fakeChannel) GetMessageInfo(data map[string]interface{}) (string, uint) {
+	return "", 0
+}
+
+func (c *fakeChannel) GetMessageStatus(data map[string]interface{}) types.MessageStatus {
+	return types.Sent
+}
+
+func (c *fakeChannel) SendMessage(senderUniqueID string, messageText string, autoreply bool) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.sent = append(c.sent, messageText)
+}
+
+func (c *fakeChannel) CheckConfiguration() string {
+	return ""
+}
+
+func (c *fakeChannel) sentMessages() []string {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([]string(nil), c.sent...)
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"server/auths"
+	"server/db"
+	"server/types"
+	"time"
+)
+
+const (
+	HEALTH_CHECK_TIMEOUT = 2 * time.Second
+
+	HEALTH_OK          = "ok"
+	HEALTH_DEGRADED    = "degraded"
+	HEALTH_UNAVAILABLE = "unavailable"
+)
+
+// HealthCheck is the result of checking one dependency of the server
+type HealthCheck struct {
+	Name     string `json:"name"`
+	Healthy  bool   `json:"healthy"`
+	Optional bool   `json:"optional,omitempty"` //the server keeps serving chats without it
+	Details  string `json:"details,omitempty"`
+	Duration int64  `json:"duration_ms"`
+}
+
+// runHealthCheck runs and times a single check
+func runHealthCheck(name string, check func() (healthy bool, details string)) HealthCheck {
+
+	start := time.Now()
+	healthy, details := check()
+
+	return HealthCheck{Name: name, Healthy: healthy, Details: details, Duration: time.Since(start).Milliseconds()}
+}
+
+func optionalHealthCheck(check HealthCheck) HealthCheck {
+	check.Optional = true
+	return check
+}
+
+func checkDB(dbCredentials string, dbName string) func() (bool, string) {
+	return func() (bool, string) {
+		if err := db.DBConnector.Ping(dbCredentials, dbName, HEALTH_CHECK_TIMEOUT); err != nil {
+			return false, err.Error()
+		}
+
+		return true, ""
+	}
+}
+
+func checkAMI() (bool, string) {
+
+	checker, ok := TcpServer.loginAuthenticator.(auths.HealthChecker)
+	if !ok {
+		return true, "Login authenticator does not use AMI"
+	}
+
+	return checker.CheckHealth()
+}
+
+func checkChannels() (bool, string) {
+
+	healthy := true
+	details := ""
+
+	for channelType, channel := range Omnichannel.Channels {
+		if failedMsg := channel.CheckConfiguration(); failedMsg != "" {
+			healthy = false
+			details += types.ChannelNames[channelType] + ": " + failedMsg + "; "
+		}
+	}
+
+	return healthy, details
+}
+
+func checkTCPServer() (bool, string) {
+	if TcpServer.Listener == nil {
+		return false, "TCP server is not listening"
+	}
+
+	return true, TcpServer.Listener.Addr().String()
+}
+
+// usesAuthenticator reports whether agents log in through the authenticator type
+func usesAuthenticator(authenticatorType string) bool {
+
+	chain, ok := TcpServer.loginAuthenticator.(*auths.ChainAuthenticator)
+
+	return ok && chain.HasBackend(authenticatorType)
+}
+
+// GetHealthChecks checks every dependency the server needs to serve agents and customers
+func GetHealthChecks() []HealthCheck {
+
+	checks := []HealthCheck{runHealthCheck("omni_db", checkDB(OMNI_DB_CREDENTIALS, OMNI_DB_NAME))}
+
+	// the Asterisk DB is only needed to log in agents with their extension
+	if usesAuthenticator(AUTHENTICATOR_ASTERISK) {
+		checks = append(checks, runHealthCheck("asterisk_db", checkDB(auths.AST_DB_CREDENTIALS, auths.AST_DB_NAME)))
+	}
+
+	return append(checks,
+		optionalHealthCheck(runHealthCheck("ami", checkAMI)),
+		runHealthCheck("channels", checkChannels),
+		runHealthCheck("tcp_server", checkTCPServer),
+	)
+}
+
+func writeHealthReport(w http.ResponseWriter, checks []HealthCheck, failedStatus string) {
+
+	status := HEALTH_OK
+	for _, check := range checks {
+		if !check.Healthy && !check.Optional {
+			status = failedStatus
+		} else if !check.Healthy && status == HEALTH_OK {
+			status = HEALTH_DEGRADED
+		}
+	}
+
+	report := make(map[string]interface{})
+	report["status"] = status
+	report["timestamp"] = time.Now()
+	report["checks"] = checks
+
+	w.Header().Set("Content-Type", "application/json")
+	if status == HEALTH_UNAVAILABLE {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Println("Failed to write health report: ", err)
+	}
+}
+
+// HandleHealthz reports the dependencies but keeps answering 200 while the process is up, so a failing dependency does not get the server restarted
+func HandleHealthz(w http.ResponseWriter, req *http.Request) {
+	writeHealthReport(w, GetHealthChecks(), HEALTH_DEGRADED)
+}
+
+// HandleReadyz answers 503 while a required dependency fails, so the load balancer stops sending traffic to this server
+func HandleReadyz(w http.ResponseWriter, req *http.Request) {
+	writeHealthReport(w, GetHealthChecks(), HEALTH_UNAVAILABLE)
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		dbFails bool
+		code    int
+		status  string
+	}{
+		{"database reachable", false, http.StatusOK, HEALTH_OK},
+		{"database ping fails", true, http.StatusServiceUnavailable, HEALTH_UNAVAILABLE},
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	previous := TcpServer.Listener
+	TcpServer.Listener = listener
+	t.Cleanup(func() { TcpServer.Listener = previous })
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			setupRouting(t, nil, nil)
+			useFakeAuthenticator(t)
+			useFakeChannel(t)
+			if test.dbFails {
+				testDB.fail("PING")
+			}
+
+			recorder := httptest.NewRecorder()
+			HandleReadyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			var report map[string]interface{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+				t.Fatalf("invalid health report %q: %v", recorder.Body.String(), err)
+			}
+
+			if recorder.Code != test.code || report["status"] != test.status {
+				t.Errorf("readyz answered %d with %v, want %d with %s", recorder.Code, report["status"], test.code, test.status)
+			}
+			if testDB.count("PING") == 0 {
+				t.Errorf("readyz did not ping the database")
+			}
+		})
+	}
+}
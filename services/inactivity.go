@@ -0,0 +1,155 @@
+package services
+
+import (
+	"log"
+	"server/db"
+	"server/types"
+	"strconv"
+	"time"
+)
+
+const (
+	INACTIVITY_CHECK_INTERVAL = 30 * time.Second
+
+	AUTOREPLY_INACTIVITY_WARNING = "inactivity-warning"
+	AUTOREPLY_INACTIVITY_CLOSED  = "inactivity-closed"
+)
+
+var Inactivity InactivityScheduler
+
+// InactivityScheduler warns customers who stopped answering an agent and finally closes their conversation
+type InactivityScheduler struct{}
+
+func (i *InactivityScheduler) Init() {
+
+	for _, conversation := range Omnichannel.ActiveConversations {
+		Omnichannel.loadLastActivity(conversation)
+	}
+
+	go i.run()
+}
+
+func (i *InactivityScheduler) run() {
+	for range time.Tick(INACTIVITY_CHECK_INTERVAL) {
+		i.checkConversations(time.Now())
+	}
+}
+
+func (i *InactivityScheduler) checkConversations(now time.Time) {
+
+	conversations := Omnichannel.snapshotActiveConversations()
+
+	for n := range conversations {
+		conversation := &conversations[n]
+
+		// only conversations waiting for the customer's answer can go idle
+		if conversation.State != types.Assigned || !conversation.LastMessageFromAgent {
+			continue
+		}
+
+		warningAfter, closeAfter := QueueManager.GetInactivityTimers(conversation.Queue)
+		idle := now.Sub(time.UnixMilli(int64(conversation.LastActivity)))
+
+		if closeAfter > 0 && idle >= closeAfter {
+			i.closeConversation(conversation)
+		} else if warningAfter > 0 && idle >= warningAfter && !conversation.InactivityWarned {
+			i.warnCustomer(conversation)
+		}
+	}
+}
+
+func (i *InactivityScheduler) warnCustomer(conversation *types.Conversation) {
+
+	if !Omnichannel.markInactivityWarned(conversation.Id, conversation.LastActivity) {
+		return
+	}
+
+	if text := Omnichannel.ExpandPlaceholders(AutoReplies.GetTemplate(AUTOREPLY_INACTIVITY_WARNING, conversation), conversation, nil); text != "" {
+		message := types.Message{Type: types.Text, Text: text, Timestamp: uint(time.Now().UnixMilli()), Status: types.Sent}
+		Omnichannel.sendMessage(conversation.Id, message, true)
+	}
+
+	jsonData := make(map[string]interface{})
+	jsonData["event"] = EVENT_CUSTOMER_INACTIVE
+	jsonData["conversationID"] = conversation.Id
+	jsonData["lastActivity"] = conversation.LastActivity
+	TcpServer.SendEventToAgents(jsonData, conversation.ConnectedAgent)
+}
+
+func (i *InactivityScheduler) closeConversation(conversation *types.Conversation) {
+
+	if !Omnichannel.isInactiveSince(conversation.Id, conversation.LastActivity) {
+		return
+	}
+
+	log.Println("Closing inactive conversation ", conversation.Id)
+
+	if text := Omnichannel.ExpandPlaceholders(AutoReplies.GetTemplate(AUTOREPLY_INACTIVITY_CLOSED, conversation), conversation, nil); text != "" {
+		message := types.Message{Type: types.Text, Text: text, Timestamp: uint(time.Now().UnixMilli()), Status: types.Sent}
+		Omnichannel.sendMessage(conversation.Id, message, true)
+	}
+
+	jsonData := make(map[string]interface{})
+	jsonData["event"] = EVENT_CONVERSATION_AUTO_CLOSED
+	jsonData["conversationID"] = conversation.Id
+	jsonData["lastActivity"] = conversation.LastActivity
+	TcpServer.SendEventToAgents(jsonData, conversation.ConnectedAgent)
+
+	TcpServer.CloseConversation(conversation.Id)
+}
+
+// updateActivity keeps track of who wrote last in an active conversation
+func (o *OmniChannel) updateActivity(conversationID string, message types.Message) {
+
+	if message.Type != types.Text {
+		return
+	}
+
+	o.conversationsMutex.Lock()
+	defer o.conversationsMutex.Unlock()
+
+	if conversation := o.activeConversation(conversationID); conversation != nil {
+		conversation.LastActivity = uint(time.Now().UnixMilli())
+		conversation.LastMessageFromAgent = message.SentFromAgent
+		conversation.InactivityWarned = false
+	}
+}
+
+// markInactivityWarned sets the warning once, unless the conversation changed since lastActivity
+func (o *OmniChannel) markInactivityWarned(conversationID string, lastActivity uint) bool {
+
+	o.conversationsMutex.Lock()
+	defer o.conversationsMutex.Unlock()
+
+	conversation := o.activeConversation(conversationID)
+	if conversation == nil || conversation.InactivityWarned || conversation.LastActivity != lastActivity {
+		return false
+	}
+
+	conversation.InactivityWarned = true
+
+	return true
+}
+
+// isInactiveSince reports whether nobody wrote in the conversation since lastActivity and the agent still waits for the customer
+func (o *OmniChannel) isInactiveSince(conversationID string, lastActivity uint) bool {
+
+	o.conversationsMutex.RLock()
+	defer o.conversationsMutex.RUnlock()
+
+	conversation := o.activeConversation(conversationID)
+
+	return conversation != nil && conversation.State == types.Assigned && conversation.LastMessageFromAgent && conversation.LastActivity == lastActivity
+}
+
+func (o *OmniChannel) loadLastActivity(conversation *types.Conversation) {
+
+	conversation.LastActivity = conversation.Created_Timestamp
+
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "SELECT timestamp, sent_from_agent FROM messages WHERE conversation_id='"+conversation.Id+"' AND type="+strconv.Itoa(int(types.Text))+" ORDER BY id DESC LIMIT 1")
+	defer results.Close()
+
+	if results.Next() {
+		results.Scan(&conversation.LastActivity, &conversation.LastMessageFromAgent)
+	}
+}
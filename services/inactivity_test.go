@@ -0,0 +1,126 @@
+package services
+
+import (
+	"reflect"
+	"server/types"
+	"sync"
+	"testing"
+	"time"
+)
+
+// setupInactivity gives agent 1001 an assigned conversation in which the agent wrote last, idle since 90 seconds before now
+func setupInactivity(t *testing.T, now time.Time) (*types.Agent, *types.Conversation) {
+
+	agent := newTestAgent("1001", "Sales")
+	agent.Conversations = 1
+
+	conversation := newTestConversation("c1", "Sales")
+	conversation.State = types.Assigned
+	conversation.ConnectedAgent = agent.Id
+	conversation.LastActivity = uint(now.Add(-90 * time.Second).UnixMilli())
+	conversation.LastMessageFromAgent = true
+
+	setupRouting(t, LeastBusyStrategy{}, []*types.Agent{agent}, conversation)
+	QueueManager.Queues["Sales"] = &ChatQueue{Name: "Sales", InactivityWarning: time.Minute, InactivityClose: 2 * time.Minute,
+		SLAFirstResponse: time.Minute, SLAResolution: time.Hour, SLAWarningPercentage: 80}
+
+	return agent, conversation
+}
+
+func TestInactivityWarnsThenCloses(t *testing.T) {
+
+	now := time.Now()
+	agent, conversation := setupInactivity(t, now)
+
+	Inactivity.checkConversations(now)
+	Inactivity.checkConversations(now)
+
+	if warned := socketOf(agent).received(EVENT_CUSTOMER_INACTIVE); !reflect.DeepEqual(warned, []string{"c1"}) {
+		t.Fatalf("agent was warned %v, want [c1] once", warned)
+	}
+	if !conversation.InactivityWarned {
+		t.Errorf("warned conversation is not marked as warned")
+	}
+
+	Inactivity.checkConversations(now.Add(time.Minute))
+
+	if closed := socketOf(agent).received(EVENT_CONVERSATION_AUTO_CLOSED); !reflect.DeepEqual(closed, []string{"c1"}) {
+		t.Fatalf("agent was told %v were closed, want [c1]", closed)
+	}
+	if Omnichannel.FindActiveConversationByID("c1") != nil || conversation.State != types.Finished {
+		t.Errorf("closed conversation is %v and still active: %v", conversation.State, Omnichannel.FindActiveConversationByID("c1") != nil)
+	}
+	if agent.Conversations != 0 {
+		t.Errorf("agent has %d conversations after the close, want 0", agent.Conversations)
+	}
+}
+
+func TestInactivitySkipsConversationAnsweredSinceTheCheck(t *testing.T) {
+
+	now := time.Now()
+	agent, _ := setupInactivity(t, now)
+
+	snapshot := Omnichannel.snapshotActiveConversations()[0]
+
+	// the customer answers between the snapshot and the warning
+	Omnichannel.updateActivity("c1", types.Message{Type: types.Text, Text: "still here"})
+
+	Inactivity.warnCustomer(&snapshot)
+	Inactivity.closeConversation(&snapshot)
+
+	if warned := socketOf(agent).received(EVENT_CUSTOMER_INACTIVE); len(warned) != 0 {
+		t.Errorf("agent was warned %v about a customer who answered", warned)
+	}
+	if Omnichannel.FindActiveConversationByID("c1") == nil {
+		t.Errorf("conversation the customer answered was closed")
+	}
+}
+
+// TestBackgroundChecksWithMessages runs the inactivity and SLA checks while messages arrive and the conversation is finished, for go test -race
+func TestBackgroundChecksWithMessages(t *testing.T) {
+
+	now := time.Now()
+	agent, _ := setupInactivity(t, now)
+	SLA.notified = make(map[string]map[string]int)
+
+	var wait, running sync.WaitGroup
+	stop := make(chan struct{})
+
+	for _, check := range []func(time.Time){Inactivity.checkConversations, SLA.checkConversations} {
+		wait.Add(1)
+		running.Add(1)
+		go func(check func(time.Time)) {
+			defer wait.Done()
+
+			check(time.Now())
+			running.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					check(time.Now())
+				}
+			}
+		}(check)
+	}
+
+	// both checks run while the messages arrive
+	running.Wait()
+
+	for i := 0; i < 50; i++ {
+		Omnichannel.AddNewMessage("c1", types.Message{Type: types.Text, Text: "message", Timestamp: uint(time.Now().UnixMilli()), SentFromAgent: i%2 == 0})
+	}
+
+	if failedMsg := TcpServer.FinishConversation("c1", agent); failedMsg != "" {
+		t.Errorf("FinishConversation failed with %q", failedMsg)
+	}
+
+	close(stop)
+	wait.Wait()
+
+	if finished := socketOf(agent).received(EVENT_CONVERSATION_FINISHED); !reflect.DeepEqual(finished, []string{"c1"}) {
+		t.Errorf("agent was told %v were finished, want [c1] once", finished)
+	}
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+var Throttle LoginThrottler
+
+// loginFailures counts the failed logins of one username or IP address in the current window
+type loginFailures struct {
+	count       int
+	windowStart time.Time
+	lockedUntil time.Time
+}
+
+// LoginThrottler locks a username or an IP address out for a while after too many failed logins.
+// Limits of 0 turn the throttling of that key off.
+type LoginThrottler struct {
+	maxUsernameFailures int
+	maxIPFailures       int
+	window              time.Duration
+	lockout             time.Duration
+
+	failures map[string]*loginFailures //map["user:"+username or "ip:"+address]failures
+	mutex    sync.Mutex
+}
+
+func (t *LoginThrottler) Init() {
+
+	t.failures = make(map[string]*loginFailures)
+	t.maxUsernameFailures = 5
+	t.maxIPFailures = 20
+	t.window = 15 * time.Minute
+	t.lockout = 15 * time.Minute
+
+	cfg, err := ini.Load("conf/login_auth_conf.ini")
+	if err != nil {
+		log.Println("Failed to read login_auth_conf file: ", err)
+		return
+	}
+
+	section := cfg.Section("login-throttling")
+
+	t.maxUsernameFailures = section.Key("MAX_USERNAME_FAILURES").MustInt(t.maxUsernameFailures)
+	t.maxIPFailures = section.Key("MAX_IP_FAILURES").MustInt(t.maxIPFailures)
+	t.window = time.Duration(section.Key("FAILURE_WINDOW").MustInt(15)) * time.Minute
+	t.lockout = time.Duration(section.Key("LOCKOUT_DURATION").MustInt(15)) * time.Minute
+}
+
+// Check rejects the login while the username or the address is locked out
+func (t *LoginThrottler) Check(username string, ip string, now time.Time) (failedMsg string) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, key := range []string{"user:" + username, "ip:" + ip} {
+		if failures := t.failures[key]; failures != nil && now.Before(failures.lockedUntil) {
+			return "Too many failed login attempts, try again in " + failures.lockedUntil.Sub(now).Round(time.Second).String()
+		}
+	}
+
+	return ""
+}
+
+// Failed counts a failed login and reports whether it locked the username or the address out
+func (t *LoginThrottler) Failed(username string, ip string, now time.Time) (locked bool) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.prune(now)
+
+	if t.count("user:"+username, t.maxUsernameFailures, now) {
+		log.Println("Login of ", username, " locked out for ", t.lockout)
+		locked = true
+	}
+	if t.count("ip:"+ip, t.maxIPFailures, now) {
+		log.Println("Logins from ", ip, " locked out for ", t.lockout)
+		locked = true
+	}
+
+	return locked
+}
+
+// Succeeded clears the failures of the username, the address keeps its count so it cannot reset it with a known account
+func (t *LoginThrottler) Succeeded(username string) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.failures, "user:"+username)
+}
+
+func (t *LoginThrottler) count(key string, limit int, now time.Time) bool {
+
+	if limit <= 0 {
+		return false
+	}
+
+	failures := t.failures[key]
+	if failures == nil || now.Sub(failures.windowStart) > t.window {
+		failures = &loginFailures{windowStart: now}
+		t.failures[key] = failures
+	}
+
+	failures.count++
+	if failures.count < limit {
+		return false
+	}
+
+	failures.count = 0
+	failures.windowStart = now
+	failures.lockedUntil = now.Add(t.lockout)
+
+	return true
+}
+
+// prune forgets the keys whose window and lockout are over
+func (t *LoginThrottler) prune(now time.Time) {
+	for key, failures := range t.failures {
+		if now.Sub(failures.windowStart) > t.window && !now.Before(failures.lockedUntil) {
+			delete(t.failures, key)
+		}
+	}
+}
+
+func remoteIP(con net.Conn) string {
+
+	host, _, err := net.SplitHostPort(con.RemoteAddr().String())
+	if err != nil {
+		return con.RemoteAddr().String()
+	}
+
+	return host
+}
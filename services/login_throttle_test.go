@@ -0,0 +1,115 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestThrottler() *LoginThrottler {
+	return &LoginThrottler{maxUsernameFailures: 3, maxIPFailures: 5, window: time.Minute, lockout: 10 * time.Minute, failures: make(map[string]*loginFailures)}
+}
+
+// fail counts failed logins and reports whether the last one locked out
+func fail(throttler *LoginThrottler, username string, ip string, times int, now time.Time) (locked bool) {
+	for i := 0; i < times; i++ {
+		locked = throttler.Failed(username, ip, now)
+	}
+	return locked
+}
+
+func TestThrottleUsernameLimit(t *testing.T) {
+
+	throttler := newTestThrottler()
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+	if fail(throttler, "jdoe", "10.0.0.1", 2, now) {
+		t.Fatalf("locked out below the username limit")
+	}
+	if failedMsg := throttler.Check("jdoe", "10.0.0.1", now); failedMsg != "" {
+		t.Fatalf("Check below the username limit failed with %q", failedMsg)
+	}
+
+	// the third failure from another address still counts for the username
+	if !throttler.Failed("jdoe", "10.0.0.2", now) {
+		t.Fatalf("third failure did not lock the username out")
+	}
+
+	if failedMsg := throttler.Check("jdoe", "10.0.0.3", now.Add(time.Minute)); failedMsg != "Too many failed login attempts, try again in 9m0s" {
+		t.Errorf("Check of a locked username from a new address failed with %q", failedMsg)
+	}
+	if failedMsg := throttler.Check("asmith", "10.0.0.1", now); failedMsg != "" {
+		t.Errorf("Check of another username failed with %q, want only jdoe locked", failedMsg)
+	}
+	if failedMsg := throttler.Check("jdoe", "10.0.0.1", now.Add(10*time.Minute)); failedMsg != "" {
+		t.Errorf("Check after the lockout failed with %q", failedMsg)
+	}
+}
+
+func TestThrottleIPLimit(t *testing.T) {
+
+	throttler := newTestThrottler()
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+	// one failure per username stays below the username limit, the address reaches its own
+	var locked bool
+	for i := 0; i < 5; i++ {
+		locked = throttler.Failed(fmt.Sprintf("agent%d", i), "10.0.0.1", now)
+	}
+	if !locked {
+		t.Fatalf("fifth failure from the address did not lock it out")
+	}
+
+	if failedMsg := throttler.Check("jdoe", "10.0.0.1", now); failedMsg == "" {
+		t.Errorf("Check from a locked address succeeded")
+	}
+	if failedMsg := throttler.Check("jdoe", "10.0.0.2", now); failedMsg != "" {
+		t.Errorf("Check from another address failed with %q", failedMsg)
+	}
+}
+
+func TestThrottleWindowExpires(t *testing.T) {
+
+	throttler := newTestThrottler()
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+	fail(throttler, "jdoe", "10.0.0.1", 2, now)
+
+	// the next failure starts a new window, so two more are needed to lock out
+	later := now.Add(2 * time.Minute)
+	if fail(throttler, "jdoe", "10.0.0.1", 2, later) {
+		t.Fatalf("failures of an expired window counted towards the lockout")
+	}
+	if !throttler.Failed("jdoe", "10.0.0.1", later) {
+		t.Errorf("three failures in the new window did not lock out")
+	}
+}
+
+func TestThrottleResetOnSuccess(t *testing.T) {
+
+	throttler := newTestThrottler()
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+	fail(throttler, "jdoe", "10.0.0.1", 2, now)
+	throttler.Succeeded("jdoe")
+
+	if fail(throttler, "jdoe", "10.0.0.1", 2, now) {
+		t.Errorf("failures before a successful login counted towards the username lockout")
+	}
+
+	// the address keeps its count, 2 + 2 failures before and one more reach its limit
+	if !throttler.Failed("asmith", "10.0.0.1", now) {
+		t.Errorf("a successful login reset the failures of the address")
+	}
+}
+
+func TestThrottleDisabledLimits(t *testing.T) {
+
+	throttler := newTestThrottler()
+	throttler.maxUsernameFailures, throttler.maxIPFailures = 0, 0
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+	if fail(throttler, "jdoe", "10.0.0.1", 50, now) {
+		t.Errorf("locked out with the throttling turned off")
+	}
+}
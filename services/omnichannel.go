@@ -1,15 +1,17 @@
 package services
 
 import (
-	"fmt"
+	"crypto/subtle"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"server/channels"
 	"server/db"
+	"server/metrics"
 	"server/types"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-ini/ini"
@@ -17,7 +19,8 @@ import (
 )
 
 const (
-	HTTP_PORT = "8181"
+	HTTP_PORT          = "8181"
+	ADMIN_HTTP_ADDRESS = "127.0.0.1:8182"
 )
 
 var (
@@ -25,16 +28,13 @@ var (
 	OMNI_DB_NAME        string
 )
 
-var executionTime float64
-var numOfReq int
-var startTime time.Time
-
 var Omnichannel OmniChannel
 
 type OmniChannel struct {
 	Channels            map[types.ChannelType]channels.Channel //map[channelType]channelHandler
 	Customers           []*types.Customer
 	ActiveConversations []*types.Conversation
+	conversationsMutex  sync.RWMutex
 }
 
 func (o *OmniChannel) Start() {
@@ -65,16 +65,46 @@ func (o *OmniChannel) Init() {
 	queryCustomerContactsTable := `CREATE TABLE IF NOT EXISTS customer_contacts(customer_id VARCHAR(256), channel_type VARCHAR(256), channel_id VARCHAR(256), PRIMARY KEY(channel_type, channel_id), INDEX index_cc1 (customer_id(255), channel_type))`
 	queryConversationsTable := `CREATE TABLE IF NOT EXISTS conversations(id VARCHAR(256) primary key, type INT, customer_id TEXT, connected_agent TEXT, created_timestamp BIGINT, state INT, INDEX index_c1 (customer_id(255), state))`
 	queryMessagesTable := `CREATE TABLE IF NOT EXISTS messages(id INT primary key auto_increment, conversation_id VARCHAR(256), body TEXT, timestamp BIGINT, status INT, sent_from_agent BOOLEAN, type INT, event TEXT, INDEX index_m1 (conversation_id, status, sent_from_agent))`
+	queryAgentSkillsTable := `CREATE TABLE IF NOT EXISTS agent_skills(agent_id VARCHAR(256), skill VARCHAR(64), value VARCHAR(256), PRIMARY KEY(agent_id, skill, value))`
+	queryCustomerAttributesTable := `CREATE TABLE IF NOT EXISTS customer_attributes(customer_id VARCHAR(256), name VARCHAR(64), value TEXT, PRIMARY KEY(customer_id, name))`
+	queryAgentQueuesTable := `CREATE TABLE IF NOT EXISTS agent_queues(agent_id VARCHAR(256), queue VARCHAR(256), PRIMARY KEY(agent_id, queue))`
+	queryAgentSettingsTable := `CREATE TABLE IF NOT EXISTS agent_settings(agent_id VARCHAR(256) primary key, max_conversations INT)`
+	queryCannedResponsesTable := `CREATE TABLE IF NOT EXISTS canned_responses(id INT primary key auto_increment, scope INT, owner VARCHAR(256), shortcut VARCHAR(64), title TEXT, body TEXT, INDEX index_cr1 (scope, owner))`
+	queryAuditLogTable := `CREATE TABLE IF NOT EXISTS audit_log(id INT primary key auto_increment, timestamp BIGINT, event VARCHAR(64), agent_id VARCHAR(256), username VARCHAR(256), remote_ip VARCHAR(64), details TEXT, INDEX index_al1 (timestamp), INDEX index_al2 (agent_id, timestamp))`
+	querySLAOutcomesTable := `CREATE TABLE IF NOT EXISTS sla_outcomes(conversation_id VARCHAR(256) primary key, type INT, queue VARCHAR(256), agent_id VARCHAR(256), created_timestamp BIGINT, assigned_timestamp BIGINT, first_response_timestamp BIGINT, finished_timestamp BIGINT, first_response_time BIGINT, resolution_time BIGINT, first_response_target BIGINT, resolution_target BIGINT, first_response_met BOOLEAN, resolution_met BOOLEAN, INDEX index_so1 (finished_timestamp, queue))`
 
 	db.DBConnector.CreateTable(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, queryCustomersTable)
 	db.DBConnector.CreateTable(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, queryCustomerContactsTable)
 	db.DBConnector.CreateTable(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, queryConversationsTable)
 	db.DBConnector.CreateTable(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, queryMessagesTable)
+	db.DBConnector.CreateTable(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, queryAgentSkillsTable)
+	db.DBConnector.CreateTable(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, queryCustomerAttributesTable)
+	db.DBConnector.CreateTable(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, queryAgentQueuesTable)
+	db.DBConnector.CreateTable(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, queryAgentSettingsTable)
+	db.DBConnector.CreateTable(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, queryCannedResponsesTable)
+	db.DBConnector.CreateTable(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, querySLAOutcomesTable)
+	db.DBConnector.CreateTable(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, queryAuditLogTable)
+
+	db.DBConnector.AddColumn(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "conversations", "queue", "VARCHAR(256) DEFAULT ''")
+	db.DBConnector.AddColumn(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "messages", "author", "VARCHAR(256) DEFAULT ''")
 
 	o.InitializeChannels()
+	QueueManager.Init()
+	Calendars.Init()
 
 	o.Customers = o.GetCustomers()
 	o.ActiveConversations = o.GetAllActiveConversations()
+
+	Router.Init()
+	AutoReplies.Init()
+
+	for _, conversation := range o.ActiveConversations {
+		conversation.Queued_Timestamp = conversation.Created_Timestamp
+		o.DeriveRequirements(conversation, o.GetFirstCustomerMessage(conversation.Id), Router.KeywordRules, Router.ChannelSkill)
+	}
+
+	Inactivity.Init()
+	SLA.Init()
 }
 
 func (o *OmniChannel) InitializeChannels() {
@@ -90,8 +120,13 @@ func (o *OmniChannel) InitializeChannels() {
 }
 
 func startHttpServer() {
-	numOfReq = 0
+	registerMetrics()
+
 	http.HandleFunc("/", HandleRequests)
+	http.HandleFunc("/healthz", HandleHealthz)
+	http.HandleFunc("/readyz", HandleReadyz)
+
+	go startAdminHttpServer()
 
 	log.Println("Starting HTTP Server at PORT " + HTTP_PORT)
 
@@ -100,6 +135,42 @@ func startHttpServer() {
 	}
 }
 
+// startAdminHttpServer serves the metrics and reports apart from the public webhook port, by default only to the host itself.
+// With a TOKEN configured every request has to send it as its bearer token.
+func startAdminHttpServer() {
+
+	address := ADMIN_HTTP_ADDRESS
+	token := ""
+
+	if cfg, err := ini.Load("conf/http_conf.ini"); err == nil {
+		address = cfg.Section("admin").Key("ADDRESS").MustString(ADMIN_HTTP_ADDRESS)
+		token = cfg.Section("admin").Key("TOKEN").String()
+	} else {
+		log.Println("Failed to read http_conf file: ", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", requireToken(token, metrics.Handler))
+	mux.HandleFunc("/reports", requireToken(token, HandleReports))
+
+	log.Println("Starting admin HTTP Server at " + address)
+
+	if err := http.ListenAndServe(address, mux); err != nil {
+		log.Println("Admin HTTP Server error:", err)
+	}
+}
+
+func requireToken(token string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, req)
+	}
+}
+
 func HandleRequests(w http.ResponseWriter, req *http.Request) {
 
 	body, err := ioutil.ReadAll(req.Body)
@@ -107,18 +178,18 @@ func HandleRequests(w http.ResponseWriter, req *http.Request) {
 		panic(err)
 	}
 
-	if numOfReq == 0 {
-		startTime = time.Now()
-	}
-	numOfReq++
-
-	//defer MeasureExecutionTime()()
-
 	channelType := Omnichannel.getChannelType(req.URL.String())
 	if channelType == types.Unknown {
 		return
 	}
 
+	start := time.Now()
+	channelName := types.ChannelNames[channelType]
+	metrics.WebhookRequests.Inc(channelName)
+	defer func() {
+		metrics.WebhookDuration.Observe(time.Since(start).Seconds(), channelName)
+	}()
+
 	channel := Omnichannel.Channels[channelType]
 	event, data := channel.ParseReceivedData(body)
 	senderUniqueID, senderName := channel.GetSenderInfo(data)
@@ -132,6 +203,7 @@ func HandleRequests(w http.ResponseWriter, req *http.Request) {
 		} else {
 			Omnichannel.AddNewCustomerContact(customer, channelType, senderUniqueID)
 		}
+		customerID = customer.Id
 	}
 
 	if event == types.EVENT_NEW_MESSAGE {
@@ -143,11 +215,21 @@ func HandleRequests(w http.ResponseWriter, req *http.Request) {
 		if conversation = Omnichannel.FindActiveConversationFromCustomer(customerID); conversation == nil {
 
 			conversation = &types.Conversation{Id: uuid.New().String(), Type: channelType, State: types.Unassigned, CustomerID: customerID, Created_Timestamp: timestamp}
+			conversation.Queue = QueueManager.SelectQueue(channelType)
+			conversation.Queued_Timestamp = uint(time.Now().UnixMilli())
+			Omnichannel.DeriveRequirements(conversation, messageText, Router.KeywordRules, Router.ChannelSkill)
+			if Calendars.ShouldDefer(conversation) {
+				conversation.State = types.Deferred
+			}
 			Omnichannel.AddNewConversation(conversation)
 			Omnichannel.AddNewMessage(conversation.Id, Omnichannel.createEventMessage(EVENT_CONVERSATION_STARTED, timestamp))
 			Omnichannel.AddNewMessage(conversation.Id, message)
-			channel.SendMessage(senderUniqueID, "Thank you for contacting us. One of agents will answer as soon as possible.", true)
-			Omnichannel.SendNewConversationToAgents(*conversation)
+			if greeting := AutoReplies.GetGreeting(conversation); greeting != "" {
+				channel.SendMessage(senderUniqueID, greeting, true)
+			}
+			if conversation.State == types.Unassigned {
+				Router.RouteConversation(conversation)
+			}
 		} else {
 			Omnichannel.AddNewMessage(conversation.Id, message)
 
@@ -174,19 +256,25 @@ func HandleRequests(w http.ResponseWriter, req *http.Request) {
 			TcpServer.SendEventToAgents(jsonData, "")
 		}
 	}
-
-	if numOfReq == 100 {
-		fmt.Println("All Messages executed for ", time.Since(startTime).Seconds())
-		numOfReq = 0
-	}
 }
 
-func MeasureExecutionTime() func() {
-	startTime := time.Now()
-	return func() {
-		executionTime += time.Since(startTime).Seconds()
-		fmt.Printf("Total Elapsed time is %f \n", executionTime)
-	}
+// registerMetrics adds the gauges describing the current state of the server to /metrics
+func registerMetrics() {
+
+	metrics.NewGaugeFunc("omnichannel_logged_agents", "Agents logged in to the TCP server.", func() float64 {
+		return float64(len(TcpServer.GetLoggedAgents()))
+	})
+
+	metrics.NewGaugeFunc("omnichannel_active_conversations", "Conversations that are not finished.", func() float64 {
+		Omnichannel.conversationsMutex.RLock()
+		defer Omnichannel.conversationsMutex.RUnlock()
+
+		return float64(len(Omnichannel.ActiveConversations))
+	})
+
+	metrics.NewGaugeFunc("omnichannel_unassigned_conversations", "Conversations waiting for an agent.", func() float64 {
+		return float64(len(Omnichannel.GetUnassignedConversations()))
+	})
 }
 
 func (o *OmniChannel) getChannelType(urlString string) types.ChannelType {
@@ -204,39 +292,104 @@ func (o *OmniChannel) getChannelType(urlString string) types.ChannelType {
 	return channelType
 }
 
-func (o *OmniChannel) sendMessage(conversationID string, messageTxt string, autoreply bool) {
+// sendMessage dispatches a text message to the customer's channel, notes and events always stay internal
+func (o *OmniChannel) sendMessage(conversationID string, message types.Message, autoreply bool) {
+
+	if message.Type != types.Text {
+		log.Println("Refusing to send a non text message to a channel: ", conversationID)
+		return
+	}
 
 	if conversation := o.FindConversationByID(conversationID); conversation != nil {
 
 		customerUniqueID := o.FindCustomerUniqueIdByChannel(conversation.CustomerID, conversation.Type)
 		channel := o.Channels[conversation.Type]
-		channel.SendMessage(customerUniqueID, messageTxt, autoreply)
+		channel.SendMessage(customerUniqueID, message.Text, autoreply)
 
 		if !autoreply {
-			message := types.Message{Type: types.Text, Text: messageTxt, Timestamp: uint(time.Now().UnixMilli()), Status: types.Sent, SentFromAgent: true}
 			o.AddNewMessage(conversationID, message)
 		}
 	}
 }
 
-func (o *OmniChannel) SendNewConversationToAgents(conversation types.Conversation) {
+// AddNote stores an internal note of the agent, notes are never sent to the customer
+func (o *OmniChannel) AddNote(conversationID string, agentID string, text string) (note types.Message, failedMsg string) {
+
+	note = types.Message{Type: types.Note, Text: text, Timestamp: uint(time.Now().UnixMilli()), Status: types.Sent, SentFromAgent: true, Author: agentID}
+	if err := o.AddNewMessage(conversationID, note); err != nil {
+		return note, "Failed to save the note"
+	}
+
+	return note, ""
+}
+
+func (o *OmniChannel) SendNewConversationToAgents(conversation types.Conversation, agents []*types.Agent) {
 
 	jsonData := make(map[string]interface{})
 	jsonData["event"] = types.EVENT_NEW_CONVERSATION
 	jsonData["conversationID"] = conversation.Id
 	jsonData["type"] = conversation.Type
+	jsonData["queue"] = conversation.Queue
 	jsonData["customer"] = o.FindCustomerByID(conversation.CustomerID)
 
-	TcpServer.SendEventToAgents(jsonData, "")
+	for _, agent := range agents {
+		TcpServer.SendEventToAgents(jsonData, agent.Id)
+	}
 }
 
-func (o *OmniChannel) FinishConversation(conversationID string) {
-	o.AddNewMessage(conversationID, Omnichannel.createEventMessage(EVENT_CONVERSATION_FINISHED, uint(time.Now().UnixMilli())))
+// FinishConversation ends the active conversation and returns it, or nil if it is not active anymore,
+// so a conversation closed from several places at once is finished only once
+func (o *OmniChannel) FinishConversation(conversationID string) *types.Conversation {
+
+	var conversation *types.Conversation
+
+	o.conversationsMutex.Lock()
+	for i := range o.ActiveConversations {
+		if o.ActiveConversations[i].Id == conversationID {
+			conversation = o.ActiveConversations[i]
+			o.ActiveConversations = append(o.ActiveConversations[:i], o.ActiveConversations[i+1:]...)
+			break
+		}
+	}
+	if conversation == nil || conversation.State == types.Finished {
+		o.conversationsMutex.Unlock()
+		return nil
+	}
+	conversation.State = types.Finished
+	o.conversationsMutex.Unlock()
+
+	finished := time.Now()
+
+	o.AddNewMessage(conversationID, Omnichannel.createEventMessage(EVENT_CONVERSATION_FINISHED, uint(finished.UnixMilli())))
+	SLA.RecordOutcome(conversation, finished)
 	o.UpdateConversationState(conversationID, types.Finished, "")
+
+	return conversation
 }
 
-func (o *OmniChannel) AcceptConversation(conversationID string, agentExt string) {
+// AcceptConversation connects the agent to the conversation if it is still waiting, it returns false if the conversation
+// was finished or taken in the meantime
+func (o *OmniChannel) AcceptConversation(conversationID string, agentExt string) bool {
+
+	now := uint(time.Now().UnixMilli())
+
+	o.conversationsMutex.Lock()
+	conversation := o.activeConversation(conversationID)
+	if conversation == nil || conversation.State != types.Unassigned {
+		o.conversationsMutex.Unlock()
+		return false
+	}
+	conversation.State = types.Assigned
+	conversation.ConnectedAgent = agentExt
+	if conversation.Assigned_Timestamp == 0 {
+		conversation.Assigned_Timestamp = now
+	}
+	o.conversationsMutex.Unlock()
+
+	o.AddNewMessage(conversationID, o.createEventMessage(EVENT_CONVERSATION_ACCEPTED, now))
 	o.UpdateConversationState(conversationID, types.Assigned, agentExt)
+
+	return true
 }
 
 func (o *OmniChannel) createEventMessage(event string, timestamp uint) types.Message {
@@ -320,6 +473,9 @@ func (o *OmniChannel) AddNewCustomerContact(customer *types.Customer, channelTyp
 
 func (o *OmniChannel) FindActiveConversationFromCustomer(customerID string) *types.Conversation {
 
+	o.conversationsMutex.RLock()
+	defer o.conversationsMutex.RUnlock()
+
 	for i := range o.ActiveConversations {
 		if o.ActiveConversations[i].CustomerID == customerID {
 			return o.ActiveConversations[i]
@@ -330,15 +486,78 @@ func (o *OmniChannel) FindActiveConversationFromCustomer(customerID string) *typ
 
 }
 
+func (o *OmniChannel) FindActiveConversationByID(conversationID string) *types.Conversation {
+
+	o.conversationsMutex.RLock()
+	defer o.conversationsMutex.RUnlock()
+
+	return o.activeConversation(conversationID)
+}
+
+// activeConversation looks the conversation up, the caller holds conversationsMutex
+func (o *OmniChannel) activeConversation(conversationID string) *types.Conversation {
+
+	for i := range o.ActiveConversations {
+		if o.ActiveConversations[i].Id == conversationID {
+			return o.ActiveConversations[i]
+		}
+	}
+
+	return nil
+}
+
+// snapshotActiveConversations copies the active conversations, so the background checks can read them while messages change them
+func (o *OmniChannel) snapshotActiveConversations() []types.Conversation {
+
+	o.conversationsMutex.RLock()
+	defer o.conversationsMutex.RUnlock()
+
+	conversations := make([]types.Conversation, len(o.ActiveConversations))
+	for i := range o.ActiveConversations {
+		conversations[i] = *o.ActiveConversations[i]
+	}
+
+	return conversations
+}
+
+func (o *OmniChannel) GetUnassignedConversations() []*types.Conversation {
+
+	o.conversationsMutex.RLock()
+	defer o.conversationsMutex.RUnlock()
+
+	var conversations []*types.Conversation
+
+	for i := range o.ActiveConversations {
+		if o.ActiveConversations[i].State == types.Unassigned {
+			conversations = append(conversations, o.ActiveConversations[i])
+		}
+	}
+
+	return conversations
+}
+
+func (o *OmniChannel) RemoveActiveConversation(conversationID string) {
+
+	o.conversationsMutex.Lock()
+	defer o.conversationsMutex.Unlock()
+
+	for i := range o.ActiveConversations {
+		if o.ActiveConversations[i].Id == conversationID {
+			o.ActiveConversations = append(o.ActiveConversations[:i], o.ActiveConversations[i+1:]...)
+			return
+		}
+	}
+}
+
 func (o *OmniChannel) FindConversationByID(conversationID string) *types.Conversation {
 
-	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "SELECT customer_id, type, connected_agent, created_timestamp, state FROM conversations WHERE id='"+conversationID+"'")
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "SELECT customer_id, type, connected_agent, created_timestamp, state, queue FROM conversations WHERE id=?", conversationID)
 	defer results.Close()
 
 	if results.Next() {
 		conversation := types.Conversation{}
 		conversation.Id = conversationID
-		if err := results.Scan(&conversation.CustomerID, &conversation.Type, &conversation.ConnectedAgent, &conversation.Created_Timestamp, &conversation.State); err == nil {
+		if err := results.Scan(&conversation.CustomerID, &conversation.Type, &conversation.ConnectedAgent, &conversation.Created_Timestamp, &conversation.State, &conversation.Queue); err == nil {
 			return &conversation
 		}
 	}
@@ -348,15 +567,18 @@ func (o *OmniChannel) FindConversationByID(conversationID string) *types.Convers
 
 func (o *OmniChannel) AddNewConversation(conversation *types.Conversation) {
 
+	o.conversationsMutex.Lock()
 	o.ActiveConversations = append(o.ActiveConversations, conversation)
+	o.conversationsMutex.Unlock()
 
-	query := "INSERT INTO conversations(id, type, customer_id, connected_agent, created_timestamp, state) VALUES('" +
+	query := "INSERT INTO conversations(id, type, customer_id, connected_agent, created_timestamp, state, queue) VALUES('" +
 		conversation.Id + "'," +
 		"'" + strconv.Itoa(int(conversation.Type)) + "'," +
 		"'" + conversation.CustomerID + "'," +
 		"'" + conversation.ConnectedAgent + "'," +
 		strconv.FormatUint(uint64(conversation.Created_Timestamp), 10) + "," +
-		strconv.Itoa(int(conversation.State)) + ")"
+		strconv.Itoa(int(conversation.State)) + "," +
+		"'" + conversation.Queue + "')"
 
 	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, query)
 	defer results.Close()
@@ -369,30 +591,32 @@ func (o *OmniChannel) UpdateConversationState(conversationID string, state types
 	if connectedAgent == "" {
 		query = "UPDATE conversations SET state=" + strconv.Itoa(int(state)) + " WHERE id='" + conversationID + "'"
 	} else {
-		query = "UPDATE conversations SET state=" + strconv.Itoa(int(state)) + ", connectedAgent='" + connectedAgent + "' WHERE id='" + conversationID + "'"
+		query = "UPDATE conversations SET state=" + strconv.Itoa(int(state)) + ", connected_agent='" + connectedAgent + "' WHERE id='" + conversationID + "'"
 	}
 
 	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, query)
 	defer results.Close()
 }
 
-func (o *OmniChannel) AddNewMessage(conversationID string, message types.Message) {
+// AddNewMessage stores the message of the conversation, it returns the database error if it could not be stored
+func (o *OmniChannel) AddNewMessage(conversationID string, message types.Message) error {
 
-	query := "INSERT INTO messages(conversation_id, body, timestamp, status, sent_from_agent, type, event) VALUES('" +
-		conversationID + "'," +
-		"'" + message.Text + "'," +
-		strconv.FormatUint(uint64(message.Timestamp), 10) + "," +
-		strconv.Itoa(int(message.Status)) + "," +
-		strconv.FormatBool(message.SentFromAgent) + "," +
-		strconv.Itoa(int(message.Type)) + "," +
-		"'" + message.Event + "')"
+	query := "INSERT INTO messages(conversation_id, body, timestamp, status, sent_from_agent, type, event, author) VALUES(?, ?, ?, ?, ?, ?, ?, ?)"
 
-	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, query)
-	defer results.Close()
+	if err := db.DBConnector.Execute(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, query, conversationID, message.Text, uint64(message.Timestamp), int(message.Status),
+		message.SentFromAgent, int(message.Type), message.Event, message.Author); err != nil {
+		return err
+	}
+
+	o.updateActivity(conversationID, message)
+	o.recordFirstResponse(conversationID, message)
+	Monitor.PublishMessage(conversationID, message)
+
+	return nil
 }
 
 func (o *OmniChannel) UpdateMessageStatus(conversationID string, status types.MessageStatus) {
-	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "UPDATE messages SET status="+strconv.Itoa(int(status))+" WHERE conversation_id='"+conversationID+"' AND status<"+strconv.Itoa(int(status))+" AND sent_from_agent=true")
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "UPDATE messages SET status=? WHERE conversation_id=? AND status<? AND sent_from_agent=true AND type=?", int(status), conversationID, int(status), int(types.Text))
 	defer results.Close()
 }
 
@@ -400,12 +624,12 @@ func (o *OmniChannel) GetMessages(conversationID string) []*types.Message {
 
 	var messages []*types.Message
 
-	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "SELECT body, timestamp, status, sent_from_agent, type, event FROM messages WHERE conversation_id='"+conversationID+"'")
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "SELECT body, timestamp, status, sent_from_agent, type, event, author FROM messages WHERE conversation_id=?", conversationID)
 	defer results.Close()
 
 	for results.Next() {
 		var message types.Message
-		if err := results.Scan(&message.Text, &message.Timestamp, &message.Status, &message.SentFromAgent, &message.Type, &message.Event); err == nil {
+		if err := results.Scan(&message.Text, &message.Timestamp, &message.Status, &message.SentFromAgent, &message.Type, &message.Event, &message.Author); err == nil {
 			messages = append(messages, &message)
 		}
 	}
@@ -413,6 +637,19 @@ func (o *OmniChannel) GetMessages(conversationID string) []*types.Message {
 	return messages
 }
 
+func (o *OmniChannel) GetFirstCustomerMessage(conversationID string) string {
+
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "SELECT body FROM messages WHERE conversation_id='"+conversationID+"' AND type="+strconv.Itoa(int(types.Text))+" AND sent_from_agent=false ORDER BY id LIMIT 1")
+	defer results.Close()
+
+	var body string
+	if results.Next() {
+		results.Scan(&body)
+	}
+
+	return body
+}
+
 func (o *OmniChannel) GetCustomers() []*types.Customer {
 
 	var customers []*types.Customer
@@ -423,6 +660,7 @@ func (o *OmniChannel) GetCustomers() []*types.Customer {
 	for results.Next() {
 		var customer types.Customer
 		if err := results.Scan(&customer.Id, &customer.Name); err == nil {
+			customer.Attributes = o.GetCustomerAttributes(customer.Id)
 			customers = append(customers, &customer)
 
 			results2 := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "SELECT channel_type, channel_id FROM customer_contacts WHERE customer_id='"+customer.Id+"'")
@@ -461,12 +699,12 @@ func (o *OmniChannel) GetAgentActiveConversations(agent string) []*types.Convers
 
 	var conversations []*types.Conversation
 
-	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "SELECT id, type, customer_id, connected_agent, created_timestamp, state FROM conversations WHERE connected_agent='"+agent+"' AND state<"+strconv.Itoa(int(types.Finished)))
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "SELECT id, type, customer_id, connected_agent, created_timestamp, state, queue FROM conversations WHERE connected_agent='"+agent+"' AND state="+strconv.Itoa(int(types.Assigned)))
 	defer results.Close()
 
 	for results.Next() {
 		var conversation types.Conversation
-		if err := results.Scan(&conversation.Id, &conversation.Type, &conversation.CustomerID, &conversation.ConnectedAgent, &conversation.Created_Timestamp, &conversation.State); err == nil {
+		if err := results.Scan(&conversation.Id, &conversation.Type, &conversation.CustomerID, &conversation.ConnectedAgent, &conversation.Created_Timestamp, &conversation.State, &conversation.Queue); err == nil {
 			conversations = append(conversations, &conversation)
 		}
 	}
@@ -478,12 +716,12 @@ func (o *OmniChannel) GetAllActiveConversations() []*types.Conversation {
 
 	var conversations []*types.Conversation
 
-	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "SELECT id, type, customer_id, connected_agent, created_timestamp, state FROM conversations WHERE state<"+strconv.Itoa(int(types.Finished)))
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "SELECT id, type, customer_id, connected_agent, created_timestamp, state, queue FROM conversations WHERE state<>"+strconv.Itoa(int(types.Finished)))
 	defer results.Close()
 
 	for results.Next() {
 		var conversation types.Conversation
-		if err := results.Scan(&conversation.Id, &conversation.Type, &conversation.CustomerID, &conversation.ConnectedAgent, &conversation.Created_Timestamp, &conversation.State); err == nil {
+		if err := results.Scan(&conversation.Id, &conversation.Type, &conversation.CustomerID, &conversation.ConnectedAgent, &conversation.Created_Timestamp, &conversation.State, &conversation.Queue); err == nil {
 			conversations = append(conversations, &conversation)
 		}
 	}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"server/types"
+	"testing"
+)
+
+func TestAddNote(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		fail      bool
+		failedMsg string
+	}{
+		{"stored", false, ""},
+		{"database error", true, "Failed to save the note"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			agent := newTestAgent("1001")
+			conversation := newTestConversation("c1", "")
+			conversation.State, conversation.ConnectedAgent = types.Assigned, agent.Id
+
+			setupRouting(t, LeastBusyStrategy{}, []*types.Agent{agent}, conversation)
+			useFakeAuthenticator(t)
+			Permissions.Init()
+			channel := useFakeChannel(t)
+			if test.fail {
+				testDB.fail("INSERT INTO messages")
+			}
+
+			text := "We're on it, customer said \"urgent\""
+			reply := sendCommand(t, agent, map[string]interface{}{"action": CMD_ADD_NOTE, "conversationID": "c1", "agentID": "1001", "text": text})
+
+			if test.fail && (reply["success"] != float64(0) || reply["failed_message"] != test.failedMsg) {
+				t.Errorf("reply = %v, want success 0 with %q", reply, test.failedMsg)
+			}
+			if !test.fail && (reply["success"] != float64(1) || reply["message"] == nil) {
+				t.Errorf("reply = %v, want success 1 with the note", reply)
+			}
+
+			stored := testDB.argsOf("INSERT INTO messages")
+			if len(stored) != 1 {
+				t.Fatalf("stored %d messages, want the note", len(stored))
+			}
+			if want := []driver.Value{"c1", text, stored[0][2], int64(types.Sent), true, int64(types.Note), "", "1001"}; !reflect.DeepEqual(stored[0], want) {
+				t.Errorf("note stored as %v, want %v", stored[0], want)
+			}
+
+			if sent := channel.sentMessages(); len(sent) != 0 {
+				t.Errorf("note was sent to the customer: %v", sent)
+			}
+		})
+	}
+}
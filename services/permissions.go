@@ -0,0 +1,263 @@
+package services
+
+import (
+	"log"
+	"net"
+	"server/types"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+var Permissions PermissionManager
+
+var (
+	allRoles        = []types.AgentRole{types.RoleAgent, types.RoleSupervisor, types.RoleAdmin}
+	supervisorRoles = []types.AgentRole{types.RoleSupervisor, types.RoleAdmin}
+	adminRoles      = []types.AgentRole{types.RoleAdmin}
+	knownRoles      = map[types.AgentRole]bool{types.RoleAgent: true, types.RoleSupervisor: true, types.RoleAdmin: true}
+)
+
+// defaultPermissions are used for the commands permissions_conf.ini does not mention
+var defaultPermissions = map[string][]types.AgentRole{
+	CMD_AGENT_LOGOFF:          allRoles,
+	CMD_SET_AGENT_STATE:       allRoles,
+	CMD_SET_MAX_CONVERSATIONS: supervisorRoles,
+
+	CMD_ACCEPT_CONVERSATION:   allRoles,
+	CMD_DECLINE_CONVERSATION:  allRoles,
+	CMD_FINISH_CONVERSATION:   allRoles,
+	CMD_TRANSFER_CONVERSATION: allRoles,
+	CMD_ACCEPT_TRANSFER:       allRoles,
+	CMD_DECLINE_TRANSFER:      allRoles,
+
+	CMD_GET_MESSAGES:         allRoles,
+	CMD_GET_CUSTOMER_HISTORY: allRoles,
+	CMD_SEND_MESSAGE:         allRoles,
+	CMD_ADD_NOTE:             allRoles,
+
+	CMD_GET_CANNED_RESPONSES:   allRoles,
+	CMD_FIND_CANNED_RESPONSE:   allRoles,
+	CMD_ADD_CANNED_RESPONSE:    allRoles,
+	CMD_UPDATE_CANNED_RESPONSE: allRoles,
+	CMD_DELETE_CANNED_RESPONSE: allRoles,
+
+	CMD_GET_ACTIVE_CONVERSATIONS: supervisorRoles,
+	CMD_MONITOR_CONVERSATION:     supervisorRoles,
+	CMD_STOP_MONITORING:          supervisorRoles,
+	CMD_WHISPER:                  supervisorRoles,
+	CMD_REASSIGN_CONVERSATION:    supervisorRoles,
+	CMD_FORCE_CLOSE_CONVERSATION: supervisorRoles,
+
+	CMD_SET_AGENT_SKILLS:       supervisorRoles,
+	CMD_SET_CUSTOMER_ATTRIBUTE: allRoles,
+
+	CMD_GET_QUEUES:  allRoles,
+	CMD_JOIN_QUEUE:  allRoles,
+	CMD_LEAVE_QUEUE: allRoles,
+
+	CMD_CALL_CUSTOMER: allRoles,
+
+	CMD_CREATE_AGENT:         adminRoles,
+	CMD_DISABLE_AGENT:        adminRoles,
+	CMD_ENABLE_AGENT:         adminRoles,
+	CMD_RESET_AGENT_PASSWORD: adminRoles,
+	CMD_SET_AGENT_ROLES:      adminRoles,
+	CMD_GET_AUDIT_LOG:        adminRoles,
+}
+
+// offeredCommands may be run on a conversation that is only offered or transferred to the agent
+var offeredCommands = map[string]bool{
+	CMD_ACCEPT_CONVERSATION:  true,
+	CMD_DECLINE_CONVERSATION: true,
+	CMD_ACCEPT_TRANSFER:      true,
+	CMD_DECLINE_TRANSFER:     true,
+	CMD_GET_MESSAGES:         true,
+	CMD_GET_CUSTOMER_HISTORY: true,
+}
+
+// historyCommands may be run on a finished conversation of a customer the agent may access
+var historyCommands = map[string]bool{
+	CMD_GET_MESSAGES: true,
+}
+
+// PermissionManager decides which roles may run each TCP command. Commands without any permission are denied.
+type PermissionManager struct {
+	commands         map[string][]types.AgentRole //map[command]roles
+	actForOthers     []types.AgentRole            //roles that may run commands on behalf of other agents
+	allConversations []types.AgentRole            //roles that may access conversations handled by other agents
+}
+
+func (p *PermissionManager) Init() {
+
+	p.commands = make(map[string][]types.AgentRole)
+	for command, roles := range defaultPermissions {
+		p.commands[command] = roles
+	}
+	p.actForOthers = supervisorRoles
+	p.allConversations = supervisorRoles
+
+	cfg, err := ini.Load("conf/permissions_conf.ini")
+	if err != nil {
+		log.Println("Failed to read permissions_conf file, using the default permissions: ", err)
+		return
+	}
+
+	general := cfg.Section("general")
+	if general.HasKey("ACT_FOR_OTHERS") {
+		p.actForOthers = parseRoleList(general.Key("ACT_FOR_OTHERS").Strings(","))
+	}
+	if general.HasKey("ALL_CONVERSATIONS") {
+		p.allConversations = parseRoleList(general.Key("ALL_CONVERSATIONS").Strings(","))
+	}
+
+	for _, key := range cfg.Section("commands").Keys() {
+		p.commands[strings.ToLower(key.Name())] = parseRoleList(key.Strings(","))
+	}
+}
+
+func parseRoleList(names []string) []types.AgentRole {
+
+	var roles []types.AgentRole
+	for _, name := range names {
+		if role := types.AgentRole(strings.ToLower(name)); knownRoles[role] {
+			roles = append(roles, role)
+		} else {
+			log.Println("Unknown role in permissions: ", name)
+		}
+	}
+
+	return roles
+}
+
+func hasAnyRole(agent *types.Agent, roles []types.AgentRole) bool {
+	for _, role := range roles {
+		if agent.HasRole(role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *PermissionManager) IsAllowed(agent *types.Agent, command string) bool {
+	return hasAnyRole(agent, p.commands[command])
+}
+
+// CanAccessConversation lets agents work only on their own conversations. On the ones offered or transferred
+// to them they may only run the offeredCommands, to read them and to accept or decline them.
+// Finished conversations are the customer's history, they may be read by the agents who may access the customer.
+func (p *PermissionManager) CanAccessConversation(agent *types.Agent, conversationID string, command string) bool {
+
+	if hasAnyRole(agent, p.allConversations) {
+		return true
+	}
+
+	conversation := Omnichannel.FindActiveConversationByID(conversationID)
+	if conversation == nil {
+		if !historyCommands[command] {
+			return false
+		}
+
+		finished := Omnichannel.FindConversationByID(conversationID)
+
+		return finished != nil && finished.State == types.Finished && p.CanAccessCustomer(agent, finished.CustomerID, command)
+	}
+
+	if conversation.ConnectedAgent == agent.Id {
+		return true
+	}
+
+	if !offeredCommands[command] {
+		return false
+	}
+
+	return Router.IsOfferedTo(conversation, agent) || Router.IsTransferredTo(conversationID, agent.Id)
+}
+
+func (p *PermissionManager) CanAccessCustomer(agent *types.Agent, customerID string, command string) bool {
+
+	if hasAnyRole(agent, p.allConversations) {
+		return true
+	}
+
+	conversation := Omnichannel.FindActiveConversationFromCustomer(customerID)
+
+	return conversation != nil && p.CanAccessConversation(agent, conversation.Id, command)
+}
+
+// Authorize checks the command against the roles of the agent logged in on the connection
+func (s *TCPServer) Authorize(con net.Conn, action string, parsedData map[string]interface{}) (failedMsg string) {
+
+	if action == CMD_AGENT_LOGIN {
+		return ""
+	}
+
+	agent := s.GetAgentBySocket(con)
+	if agent == nil {
+		return "Agent is not logged in"
+	}
+
+	if !Permissions.IsAllowed(agent, action) {
+		log.Println("Agent ", agent.Id, " is not allowed to run ", action)
+		return "Permission denied"
+	}
+
+	if !hasAnyRole(agent, Permissions.actForOthers) {
+		arguments := []string{"agentID"}
+		if action == CMD_AGENT_LOGOFF {
+			arguments = append(arguments, "id")
+		}
+
+		for _, argument := range arguments {
+			if agentID, ok := parsedData[argument].(string); ok && agentID != agent.Id {
+				return "Permission denied"
+			}
+		}
+	}
+
+	if conversationID, ok := parsedData["conversationID"].(string); ok && !Permissions.CanAccessConversation(agent, conversationID, action) {
+		return "Permission denied"
+	}
+
+	if customerID, ok := parsedData["customer_id"].(string); ok && !Permissions.CanAccessCustomer(agent, customerID, action) {
+		return "Permission denied"
+	}
+
+	return ""
+}
+
+// LoadRoles reads the roles login_auth_conf.ini grants to agents on top of the ones from their authenticator
+func (s *TCPServer) LoadRoles() {
+
+	s.roles = make(map[string][]types.AgentRole)
+
+	cfg, err := ini.Load("conf/login_auth_conf.ini")
+	if err != nil {
+		log.Println("Failed to read login_auth_conf file: ", err)
+		return
+	}
+
+	for _, agentID := range cfg.Section("roles").Key("SUPERVISORS").Strings(",") {
+		s.roles[agentID] = append(s.roles[agentID], types.RoleSupervisor)
+	}
+	for _, agentID := range cfg.Section("roles").Key("ADMINS").Strings(",") {
+		s.roles[agentID] = append(s.roles[agentID], types.RoleAdmin)
+	}
+}
+
+// GetRoles merges the roles returned by the authenticator with the configured ones, every agent has the agent role
+func (s *TCPServer) GetRoles(agentID string, authenticatedRoles []types.AgentRole) []types.AgentRole {
+
+	roles := []types.AgentRole{types.RoleAgent}
+	seen := map[types.AgentRole]bool{types.RoleAgent: true}
+
+	for _, role := range append(append([]types.AgentRole(nil), authenticatedRoles...), s.roles[agentID]...) {
+		if !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+
+	return roles
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"database/sql/driver"
+	"server/types"
+	"testing"
+)
+
+// setupPermissions logs in agent 1001 handling c1, agent 1002 offered c2 and supervisor 2001,
+// with the default permissions. Finished conversations are answered by finishedCustomer.
+func setupPermissions(t *testing.T, finishedCustomer string) (agent *types.Agent, offered *types.Agent, supervisor *types.Agent) {
+
+	agent, offered, supervisor = newTestAgent("1001"), newTestAgent("1002"), newTestAgent("2001")
+	supervisor.Roles = append(supervisor.Roles, types.RoleSupervisor)
+	agent.State, supervisor.State = types.Busy, types.Away
+
+	own := newTestConversation("c1", "")
+	own.State, own.ConnectedAgent = types.Assigned, agent.Id
+	waiting := newTestConversation("c2", "")
+
+	setupRouting(t, LongestIdleStrategy{}, []*types.Agent{agent, offered, supervisor}, own, waiting)
+	Router.RouteConversation(waiting)
+
+	if finishedCustomer != "" {
+		testDB.answer("FROM conversations WHERE id=?", []string{"customer_id", "type", "connected_agent", "created_timestamp", "state", "queue"},
+			[]driver.Value{finishedCustomer, int64(types.WhatsApp), "1001", int64(1), int64(types.Finished), ""})
+	}
+
+	Permissions.Init()
+
+	return agent, offered, supervisor
+}
+
+func TestPermissionsIsAllowed(t *testing.T) {
+
+	agent, _, supervisor := setupPermissions(t, "")
+	admin := newTestAgent("3001")
+	admin.Roles = append(admin.Roles, types.RoleAdmin)
+
+	tests := []struct {
+		agent   *types.Agent
+		command string
+		allowed bool
+	}{
+		{agent, CMD_SEND_MESSAGE, true},
+		{agent, CMD_FORCE_CLOSE_CONVERSATION, false},
+		{agent, CMD_GET_AUDIT_LOG, false},
+		{supervisor, CMD_FORCE_CLOSE_CONVERSATION, true},
+		{supervisor, CMD_CREATE_AGENT, false},
+		{admin, CMD_CREATE_AGENT, true},
+		{admin, "unknown_command", false},
+	}
+
+	for _, test := range tests {
+		if allowed := Permissions.IsAllowed(test.agent, test.command); allowed != test.allowed {
+			t.Errorf("IsAllowed(%v, %s) = %v, want %v", test.agent.Roles, test.command, allowed, test.allowed)
+		}
+	}
+}
+
+func TestPermissionsCanAccessConversation(t *testing.T) {
+
+	tests := []struct {
+		name             string
+		finishedCustomer string
+		agent            string
+		conversationID   string
+		command          string
+		allowed          bool
+	}{
+		{"own conversation", "", "1001", "c1", CMD_SEND_MESSAGE, true},
+		{"conversation of another agent", "", "1002", "c1", CMD_GET_MESSAGES, false},
+		{"supervisor", "", "2001", "c1", CMD_SEND_MESSAGE, true},
+		{"offered conversation read", "", "1002", "c2", CMD_GET_MESSAGES, true},
+		{"offered conversation accepted", "", "1002", "c2", CMD_ACCEPT_CONVERSATION, true},
+		{"offered conversation written to", "", "1002", "c2", CMD_SEND_MESSAGE, false},
+		{"conversation offered to another agent", "", "1001", "c2", CMD_GET_MESSAGES, false},
+		{"history of the own customer", "customer-c1", "1001", "old", CMD_GET_MESSAGES, true},
+		{"history written to", "customer-c1", "1001", "old", CMD_SEND_MESSAGE, false},
+		{"history of a customer offered to the agent", "customer-c2", "1002", "old", CMD_GET_MESSAGES, true},
+		{"history of another agent's customer", "customer-c1", "1002", "old", CMD_GET_MESSAGES, false},
+		{"history of a customer nobody serves", "customer-c9", "1001", "old", CMD_GET_MESSAGES, false},
+		{"unknown conversation", "", "1001", "old", CMD_GET_MESSAGES, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			setupPermissions(t, test.finishedCustomer)
+
+			agent := TcpServer.GetAgent(test.agent)
+			if allowed := Permissions.CanAccessConversation(agent, test.conversationID, test.command); allowed != test.allowed {
+				t.Errorf("CanAccessConversation(%s, %s, %s) = %v, want %v", test.agent, test.conversationID, test.command, allowed, test.allowed)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+
+	agent, _, supervisor := setupPermissions(t, "")
+
+	tests := []struct {
+		name      string
+		socket    *fakeSocket
+		action    string
+		data      map[string]interface{}
+		failedMsg string
+	}{
+		{"login without a session", &fakeSocket{}, CMD_AGENT_LOGIN, map[string]interface{}{}, ""},
+		{"command without a session", &fakeSocket{}, CMD_GET_QUEUES, map[string]interface{}{}, "Agent is not logged in"},
+		{"role without the command", socketOf(agent), CMD_FORCE_CLOSE_CONVERSATION, map[string]interface{}{"conversationID": "c1"}, "Permission denied"},
+		{"own state", socketOf(agent), CMD_SET_AGENT_STATE, map[string]interface{}{"agentID": "1001"}, ""},
+		{"state of another agent", socketOf(agent), CMD_SET_AGENT_STATE, map[string]interface{}{"agentID": "1002"}, "Permission denied"},
+		{"logoff of another agent", socketOf(agent), CMD_AGENT_LOGOFF, map[string]interface{}{"id": "1002"}, "Permission denied"},
+		{"supervisor acting for another agent", socketOf(supervisor), CMD_SET_AGENT_STATE, map[string]interface{}{"agentID": "1002"}, ""},
+		{"own conversation", socketOf(agent), CMD_SEND_MESSAGE, map[string]interface{}{"conversationID": "c1"}, ""},
+		{"conversation of another agent", socketOf(agent), CMD_GET_MESSAGES, map[string]interface{}{"conversationID": "c2"}, "Permission denied"},
+		{"own customer", socketOf(agent), CMD_GET_CUSTOMER_HISTORY, map[string]interface{}{"customer_id": "customer-c1"}, ""},
+		{"customer of another conversation", socketOf(agent), CMD_GET_CUSTOMER_HISTORY, map[string]interface{}{"customer_id": "customer-c2"}, "Permission denied"},
+	}
+
+	for _, test := range tests {
+		if failedMsg := TcpServer.Authorize(test.socket, test.action, test.data); failedMsg != test.failedMsg {
+			t.Errorf("%s: Authorize(%s) failed with %q, want %q", test.name, test.action, failedMsg, test.failedMsg)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"log"
+	"server/types"
+	"sync"
+)
+
+var PhonePresence PhonePresenceTracker
+
+// PhonePresenceTracker marks agents busy for chat routing while their phone rings or is on a call,
+// and makes them available again on hangup unless they changed their state themselves in the meantime
+type PhonePresenceTracker struct {
+	busyOnCall map[string]bool //map[agentID]made busy by a call
+	mutex      sync.Mutex
+}
+
+// PhoneStateChanged is called by the Asterisk authenticator for every change of an extension's phone state
+func (p *PhonePresenceTracker) PhoneStateChanged(agentID string, state types.PhoneState) {
+
+	if !TcpServer.updateAgent(agentID, func(agent *types.Agent) { agent.PhoneState = state }) {
+		return
+	}
+
+	jsonData := make(map[string]interface{})
+	jsonData["event"] = EVENT_AGENT_PHONE_STATE_CHANGED
+	jsonData["agentID"] = agentID
+	jsonData["phone_state"] = state
+	TcpServer.SendEventToAgents(jsonData, "")
+
+	p.apply(agentID)
+}
+
+// apply sets the agent's presence from its phone state, e.g. after login during a call
+func (p *PhonePresenceTracker) apply(agentID string) {
+
+	TcpServer.agentsMutex.RLock()
+	agent := TcpServer.findAgent(agentID)
+	if agent == nil {
+		TcpServer.agentsMutex.RUnlock()
+		return
+	}
+	agentState, phoneState := agent.State, agent.PhoneState
+	TcpServer.agentsMutex.RUnlock()
+
+	p.mutex.Lock()
+	if p.busyOnCall == nil {
+		p.busyOnCall = make(map[string]bool)
+	}
+
+	setState := false
+	state := agentState
+
+	if phoneState != types.PhoneIdle && agentState == types.Available {
+		p.busyOnCall[agentID] = true
+		setState, state = true, types.Busy
+	} else if phoneState == types.PhoneIdle && p.busyOnCall[agentID] {
+		delete(p.busyOnCall, agentID)
+		setState, state = agentState == types.Busy, types.Available
+	}
+	p.mutex.Unlock()
+
+	if setState {
+		log.Println("Agent ", agentID, " phone state ", phoneState, ", setting state to ", state)
+		TcpServer.SetAgentState(agentID, state)
+	}
+}
+
+// AgentLoggedIn takes over the phone state of an agent that logs in
+func (p *PhonePresenceTracker) AgentLoggedIn(agent *types.Agent, phoneState types.PhoneState) {
+
+	p.mutex.Lock()
+	delete(p.busyOnCall, agent.Id)
+	p.mutex.Unlock()
+
+	TcpServer.updateAgent(agent.Id, func(agent *types.Agent) { agent.PhoneState = phoneState })
+	p.apply(agent.Id)
+}
+
+// IsBusyOnCall reports whether the agent is busy only because of a call
+func (p *PhonePresenceTracker) IsBusyOnCall(agentID string) bool {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.busyOnCall[agentID]
+}
+
+// Forget drops the agent when it sets its state itself, so a hangup does not override it
+func (p *PhonePresenceTracker) Forget(agentID string) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.busyOnCall, agentID)
+}
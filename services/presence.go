@@ -0,0 +1,96 @@
+package services
+
+import (
+	"server/auths"
+	"server/db"
+	"server/types"
+	"strconv"
+)
+
+// AgentCanTakeConversation reports whether the agent is available and below its concurrent conversation limit,
+// the caller holds TcpServer.agentsMutex
+func AgentCanTakeConversation(agent *types.Agent) bool {
+
+	if agent.State != types.Available {
+		return false
+	}
+
+	return agent.MaxConversations <= 0 || agent.Conversations < agent.MaxConversations
+}
+
+func (s *TCPServer) SetAgentState(agentID string, state types.AgentState) bool {
+
+	if state < types.Available || state > types.Offline {
+		return false
+	}
+
+	if !s.updateAgent(agentID, func(agent *types.Agent) { agent.State = state }) {
+		return false
+	}
+
+	jsonData := make(map[string]interface{})
+	jsonData["event"] = EVENT_AGENT_STATE_CHANGED
+	jsonData["agentID"] = agentID
+	jsonData["state"] = state
+	s.SendEventToAgents(jsonData, "")
+
+	if state == types.Available {
+		go Router.RouteWaitingConversations()
+	} else {
+		go Router.ReleaseAgentOffers(agentID)
+	}
+
+	// a call already keeps the agent from getting voice calls, its queues stay unpaused for the next one
+	if member, ok := s.loginAuthenticator.(auths.VoiceQueueMember); ok && !PhonePresence.IsBusyOnCall(agentID) {
+		go member.SetVoiceAvailability(agentID, state)
+	}
+
+	return true
+}
+
+// VoiceAvailabilityChanged follows pauses and unpauses of the agent's voice queues made on the Asterisk side
+func (s *TCPServer) VoiceAvailabilityChanged(agentID string, state types.AgentState) {
+
+	s.agentsMutex.RLock()
+	agent := s.findAgent(agentID)
+	current := types.Offline
+	if agent != nil {
+		current = agent.State
+	}
+	s.agentsMutex.RUnlock()
+
+	if agent == nil || current == state {
+		return
+	}
+
+	if state == types.Available {
+		// unpausing ends a break, but not the busy state of an agent on a call
+		if (current != types.Busy && current != types.Away) || PhonePresence.IsBusyOnCall(agentID) {
+			return
+		}
+	} else if current != types.Available && !PhonePresence.IsBusyOnCall(agentID) {
+		return
+	}
+
+	PhonePresence.Forget(agentID)
+	s.SetAgentState(agentID, state)
+}
+
+func (o *OmniChannel) GetAgentMaxConversations(agentID string) int {
+
+	maxConversations := Router.MaxConversations
+
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "SELECT max_conversations FROM agent_settings WHERE agent_id='"+agentID+"'")
+	defer results.Close()
+
+	if results.Next() {
+		results.Scan(&maxConversations)
+	}
+
+	return maxConversations
+}
+
+func (o *OmniChannel) SetAgentMaxConversations(agentID string, maxConversations int) {
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "REPLACE INTO agent_settings(agent_id, max_conversations) VALUES('"+agentID+"',"+strconv.Itoa(maxConversations)+")")
+	defer results.Close()
+}
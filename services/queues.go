@@ -0,0 +1,300 @@
+package services
+
+import (
+	"log"
+	"server/db"
+	"server/types"
+	"sort"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+var QueueManager ChatQueueManager
+
+type ChatQueue struct {
+	Name            string
+	Channels        []types.ChannelType
+	Priority        int
+	MaxWait         time.Duration
+	Overflow        string
+	Calendar        string
+	DeferOutOfHours bool
+
+	InactivityWarning time.Duration
+	InactivityClose   time.Duration
+
+	SLAFirstResponse     time.Duration
+	SLAResolution        time.Duration
+	SLAWarningPercentage int
+}
+
+type ChatQueueManager struct {
+	Queues map[string]*ChatQueue //map[queueName]queue
+
+	DefaultInactivityWarning time.Duration
+	DefaultInactivityClose   time.Duration
+	DefaultQueues            []string //queues of the agents who have not joined any queue themselves
+}
+
+func (q *ChatQueueManager) Init() {
+
+	q.Queues = make(map[string]*ChatQueue)
+
+	cfg, err := ini.Load("conf/queues_conf.ini")
+	if err != nil {
+		log.Println("Failed to read queues_conf file: ", err)
+		return
+	}
+
+	for _, section := range cfg.Sections() {
+		if section.Name() == ini.DefaultSection {
+			q.DefaultInactivityWarning = time.Duration(section.Key("INACTIVITY_WARNING").MustInt(0)) * time.Minute
+			q.DefaultInactivityClose = time.Duration(section.Key("INACTIVITY_CLOSE").MustInt(0)) * time.Minute
+			q.DefaultQueues = section.Key("DEFAULT_QUEUES").Strings(",")
+			continue
+		}
+
+		queue := ChatQueue{Name: section.Name()}
+		for _, channelName := range section.Key("CHANNELS").Strings(",") {
+			if channelType := types.GetChannelType(channelName); channelType != types.Unknown {
+				queue.Channels = append(queue.Channels, channelType)
+			}
+		}
+		queue.Priority = section.Key("PRIORITY").MustInt(0)
+		queue.MaxWait = time.Duration(section.Key("MAX_WAIT").MustInt(0)) * time.Second
+		queue.Overflow = section.Key("OVERFLOW").String()
+		queue.Calendar = section.Key("CALENDAR").String()
+		queue.DeferOutOfHours = section.Key("DEFER_OUT_OF_HOURS").MustBool(false)
+		queue.InactivityWarning = time.Duration(section.Key("INACTIVITY_WARNING").MustInt(-1)) * time.Minute
+		queue.InactivityClose = time.Duration(section.Key("INACTIVITY_CLOSE").MustInt(-1)) * time.Minute
+		queue.SLAFirstResponse = time.Duration(section.Key("SLA_FIRST_RESPONSE").MustInt(0)) * time.Second
+		queue.SLAResolution = time.Duration(section.Key("SLA_RESOLUTION").MustInt(0)) * time.Second
+		queue.SLAWarningPercentage = section.Key("SLA_WARNING_PERCENTAGE").MustInt(80)
+
+		q.Queues[queue.Name] = &queue
+	}
+}
+
+// SelectQueue returns the highest priority queue serving the channel, or "" if no queue serves it
+func (q *ChatQueueManager) SelectQueue(channelType types.ChannelType) string {
+
+	var selected *ChatQueue
+
+	for _, queue := range q.Queues {
+		for _, queueChannel := range queue.Channels {
+			if queueChannel == channelType && (selected == nil || queue.Priority > selected.Priority) {
+				selected = queue
+			}
+		}
+	}
+
+	if selected == nil {
+		return ""
+	}
+
+	return selected.Name
+}
+
+func (q *ChatQueueManager) GetPriority(queueName string) int {
+	if queue := q.Queues[queueName]; queue != nil {
+		return queue.Priority
+	}
+
+	return 0
+}
+
+// GetInactivityTimers returns after how long an idle customer is warned and the conversation closed, 0 disables the timer.
+// Queues without their own values use the ones from the default section.
+func (q *ChatQueueManager) GetInactivityTimers(queueName string) (warning time.Duration, close time.Duration) {
+
+	warning, close = q.DefaultInactivityWarning, q.DefaultInactivityClose
+
+	if queue := q.Queues[queueName]; queue != nil {
+		if queue.InactivityWarning >= 0 {
+			warning = queue.InactivityWarning
+		}
+		if queue.InactivityClose >= 0 {
+			close = queue.InactivityClose
+		}
+	}
+
+	return warning, close
+}
+
+// GetSLATargets returns the first response and resolution targets of the queue and at which percentage of them to warn, 0 means no target
+func (q *ChatQueueManager) GetSLATargets(queueName string) (firstResponse time.Duration, resolution time.Duration, warningPercentage int) {
+
+	if queue := q.Queues[queueName]; queue != nil {
+		return queue.SLAFirstResponse, queue.SLAResolution, queue.SLAWarningPercentage
+	}
+
+	return 0, 0, 0
+}
+
+// CheckOverflow moves the conversation to the overflow queue once it waited longer than the queue allows
+func (q *ChatQueueManager) CheckOverflow(conversation *types.Conversation) bool {
+
+	queue := q.Queues[conversation.Queue]
+	if queue == nil || queue.MaxWait == 0 || queue.Overflow == "" || q.Queues[queue.Overflow] == nil {
+		return false
+	}
+
+	if time.Since(time.UnixMilli(int64(conversation.Queued_Timestamp))) < queue.MaxWait {
+		return false
+	}
+
+	log.Println("Conversation ", conversation.Id, " overflowed from ", queue.Name, " to ", queue.Overflow)
+	Omnichannel.UpdateConversationQueue(conversation, queue.Overflow)
+
+	return true
+}
+
+// SortByPriority orders conversations so higher priority queues and longer waiting conversations come first
+func (q *ChatQueueManager) SortByPriority(conversations []*types.Conversation) {
+
+	sort.SliceStable(conversations, func(i, j int) bool {
+		priorityI := q.GetPriority(conversations[i].Queue)
+		priorityJ := q.GetPriority(conversations[j].Queue)
+		if priorityI != priorityJ {
+			return priorityI > priorityJ
+		}
+
+		return conversations[i].Queued_Timestamp < conversations[j].Queued_Timestamp
+	})
+}
+
+func (q *ChatQueueManager) IsMember(agent *types.Agent, queueName string) bool {
+	for _, queue := range agent.Queues {
+		if queue == queueName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetQueuesInfo describes every queue with its logged in members and number of waiting conversations
+func (q *ChatQueueManager) GetQueuesInfo(agentID string) []map[string]interface{} {
+
+	var queuesInfo []map[string]interface{}
+
+	waiting := make(map[string]int)
+	for _, conversation := range Omnichannel.GetUnassignedConversations() {
+		waiting[conversation.Queue]++
+	}
+
+	var names []string
+	for name := range q.Queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		queue := q.Queues[name]
+
+		var channelNames []string
+		for _, channelType := range queue.Channels {
+			channelNames = append(channelNames, types.ChannelNames[channelType])
+		}
+
+		var members []string
+		isMember := false
+
+		TcpServer.agentsMutex.RLock()
+		for _, agent := range TcpServer.LoggedAgents {
+			if q.IsMember(agent, name) {
+				members = append(members, agent.Id)
+				isMember = isMember || agent.Id == agentID
+			}
+		}
+		TcpServer.agentsMutex.RUnlock()
+
+		queueInfo := make(map[string]interface{})
+		queueInfo["name"] = queue.Name
+		queueInfo["channels"] = channelNames
+		queueInfo["priority"] = queue.Priority
+		queueInfo["max_wait"] = int(queue.MaxWait.Seconds())
+		queueInfo["overflow"] = queue.Overflow
+		queueInfo["members"] = members
+		queueInfo["member"] = isMember
+		queueInfo["waiting"] = waiting[name]
+
+		queuesInfo = append(queuesInfo, queueInfo)
+	}
+
+	return queuesInfo
+}
+
+// GetAgentQueues returns the queues the agent joined, an agent without any stored membership serves the default queues
+func (q *ChatQueueManager) GetAgentQueues(agentID string) []string {
+
+	var queues []string
+
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "SELECT queue FROM agent_queues WHERE agent_id=?", agentID)
+	defer results.Close()
+
+	stored := false
+	for results.Next() {
+		var queue string
+		if err := results.Scan(&queue); err == nil {
+			stored = true
+			if q.Queues[queue] != nil {
+				queues = append(queues, queue)
+			}
+		}
+	}
+
+	if !stored {
+		for _, queue := range q.DefaultQueues {
+			if q.Queues[queue] != nil {
+				queues = append(queues, queue)
+			}
+		}
+	}
+
+	return queues
+}
+
+func (q *ChatQueueManager) JoinQueue(agentID string, queueName string) bool {
+
+	if q.Queues[queueName] == nil {
+		return false
+	}
+
+	TcpServer.updateAgent(agentID, func(agent *types.Agent) {
+		if !q.IsMember(agent, queueName) {
+			agent.Queues = append(agent.Queues, queueName)
+		}
+	})
+
+	db.DBConnector.Execute(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "REPLACE INTO agent_queues(agent_id, queue) VALUES(?, ?)", agentID, queueName)
+
+	return true
+}
+
+func (q *ChatQueueManager) LeaveQueue(agentID string, queueName string) bool {
+
+	TcpServer.updateAgent(agentID, func(agent *types.Agent) {
+		for i := range agent.Queues {
+			if agent.Queues[i] == queueName {
+				agent.Queues = append(agent.Queues[:i], agent.Queues[i+1:]...)
+				break
+			}
+		}
+	})
+
+	db.DBConnector.Execute(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "DELETE FROM agent_queues WHERE agent_id=? AND queue=?", agentID, queueName)
+
+	return true
+}
+
+func (o *OmniChannel) UpdateConversationQueue(conversation *types.Conversation, queueName string) {
+
+	o.conversationsMutex.Lock()
+	conversation.Queue = queueName
+	conversation.Queued_Timestamp = uint(time.Now().UnixMilli())
+	o.conversationsMutex.Unlock()
+
+	db.DBConnector.Execute(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "UPDATE conversations SET queue=? WHERE id=?", queueName, conversation.Id)
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"server/types"
+	"testing"
+)
+
+func setupQueues(t *testing.T, agents ...*types.Agent) {
+
+	setupRouting(t, LeastBusyStrategy{}, agents)
+	QueueManager.Queues["Sales"] = &ChatQueue{Name: "Sales"}
+	QueueManager.Queues["Support"] = &ChatQueue{Name: "Support"}
+
+	defaults := QueueManager.DefaultQueues
+	QueueManager.DefaultQueues = []string{"Sales", "Removed"}
+	t.Cleanup(func() { QueueManager.DefaultQueues = defaults })
+}
+
+func TestGetAgentQueues(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		stored []string
+		queues []string
+	}{
+		{"no membership stored", nil, []string{"Sales"}},
+		{"joined queues", []string{"Support", "Sales"}, []string{"Support", "Sales"}},
+		{"only a removed queue stored", []string{"Removed"}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			setupQueues(t)
+
+			var rows [][]driver.Value
+			for _, queue := range test.stored {
+				rows = append(rows, []driver.Value{queue})
+			}
+			testDB.answer("FROM agent_queues", []string{"queue"}, rows...)
+
+			if queues := QueueManager.GetAgentQueues("1001"); !reflect.DeepEqual(queues, test.queues) {
+				t.Errorf("GetAgentQueues = %v, want %v", queues, test.queues)
+			}
+			if args := testDB.argsOf("FROM agent_queues"); len(args) != 1 || !reflect.DeepEqual(args[0], []driver.Value{"1001"}) {
+				t.Errorf("queues were read with %v, want the agent ID as argument", args)
+			}
+		})
+	}
+}
+
+func TestJoinAndLeaveQueue(t *testing.T) {
+
+	agent := newTestAgent("1001")
+	setupQueues(t, agent)
+
+	if QueueManager.JoinQueue("1001", "Unknown") {
+		t.Errorf("joined a queue that does not exist")
+	}
+
+	for i := 0; i < 2; i++ {
+		if !QueueManager.JoinQueue("1001", "Sales") {
+			t.Fatalf("JoinQueue failed")
+		}
+	}
+	if !reflect.DeepEqual(agent.Queues, []string{"Sales"}) || !QueueManager.IsMember(agent, "Sales") || QueueManager.IsMember(agent, "Support") {
+		t.Errorf("agent is in %v after joining Sales twice, want [Sales]", agent.Queues)
+	}
+	if args := testDB.argsOf("REPLACE INTO agent_queues"); len(args) != 2 || !reflect.DeepEqual(args[0], []driver.Value{"1001", "Sales"}) {
+		t.Errorf("membership was stored with %v", args)
+	}
+
+	if !QueueManager.LeaveQueue("1001", "Sales") {
+		t.Fatalf("LeaveQueue failed")
+	}
+	if len(agent.Queues) != 0 || QueueManager.IsMember(agent, "Sales") {
+		t.Errorf("agent is in %v after leaving Sales, want no queue", agent.Queues)
+	}
+	if args := testDB.argsOf("DELETE FROM agent_queues"); len(args) != 1 || !reflect.DeepEqual(args[0], []driver.Value{"1001", "Sales"}) {
+		t.Errorf("membership was deleted with %v", args)
+	}
+}
+
+func TestCandidatesAreQueueMembers(t *testing.T) {
+
+	sales, support := newTestAgent("1001", "Sales"), newTestAgent("1002", "Support")
+	setupQueues(t, sales, support)
+
+	conversation := newTestConversation("c1", "Sales")
+
+	var candidates []string
+	for _, agent := range Router.candidateAgents(conversation, &conversationOffer{declinedBy: make(map[string]bool)}) {
+		candidates = append(candidates, agent.Id)
+	}
+
+	if !reflect.DeepEqual(candidates, []string{"1001"}) {
+		t.Errorf("candidates for a Sales conversation = %v, want [1001]", candidates)
+	}
+}
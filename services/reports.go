@@ -0,0 +1,261 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"server/db"
+	"server/types"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	REPORT_GROUP_CHANNEL = "channel"
+	REPORT_GROUP_AGENT   = "agent"
+	REPORT_GROUP_HOUR    = "hour"
+
+	REPORT_DATE_FORMAT = "2006-01-02"
+	REPORT_HOUR_FORMAT = "2006-01-02 15:00"
+)
+
+// ConversationStats is a single conversation with the timestamps the reports are aggregated from, 0 means it did not happen
+type ConversationStats struct {
+	Id            string
+	Type          types.ChannelType
+	State         types.ConversationState
+	Agent         string
+	Created       uint
+	Assigned      uint
+	FirstResponse uint
+	Finished      uint
+	Messages      int
+}
+
+// ReportRow aggregates the conversations of one channel, agent or hour, times are in seconds
+type ReportRow struct {
+	Group                   string  `json:"group"`
+	Conversations           int     `json:"conversations"`
+	Finished                int     `json:"finished"`
+	Abandoned               int     `json:"abandoned"`
+	AbandonRate             float64 `json:"abandon_rate"`
+	AvgHandleTime           float64 `json:"avg_handle_time"`
+	AvgFirstResponseTime    float64 `json:"avg_first_response_time"`
+	MessagesPerConversation float64 `json:"messages_per_conversation"`
+
+	handled        int
+	handleTime     float64
+	responded      int
+	responseTime   float64
+	messagesAmount int
+}
+
+// GetConversationStats loads the conversations created in [from, to)
+func (o *OmniChannel) GetConversationStats(from time.Time, to time.Time) []*ConversationStats {
+
+	var stats []*ConversationStats
+
+	messagesOf := func(condition string) string {
+		return " FROM messages m WHERE m.conversation_id=c.id AND " + condition + ")"
+	}
+	textMessage := "m.type=" + strconv.Itoa(int(types.Text))
+	eventMessage := func(event string) string {
+		return "m.type=" + strconv.Itoa(int(types.Event)) + " AND m.event='" + event + "'"
+	}
+
+	query := "SELECT c.id, c.type, c.state, COALESCE(NULLIF(s.agent_id, ''), c.connected_agent, ''), c.created_timestamp, " +
+		"COALESCE(NULLIF(s.assigned_timestamp, 0), (SELECT COALESCE(MIN(m.timestamp), 0)" + messagesOf(eventMessage(EVENT_CONVERSATION_ACCEPTED)) + "), " +
+		"(SELECT COALESCE(MIN(m.timestamp), 0)" + messagesOf(textMessage+" AND m.sent_from_agent=true") + ", " +
+		"(SELECT COALESCE(MAX(m.timestamp), 0)" + messagesOf(eventMessage(EVENT_CONVERSATION_FINISHED)) + ", " +
+		"(SELECT COUNT(*)" + messagesOf(textMessage) +
+		" FROM conversations c LEFT JOIN sla_outcomes s ON s.conversation_id=c.id" +
+		" WHERE c.created_timestamp>=" + strconv.FormatInt(from.UnixMilli(), 10) + " AND c.created_timestamp<" + strconv.FormatInt(to.UnixMilli(), 10)
+
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, query)
+	defer results.Close()
+
+	for results.Next() {
+		var conversation ConversationStats
+		if err := results.Scan(&conversation.Id, &conversation.Type, &conversation.State, &conversation.Agent, &conversation.Created, &conversation.Assigned, &conversation.FirstResponse, &conversation.Finished, &conversation.Messages); err != nil {
+			log.Println("Failed to read conversation stats: ", err)
+			continue
+		}
+		stats = append(stats, &conversation)
+	}
+
+	return stats
+}
+
+// BuildReport aggregates the conversations per channel, agent or hour of creation.
+// A finished conversation without any agent reply counts as abandoned.
+func BuildReport(conversations []*ConversationStats, groupBy string) []*ReportRow {
+
+	groups := make(map[string]*ReportRow)
+
+	for _, conversation := range conversations {
+
+		var group string
+		switch groupBy {
+		case REPORT_GROUP_AGENT:
+			group = conversation.Agent
+		case REPORT_GROUP_HOUR:
+			group = time.UnixMilli(int64(conversation.Created)).Format(REPORT_HOUR_FORMAT)
+		default:
+			group = types.ChannelNames[conversation.Type]
+		}
+
+		row := groups[group]
+		if row == nil {
+			row = &ReportRow{Group: group}
+			groups[group] = row
+		}
+
+		row.Conversations++
+		row.messagesAmount += conversation.Messages
+
+		if conversation.FirstResponse > conversation.Created {
+			row.responded++
+			row.responseTime += float64(conversation.FirstResponse-conversation.Created) / 1000
+		}
+
+		if conversation.State == types.Finished {
+			row.Finished++
+			if conversation.FirstResponse == 0 {
+				row.Abandoned++
+			} else if conversation.Assigned != 0 && conversation.Finished > conversation.Assigned {
+				row.handled++
+				row.handleTime += float64(conversation.Finished-conversation.Assigned) / 1000
+			}
+		}
+	}
+
+	var rows []*ReportRow
+	for _, row := range groups {
+		if row.Finished > 0 {
+			row.AbandonRate = float64(row.Abandoned) / float64(row.Finished)
+		}
+		if row.handled > 0 {
+			row.AvgHandleTime = row.handleTime / float64(row.handled)
+		}
+		if row.responded > 0 {
+			row.AvgFirstResponseTime = row.responseTime / float64(row.responded)
+		}
+		row.MessagesPerConversation = float64(row.messagesAmount) / float64(row.Conversations)
+
+		rows = append(rows, row)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Group < rows[j].Group
+	})
+
+	return rows
+}
+
+// parseReportRange reads the from and to parameters, dates without a time include the whole day; the default is the last 24 hours
+func parseReportRange(req *http.Request) (from time.Time, to time.Time, failedMsg string) {
+
+	parse := func(value string, endOfDay bool) (time.Time, bool) {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t, true
+		}
+		if t, err := time.ParseInLocation(REPORT_DATE_FORMAT, value, time.Local); err == nil {
+			if endOfDay {
+				t = t.AddDate(0, 0, 1)
+			}
+			return t, true
+		}
+		return time.Time{}, false
+	}
+
+	to = time.Now()
+	if value := req.URL.Query().Get("to"); value != "" {
+		var ok bool
+		if to, ok = parse(value, true); !ok {
+			return from, to, "Invalid to date"
+		}
+	}
+
+	from = to.Add(-24 * time.Hour)
+	if value := req.URL.Query().Get("from"); value != "" {
+		var ok bool
+		if from, ok = parse(value, false); !ok {
+			return from, to, "Invalid from date"
+		}
+	}
+
+	if !from.Before(to) {
+		return from, to, "Invalid date range"
+	}
+
+	return from, to, ""
+}
+
+// HandleReports serves /reports?group_by=channel|agent|hour&from=&to=&format=json|csv
+func HandleReports(w http.ResponseWriter, req *http.Request) {
+
+	from, to, failedMsg := parseReportRange(req)
+	if failedMsg != "" {
+		http.Error(w, failedMsg, http.StatusBadRequest)
+		return
+	}
+
+	groupBy := req.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = REPORT_GROUP_CHANNEL
+	} else if groupBy != REPORT_GROUP_CHANNEL && groupBy != REPORT_GROUP_AGENT && groupBy != REPORT_GROUP_HOUR {
+		http.Error(w, "Invalid group_by", http.StatusBadRequest)
+		return
+	}
+
+	rows := BuildReport(Omnichannel.GetConversationStats(from, to), groupBy)
+
+	if req.URL.Query().Get("format") == "csv" {
+		writeReportCSV(w, groupBy, rows)
+		return
+	}
+
+	report := make(map[string]interface{})
+	report["from"] = from
+	report["to"] = to
+	report["group_by"] = groupBy
+	report["rows"] = rows
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Println("Failed to write report: ", err)
+	}
+}
+
+func writeReportCSV(w http.ResponseWriter, groupBy string, rows []*ReportRow) {
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"report_"+groupBy+".csv\"")
+
+	formatFloat := func(value float64) string {
+		return strconv.FormatFloat(value, 'f', 2, 64)
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{groupBy, "conversations", "finished", "abandoned", "abandon_rate", "avg_handle_time", "avg_first_response_time", "messages_per_conversation"})
+
+	for _, row := range rows {
+		writer.Write([]string{
+			row.Group,
+			strconv.Itoa(row.Conversations),
+			strconv.Itoa(row.Finished),
+			strconv.Itoa(row.Abandoned),
+			formatFloat(row.AbandonRate),
+			formatFloat(row.AvgHandleTime),
+			formatFloat(row.AvgFirstResponseTime),
+			formatFloat(row.MessagesPerConversation),
+		})
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Println("Failed to write report: ", err)
+	}
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"server/types"
+	"testing"
+	"time"
+)
+
+// testReportConversations are four conversations on two channels and two agents, created in two hours
+func testReportConversations() []*ConversationStats {
+
+	at := func(hour int, minute int, second int) uint {
+		return uint(time.Date(2026, 3, 2, hour, minute, second, 0, time.Local).UnixMilli())
+	}
+
+	return []*ConversationStats{
+		// answered after 10s, handled for 5 minutes
+		{Id: "c1", Type: types.WhatsApp, State: types.Finished, Agent: "1001", Created: at(9, 0, 0), Assigned: at(9, 0, 5), FirstResponse: at(9, 0, 10), Finished: at(9, 5, 5), Messages: 4},
+		// answered after 30s, still open
+		{Id: "c2", Type: types.WhatsApp, State: types.Assigned, Agent: "1002", Created: at(9, 30, 0), Assigned: at(9, 30, 20), FirstResponse: at(9, 30, 30), Messages: 2},
+		// finished without an agent reply
+		{Id: "c3", Type: types.Viber, State: types.Finished, Agent: "1001", Created: at(10, 0, 0), Assigned: at(10, 0, 10), Finished: at(10, 1, 0), Messages: 1},
+		// answered after 20s, handled for 1 minute
+		{Id: "c4", Type: types.Viber, State: types.Finished, Agent: "1002", Created: at(10, 15, 0), Assigned: at(10, 15, 0), FirstResponse: at(10, 15, 20), Finished: at(10, 16, 0), Messages: 5},
+	}
+}
+
+func TestBuildReport(t *testing.T) {
+
+	tests := []struct {
+		groupBy string
+		rows    []ReportRow
+	}{
+		{REPORT_GROUP_CHANNEL, []ReportRow{
+			{Group: types.ChannelNames[types.Viber], Conversations: 2, Finished: 2, Abandoned: 1, AbandonRate: 0.5, AvgHandleTime: 60, AvgFirstResponseTime: 20, MessagesPerConversation: 3},
+			{Group: types.ChannelNames[types.WhatsApp], Conversations: 2, Finished: 1, Abandoned: 0, AbandonRate: 0, AvgHandleTime: 300, AvgFirstResponseTime: 20, MessagesPerConversation: 3},
+		}},
+		{REPORT_GROUP_AGENT, []ReportRow{
+			{Group: "1001", Conversations: 2, Finished: 2, Abandoned: 1, AbandonRate: 0.5, AvgHandleTime: 300, AvgFirstResponseTime: 10, MessagesPerConversation: 2.5},
+			{Group: "1002", Conversations: 2, Finished: 1, Abandoned: 0, AbandonRate: 0, AvgHandleTime: 60, AvgFirstResponseTime: 25, MessagesPerConversation: 3.5},
+		}},
+		{REPORT_GROUP_HOUR, []ReportRow{
+			{Group: "2026-03-02 09:00", Conversations: 2, Finished: 1, Abandoned: 0, AbandonRate: 0, AvgHandleTime: 300, AvgFirstResponseTime: 20, MessagesPerConversation: 3},
+			{Group: "2026-03-02 10:00", Conversations: 2, Finished: 2, Abandoned: 1, AbandonRate: 0.5, AvgHandleTime: 60, AvgFirstResponseTime: 20, MessagesPerConversation: 3},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.groupBy, func(t *testing.T) {
+
+			var rows []ReportRow
+			for _, row := range BuildReport(testReportConversations(), test.groupBy) {
+				rows = append(rows, ReportRow{Group: row.Group, Conversations: row.Conversations, Finished: row.Finished, Abandoned: row.Abandoned, AbandonRate: row.AbandonRate,
+					AvgHandleTime: row.AvgHandleTime, AvgFirstResponseTime: row.AvgFirstResponseTime, MessagesPerConversation: row.MessagesPerConversation})
+			}
+
+			if !reflect.DeepEqual(rows, test.rows) {
+				t.Errorf("BuildReport by %s =\n%+v\nwant\n%+v", test.groupBy, rows, test.rows)
+			}
+		})
+	}
+}
+
+func TestBuildReportWithoutConversations(t *testing.T) {
+	if rows := BuildReport(nil, REPORT_GROUP_CHANNEL); len(rows) != 0 {
+		t.Errorf("BuildReport without conversations = %+v, want no rows", rows)
+	}
+}
+
+func TestWriteReportCSV(t *testing.T) {
+
+	recorder := httptest.NewRecorder()
+	writeReportCSV(recorder, REPORT_GROUP_AGENT, BuildReport(testReportConversations(), REPORT_GROUP_AGENT))
+
+	want := "agent,conversations,finished,abandoned,abandon_rate,avg_handle_time,avg_first_response_time,messages_per_conversation\n" +
+		"1001,2,2,1,0.50,300.00,10.00,2.50\n" +
+		"1002,2,1,0,0.00,60.00,25.00,3.50\n"
+
+	if body := recorder.Body.String(); body != want {
+		t.Errorf("CSV report =\n%s\nwant\n%s", body, want)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/csv" {
+		t.Errorf("Content-Type = %q, want text/csv", contentType)
+	}
+}
@@ -0,0 +1,372 @@
+package services
+
+import (
+	"log"
+	"server/types"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+const (
+	DEFAULT_OFFER_TIMEOUT  = 30
+	DEFAULT_RETRY_INTERVAL = 10
+	DEFAULT_SKILL_FALLBACK = 120
+
+	DEFAULT_MAX_CONVERSATIONS = 5
+)
+
+var Router ConversationRouter
+
+type ConversationRouter struct {
+	StrategyName     string
+	Strategy         RoutingStrategy
+	OfferTimeout     time.Duration
+	RetryInterval    time.Duration
+	SkillFallback    time.Duration
+	KeywordRules     []KeywordRule
+	ChannelSkill     bool //conversations require the channel skill, only for sites that give agents channel skills
+	MaxConversations int
+	offers           map[string]*conversationOffer    //map[conversationID]offer
+	transfers        map[string]*conversationTransfer //map[conversationID]transfer
+	mutex            sync.Mutex
+}
+
+type conversationOffer struct {
+	conversationID string
+	agentID        string
+	declinedBy     map[string]bool
+	announcedTo    map[string]bool //agents the broadcast strategy announced the conversation to
+	timer          *time.Timer
+}
+
+func (r *ConversationRouter) Init() {
+
+	r.offers = make(map[string]*conversationOffer)
+	r.transfers = make(map[string]*conversationTransfer)
+	r.StrategyName = ROUTING_BROADCAST
+	r.OfferTimeout = DEFAULT_OFFER_TIMEOUT * time.Second
+	r.RetryInterval = DEFAULT_RETRY_INTERVAL * time.Second
+	r.SkillFallback = DEFAULT_SKILL_FALLBACK * time.Second
+	r.KeywordRules = nil
+	r.ChannelSkill = false
+	r.MaxConversations = DEFAULT_MAX_CONVERSATIONS
+
+	cfg, err := ini.Load("conf/routing_conf.ini")
+	if err != nil {
+		log.Println("Failed to read routing_conf file: ", err)
+	} else {
+		r.StrategyName = cfg.Section("routing").Key("STRATEGY").MustString(ROUTING_BROADCAST)
+		r.OfferTimeout = time.Duration(cfg.Section("routing").Key("OFFER_TIMEOUT").MustInt(DEFAULT_OFFER_TIMEOUT)) * time.Second
+		r.RetryInterval = time.Duration(cfg.Section("routing").Key("RETRY_INTERVAL").MustInt(DEFAULT_RETRY_INTERVAL)) * time.Second
+		r.SkillFallback = time.Duration(cfg.Section("routing").Key("SKILL_FALLBACK_WAIT").MustInt(DEFAULT_SKILL_FALLBACK)) * time.Second
+		r.ChannelSkill = cfg.Section("routing").Key("REQUIRE_CHANNEL_SKILL").MustBool(false)
+		r.MaxConversations = cfg.Section("routing").Key("MAX_CONVERSATIONS").MustInt(DEFAULT_MAX_CONVERSATIONS)
+
+		for _, section := range cfg.Sections() {
+			if strings.HasPrefix(section.Name(), "keyword-rule") {
+				rule := KeywordRule{Keywords: section.Key("KEYWORDS").Strings(","), Skill: section.Key("SKILL").String(), Value: section.Key("VALUE").String()}
+				r.KeywordRules = append(r.KeywordRules, rule)
+			}
+		}
+	}
+
+	switch r.StrategyName {
+	case ROUTING_ROUND_ROBIN:
+		r.Strategy = &RoundRobinStrategy{}
+	case ROUTING_LEAST_BUSY:
+		r.Strategy = LeastBusyStrategy{}
+	case ROUTING_LONGEST_IDLE:
+		r.Strategy = LongestIdleStrategy{}
+	default:
+		if r.StrategyName != ROUTING_BROADCAST {
+			log.Println("Unknown routing strategy, falling back to broadcast: ", r.StrategyName)
+		}
+		r.StrategyName = ROUTING_BROADCAST
+		r.Strategy = nil
+	}
+
+	log.Println("Conversation routing strategy: ", r.StrategyName)
+}
+
+// RouteConversation announces a new unassigned conversation, either to all agents that may take it or to one agent at a time
+func (r *ConversationRouter) RouteConversation(conversation *types.Conversation) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.offerConversation(conversation.Id)
+}
+
+// RouteWaitingConversations offers every unassigned conversation that is not currently offered to an agent
+func (r *ConversationRouter) RouteWaitingConversations() {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	conversations := Omnichannel.GetUnassignedConversations()
+	QueueManager.SortByPriority(conversations)
+
+	for _, conversation := range conversations {
+		if offer := r.offers[conversation.Id]; offer == nil || offer.agentID == "" {
+			r.offerConversation(conversation.Id)
+		}
+	}
+}
+
+// IsOfferedTo reports whether the unassigned conversation is offered to the agent. The broadcast strategy
+// offers it to every agent that may take it.
+func (r *ConversationRouter) IsOfferedTo(conversation *types.Conversation, agent *types.Agent) bool {
+
+	if conversation.State != types.Unassigned {
+		return false
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.Strategy == nil {
+		for _, candidate := range r.candidateAgents(conversation, &conversationOffer{}) {
+			if candidate.Id == agent.Id {
+				return true
+			}
+		}
+		return false
+	}
+
+	offer := r.offers[conversation.Id]
+
+	return offer != nil && offer.agentID == agent.Id
+}
+
+// AssignConversation atomically assigns an unassigned conversation to the agent, rejecting it if someone else got it first
+func (r *ConversationRouter) AssignConversation(conversationID string, agentID string) (failedMsg string) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	conversation := Omnichannel.FindActiveConversationByID(conversationID)
+	if conversation == nil || conversation.State != types.Unassigned {
+		return "Conversation is no longer available"
+	}
+
+	TcpServer.agentsMutex.RLock()
+	agent := TcpServer.findAgent(agentID)
+	atLimit := agent != nil && agent.MaxConversations > 0 && agent.Conversations >= agent.MaxConversations
+	TcpServer.agentsMutex.RUnlock()
+
+	if atLimit {
+		return "Maximum number of concurrent conversations reached"
+	}
+
+	if offer := r.offers[conversationID]; offer != nil {
+		r.cancelOffer(offer)
+		if offer.agentID != "" && offer.agentID != agentID {
+			r.sendOfferEvent(EVENT_CONVERSATION_OFFER_REVOKED, conversation, offer.agentID)
+		}
+	}
+
+	if !Omnichannel.AcceptConversation(conversationID, agentID) {
+		return "Conversation is no longer available"
+	}
+
+	TcpServer.updateAgent(agentID, func(agent *types.Agent) {
+		agent.Conversations++
+		agent.IdleSince = uint(time.Now().UnixMilli())
+	})
+
+	return ""
+}
+
+// DeclineConversation passes the offered conversation on to the next agent
+func (r *ConversationRouter) DeclineConversation(conversationID string, agentID string) bool {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	offer := r.offers[conversationID]
+	if offer == nil || offer.agentID != agentID {
+		return false
+	}
+
+	offer.timer.Stop()
+	offer.declinedBy[agentID] = true
+	offer.agentID = ""
+	r.offerConversation(conversationID)
+
+	return true
+}
+
+// ReleaseAgentOffers re-offers every conversation currently offered to the agent, e.g. after logoff
+func (r *ConversationRouter) ReleaseAgentOffers(agentID string) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for conversationID, offer := range r.offers {
+		if offer.agentID == agentID {
+			offer.timer.Stop()
+			offer.declinedBy[agentID] = true
+			offer.agentID = ""
+			r.offerConversation(conversationID)
+		}
+	}
+}
+
+func (r *ConversationRouter) offerConversation(conversationID string) {
+
+	conversation := Omnichannel.FindActiveConversationByID(conversationID)
+	if conversation == nil || conversation.State != types.Unassigned {
+		if offer := r.offers[conversationID]; offer != nil {
+			r.cancelOffer(offer)
+		}
+		return
+	}
+
+	offer := r.offers[conversationID]
+	if offer == nil {
+		offer = &conversationOffer{conversationID: conversationID, declinedBy: make(map[string]bool)}
+		r.offers[conversationID] = offer
+	} else if offer.timer != nil {
+		offer.timer.Stop()
+	}
+
+	overflowed := QueueManager.CheckOverflow(conversation)
+
+	if r.Strategy == nil {
+		r.broadcastConversation(conversation, offer, overflowed)
+		return
+	}
+
+	candidates := r.candidateAgents(conversation, offer)
+	if len(candidates) == 0 && len(offer.declinedBy) > 0 {
+		// everybody had a chance, start a new round after the retry interval
+		offer.declinedBy = make(map[string]bool)
+	}
+
+	var agent *types.Agent
+	if len(candidates) > 0 {
+		TcpServer.agentsMutex.RLock()
+		agent = r.Strategy.SelectAgent(candidates)
+		TcpServer.agentsMutex.RUnlock()
+	}
+
+	if agent == nil {
+		offer.agentID = ""
+		offer.timer = time.AfterFunc(r.RetryInterval, func() { r.retryOffer(conversationID) })
+		return
+	}
+
+	offer.agentID = agent.Id
+	offer.timer = time.AfterFunc(r.OfferTimeout, func() { r.offerExpired(conversationID, agent.Id) })
+
+	r.sendOfferEvent(EVENT_CONVERSATION_OFFERED, conversation, agent.Id)
+}
+
+// broadcastConversation announces the conversation to the agents that may take it and were not told yet, e.g. because
+// they just became available. It checks again after the retry interval, for overflows and the skill fallback.
+func (r *ConversationRouter) broadcastConversation(conversation *types.Conversation, offer *conversationOffer, overflowed bool) {
+
+	if offer.announcedTo == nil || overflowed {
+		offer.announcedTo = make(map[string]bool)
+	}
+
+	var agents []*types.Agent
+	for _, agent := range r.candidateAgents(conversation, offer) {
+		if !offer.announcedTo[agent.Id] {
+			offer.announcedTo[agent.Id] = true
+			agents = append(agents, agent)
+		}
+	}
+
+	if len(agents) > 0 {
+		Omnichannel.SendNewConversationToAgents(*conversation, agents)
+	}
+
+	offer.timer = time.AfterFunc(r.RetryInterval, func() { r.retryOffer(conversation.Id) })
+}
+
+func (r *ConversationRouter) candidateAgents(conversation *types.Conversation, offer *conversationOffer) []*types.Agent {
+
+	var agents []*types.Agent
+
+	// after waiting too long any agent may take the conversation, regardless of skills
+	waited := time.Since(time.UnixMilli(int64(conversation.Created_Timestamp)))
+	matchSkills := waited < r.SkillFallback
+
+	TcpServer.agentsMutex.RLock()
+	defer TcpServer.agentsMutex.RUnlock()
+
+	for _, agent := range TcpServer.LoggedAgents {
+		if offer.declinedBy[agent.Id] || !AgentCanTakeConversation(agent) {
+			continue
+		}
+
+		if conversation.Queue != "" && !QueueManager.IsMember(agent, conversation.Queue) {
+			continue
+		}
+
+		if matchSkills && !AgentHasSkills(agent, conversation.Requirements) {
+			continue
+		}
+
+		agents = append(agents, agent)
+	}
+
+	return agents
+}
+
+func (r *ConversationRouter) offerExpired(conversationID string, agentID string) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	offer := r.offers[conversationID]
+	if offer == nil || offer.agentID != agentID {
+		return
+	}
+
+	if conversation := Omnichannel.FindActiveConversationByID(conversationID); conversation != nil {
+		r.sendOfferEvent(EVENT_CONVERSATION_OFFER_REVOKED, conversation, agentID)
+	}
+
+	offer.declinedBy[agentID] = true
+	offer.agentID = ""
+	r.offerConversation(conversationID)
+}
+
+func (r *ConversationRouter) retryOffer(conversationID string) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if offer := r.offers[conversationID]; offer != nil && offer.agentID == "" {
+		r.offerConversation(conversationID)
+	}
+}
+
+func (r *ConversationRouter) cancelOffer(offer *conversationOffer) {
+
+	if offer.timer != nil {
+		offer.timer.Stop()
+	}
+
+	delete(r.offers, offer.conversationID)
+}
+
+func (r *ConversationRouter) sendOfferEvent(event string, conversation *types.Conversation, agentID string) {
+
+	jsonData := make(map[string]interface{})
+	jsonData["event"] = event
+	jsonData["conversationID"] = conversation.Id
+	jsonData["type"] = conversation.Type
+	jsonData["queue"] = conversation.Queue
+	jsonData["customer"] = Omnichannel.FindCustomerByID(conversation.CustomerID)
+	if event == EVENT_CONVERSATION_OFFERED {
+		jsonData["timeout"] = int(r.OfferTimeout.Seconds())
+	}
+
+	TcpServer.SendEventToAgents(jsonData, agentID)
+}
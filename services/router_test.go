@@ -0,0 +1,344 @@
+package services
+
+import (
+	"reflect"
+	"server/types"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestAgent(id string, queues ...string) *types.Agent {
+	return &types.Agent{Id: id, Name: "Agent " + id, Roles: []types.AgentRole{types.RoleAgent}, State: types.Available, Queues: queues, Socket: &fakeSocket{}}
+}
+
+func newTestConversation(id string, queue string) *types.Conversation {
+
+	now := uint(time.Now().UnixMilli())
+
+	return &types.Conversation{Id: id, Type: types.WhatsApp, State: types.Unassigned, CustomerID: "customer-" + id, Created_Timestamp: now, Queue: queue, Queued_Timestamp: now}
+}
+
+func socketOf(agent *types.Agent) *fakeSocket {
+	return agent.Socket.(*fakeSocket)
+}
+
+// setupRouting resets the routing state to the logged in agents and active conversations
+func setupRouting(t *testing.T, strategy RoutingStrategy, agents []*types.Agent, conversations ...*types.Conversation) {
+
+	// routing started in the background by an earlier test may still run
+	TcpServer.agentsMutex.Lock()
+	TcpServer.LoggedAgents = agents
+	TcpServer.agentsMutex.Unlock()
+
+	Omnichannel.conversationsMutex.Lock()
+	Omnichannel.ActiveConversations = conversations
+	Omnichannel.conversationsMutex.Unlock()
+
+	Omnichannel.Customers = nil
+	QueueManager.Queues = make(map[string]*ChatQueue)
+	testDB.reset()
+
+	Router.mutex.Lock()
+	Router.Strategy = strategy
+	Router.OfferTimeout = time.Minute
+	Router.RetryInterval = time.Minute
+	Router.SkillFallback = time.Minute
+	Router.offers = make(map[string]*conversationOffer)
+	Router.transfers = make(map[string]*conversationTransfer)
+	Router.mutex.Unlock()
+
+	t.Cleanup(func() {
+		Router.mutex.Lock()
+		defer Router.mutex.Unlock()
+
+		for _, offer := range Router.offers {
+			if offer.timer != nil {
+				offer.timer.Stop()
+			}
+		}
+		Router.offers = make(map[string]*conversationOffer)
+	})
+}
+
+// waitUntil polls the condition until it holds or the test times out
+func waitUntil(t *testing.T, description string, condition func() bool) {
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRoutingStrategiesSelectAgent(t *testing.T) {
+
+	agents := func() []*types.Agent {
+		return []*types.Agent{
+			{Id: "a", Conversations: 2, IdleSince: 100},
+			{Id: "b", Conversations: 1, IdleSince: 300},
+			{Id: "c", Conversations: 1, IdleSince: 200},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		strategy RoutingStrategy
+		selected []string
+	}{
+		{ROUTING_LEAST_BUSY, LeastBusyStrategy{}, []string{"c", "c", "c"}},
+		{ROUTING_LONGEST_IDLE, LongestIdleStrategy{}, []string{"a", "a", "a"}},
+		{ROUTING_ROUND_ROBIN, &RoundRobinStrategy{}, []string{"a", "b", "c", "a"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			candidates := agents()
+
+			var selected []string
+			for range test.selected {
+				selected = append(selected, test.strategy.SelectAgent(candidates).Id)
+			}
+
+			if !reflect.DeepEqual(selected, test.selected) {
+				t.Errorf("selected %v, want %v", selected, test.selected)
+			}
+
+			if agent := test.strategy.SelectAgent(nil); agent != nil {
+				t.Errorf("selected %s without candidates, want nobody", agent.Id)
+			}
+		})
+	}
+}
+
+func TestOfferTimeoutReoffersToNextAgent(t *testing.T) {
+
+	first, second := newTestAgent("1001"), newTestAgent("1002")
+	first.IdleSince, second.IdleSince = 100, 200
+	conversation := newTestConversation("c1", "")
+
+	setupRouting(t, LongestIdleStrategy{}, []*types.Agent{first, second}, conversation)
+	Router.OfferTimeout = 20 * time.Millisecond
+
+	Router.RouteConversation(conversation)
+
+	if offered := socketOf(first).received(EVENT_CONVERSATION_OFFERED); !reflect.DeepEqual(offered, []string{"c1"}) {
+		t.Fatalf("longest idle agent was offered %v, want [c1]", offered)
+	}
+
+	waitUntil(t, "the offer to the second agent", func() bool { return len(socketOf(second).received(EVENT_CONVERSATION_OFFERED)) > 0 })
+
+	if revoked := socketOf(first).received(EVENT_CONVERSATION_OFFER_REVOKED); !reflect.DeepEqual(revoked, []string{"c1"}) {
+		t.Errorf("expired offer revoked %v, want [c1]", revoked)
+	}
+
+	Router.mutex.Lock()
+	offered := Router.offers["c1"].agentID
+	Router.mutex.Unlock()
+
+	if offered != second.Id && offered != "" {
+		t.Errorf("conversation is offered to %q after the second agent's offer", offered)
+	}
+}
+
+func TestDeclineOffersToNextAgent(t *testing.T) {
+
+	first, second := newTestAgent("1001"), newTestAgent("1002")
+	conversation := newTestConversation("c1", "")
+
+	setupRouting(t, &RoundRobinStrategy{}, []*types.Agent{first, second}, conversation)
+
+	Router.RouteConversation(conversation)
+
+	if Router.DeclineConversation("c1", second.Id) {
+		t.Errorf("agent declined a conversation it was not offered")
+	}
+	if !Router.DeclineConversation("c1", first.Id) {
+		t.Fatalf("offered agent could not decline")
+	}
+
+	if offered := socketOf(second).received(EVENT_CONVERSATION_OFFERED); !reflect.DeepEqual(offered, []string{"c1"}) {
+		t.Errorf("next agent was offered %v, want [c1]", offered)
+	}
+}
+
+func TestAssignConversationOnlyOnce(t *testing.T) {
+
+	for i := 0; i < 20; i++ {
+
+		first, second := newTestAgent("1001"), newTestAgent("1002")
+		conversation := newTestConversation("c1", "")
+
+		setupRouting(t, &RoundRobinStrategy{}, []*types.Agent{first, second}, conversation)
+		Router.RouteConversation(conversation)
+
+		var wait sync.WaitGroup
+		start := make(chan struct{})
+		results := make(map[string]string)
+		var resultsMutex sync.Mutex
+
+		for _, agent := range []*types.Agent{first, second} {
+			wait.Add(1)
+			go func(agentID string) {
+				defer wait.Done()
+				<-start
+
+				failedMsg := Router.AssignConversation("c1", agentID)
+
+				resultsMutex.Lock()
+				results[agentID] = failedMsg
+				resultsMutex.Unlock()
+			}(agent.Id)
+		}
+
+		close(start)
+		wait.Wait()
+
+		var winners []string
+		for agentID, failedMsg := range results {
+			if failedMsg == "" {
+				winners = append(winners, agentID)
+			} else if failedMsg != "Conversation is no longer available" {
+				t.Errorf("agent %s failed with %q", agentID, failedMsg)
+			}
+		}
+
+		if len(winners) != 1 {
+			t.Fatalf("conversation assigned to %v, want exactly one agent", winners)
+		}
+
+		if conversation.State != types.Assigned || conversation.ConnectedAgent != winners[0] {
+			t.Errorf("conversation is %v with %q, want assigned to %s", conversation.State, conversation.ConnectedAgent, winners[0])
+		}
+		if first.Conversations+second.Conversations != 1 {
+			t.Errorf("agents have %d and %d conversations, want 1 in total", first.Conversations, second.Conversations)
+		}
+	}
+}
+
+func TestAssignConversationWhileFinished(t *testing.T) {
+
+	for i := 0; i < 20; i++ {
+
+		agent := newTestAgent("1001")
+		conversation := newTestConversation("c1", "")
+
+		setupRouting(t, LeastBusyStrategy{}, []*types.Agent{agent}, conversation)
+
+		var wait sync.WaitGroup
+		start := make(chan struct{})
+		var failedMsg string
+
+		wait.Add(2)
+		go func() {
+			defer wait.Done()
+			<-start
+			failedMsg = Router.AssignConversation("c1", agent.Id)
+		}()
+		go func() {
+			defer wait.Done()
+			<-start
+			Omnichannel.FinishConversation("c1")
+		}()
+
+		close(start)
+		wait.Wait()
+
+		if conversation.State != types.Finished {
+			t.Fatalf("conversation is %v, want finished", conversation.State)
+		}
+
+		// an accepted conversation was assigned before it finished, a rejected one never reached the agent
+		if failedMsg == "" && (conversation.ConnectedAgent != agent.Id || agent.Conversations != 1) {
+			t.Errorf("accepted conversation has agent %q and the agent %d conversations", conversation.ConnectedAgent, agent.Conversations)
+		}
+		if failedMsg != "" && (conversation.ConnectedAgent != "" || agent.Conversations != 0) {
+			t.Errorf("rejected with %q but conversation has agent %q and the agent %d conversations", failedMsg, conversation.ConnectedAgent, agent.Conversations)
+		}
+	}
+}
+
+func TestBroadcastRespectsQueues(t *testing.T) {
+
+	sales, support, away := newTestAgent("1001", "Sales"), newTestAgent("1002", "Support"), newTestAgent("1003", "Sales")
+	away.State = types.Away
+	conversation := newTestConversation("c1", "Sales")
+
+	setupRouting(t, nil, []*types.Agent{sales, support, away}, conversation)
+	QueueManager.Queues["Sales"] = &ChatQueue{Name: "Sales", MaxWait: time.Minute, Overflow: "Support"}
+	QueueManager.Queues["Support"] = &ChatQueue{Name: "Support"}
+
+	Router.RouteConversation(conversation)
+
+	if announced := socketOf(sales).received(types.EVENT_NEW_CONVERSATION); !reflect.DeepEqual(announced, []string{"c1"}) {
+		t.Errorf("queue member was announced %v, want [c1]", announced)
+	}
+	for _, agent := range []*types.Agent{support, away} {
+		if announced := socketOf(agent).received(types.EVENT_NEW_CONVERSATION); len(announced) != 0 {
+			t.Errorf("agent %s was announced %v, want nothing", agent.Id, announced)
+		}
+	}
+
+	// once it waited longer than MAX_WAIT, the next routing moves it to the overflow queue
+	conversation.Queued_Timestamp = uint(time.Now().Add(-2 * time.Minute).UnixMilli())
+	Router.RouteWaitingConversations()
+
+	if conversation.Queue != "Support" {
+		t.Fatalf("conversation is in %s, want the Support overflow queue", conversation.Queue)
+	}
+	if announced := socketOf(support).received(types.EVENT_NEW_CONVERSATION); !reflect.DeepEqual(announced, []string{"c1"}) {
+		t.Errorf("overflow queue member was announced %v, want [c1]", announced)
+	}
+}
+
+// TestAgentsChangeWhileRouting changes agents while conversations are routed and queues listed, for go test -race
+func TestAgentsChangeWhileRouting(t *testing.T) {
+
+	first, second := newTestAgent("1001", "Sales"), newTestAgent("1002", "Sales")
+	conversations := []*types.Conversation{newTestConversation("c1", "Sales"), newTestConversation("c2", "Sales")}
+
+	setupRouting(t, LeastBusyStrategy{}, []*types.Agent{first, second}, conversations...)
+	QueueManager.Queues["Sales"] = &ChatQueue{Name: "Sales"}
+	QueueManager.Queues["Support"] = &ChatQueue{Name: "Support"}
+
+	var wait sync.WaitGroup
+	run := func(action func(i int)) {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := 0; i < 50; i++ {
+				action(i)
+			}
+		}()
+	}
+
+	run(func(i int) { Router.RouteWaitingConversations() })
+	run(func(i int) { QueueManager.GetQueuesInfo(first.Id) })
+	run(func(i int) {
+		if i%2 == 0 {
+			TcpServer.SetAgentState(second.Id, types.Away)
+		} else {
+			TcpServer.SetAgentState(second.Id, types.Available)
+		}
+	})
+	run(func(i int) {
+		if i%2 == 0 {
+			QueueManager.JoinQueue(first.Id, "Support")
+		} else {
+			QueueManager.LeaveQueue(first.Id, "Support")
+		}
+	})
+	run(func(i int) { Router.AssignConversation(conversations[i%2].Id, first.Id) })
+
+	wait.Wait()
+
+	TcpServer.agentsMutex.RLock()
+	defer TcpServer.agentsMutex.RUnlock()
+
+	if first.Conversations != 2 {
+		t.Errorf("agent has %d conversations after accepting both, want 2", first.Conversations)
+	}
+}
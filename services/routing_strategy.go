@@ -0,0 +1,77 @@
+package services
+
+import (
+	"server/types"
+)
+
+const (
+	ROUTING_BROADCAST    = "broadcast"
+	ROUTING_ROUND_ROBIN  = "round-robin"
+	ROUTING_LEAST_BUSY   = "least-busy"
+	ROUTING_LONGEST_IDLE = "longest-idle"
+)
+
+type RoutingStrategy interface {
+	SelectAgent(agents []*types.Agent) *types.Agent
+}
+
+// RoundRobinStrategy offers to the agent that received an offer least recently
+type RoundRobinStrategy struct {
+	lastOffered map[string]uint64 //map[agentID]offerSequence
+	sequence    uint64
+}
+
+func (r *RoundRobinStrategy) SelectAgent(agents []*types.Agent) *types.Agent {
+
+	if r.lastOffered == nil {
+		r.lastOffered = make(map[string]uint64)
+	}
+
+	var selected *types.Agent
+
+	for i := range agents {
+		if selected == nil || r.lastOffered[agents[i].Id] < r.lastOffered[selected.Id] {
+			selected = agents[i]
+		}
+	}
+
+	if selected != nil {
+		r.sequence++
+		r.lastOffered[selected.Id] = r.sequence
+	}
+
+	return selected
+}
+
+// LeastBusyStrategy offers to the agent with the fewest assigned conversations
+type LeastBusyStrategy struct{}
+
+func (l LeastBusyStrategy) SelectAgent(agents []*types.Agent) *types.Agent {
+
+	var selected *types.Agent
+
+	for i := range agents {
+		if selected == nil || agents[i].Conversations < selected.Conversations ||
+			(agents[i].Conversations == selected.Conversations && agents[i].IdleSince < selected.IdleSince) {
+			selected = agents[i]
+		}
+	}
+
+	return selected
+}
+
+// LongestIdleStrategy offers to the agent whose last assignment or finish is the oldest
+type LongestIdleStrategy struct{}
+
+func (l LongestIdleStrategy) SelectAgent(agents []*types.Agent) *types.Agent {
+
+	var selected *types.Agent
+
+	for i := range agents {
+		if selected == nil || agents[i].IdleSince < selected.IdleSince {
+			selected = agents[i]
+		}
+	}
+
+	return selected
+}
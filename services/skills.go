@@ -0,0 +1,162 @@
+package services
+
+import (
+	"server/db"
+	"server/types"
+	"strings"
+	"unicode"
+)
+
+const (
+	SKILL_LANGUAGE = "language"
+	SKILL_CHANNEL  = "channel"
+	SKILL_PRODUCT  = "product"
+)
+
+var SkillTypes = []string{SKILL_LANGUAGE, SKILL_CHANNEL, SKILL_PRODUCT}
+
+// KeywordRule adds a requirement to a conversation whose first message contains one of the keywords
+type KeywordRule struct {
+	Keywords []string
+	Skill    string
+	Value    string
+}
+
+func (k KeywordRule) Matches(messageText string) bool {
+
+	text := normalizeWords(messageText)
+
+	for _, keyword := range k.Keywords {
+		if keyword := normalizeWords(keyword); keyword != "  " && strings.Contains(text, keyword) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeWords lowercases the text and pads every word with spaces so keywords only match whole words
+func normalizeWords(text string) string {
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	return " " + strings.Join(words, " ") + " "
+}
+
+func isSkillType(name string) bool {
+	for _, skill := range SkillTypes {
+		if skill == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DeriveRequirements builds the skills an agent needs for the conversation from the customer's attributes and the first message,
+// the channel is only required when requireChannel is set
+func (o *OmniChannel) DeriveRequirements(conversation *types.Conversation, firstMessage string, keywordRules []KeywordRule, requireChannel bool) {
+
+	requirements := make(map[string]string)
+	if requireChannel {
+		requirements[SKILL_CHANNEL] = types.ChannelNames[conversation.Type]
+	}
+
+	if customer := o.FindCustomerByID(conversation.CustomerID); customer != nil {
+		for name, value := range customer.Attributes {
+			if isSkillType(name) && value != "" {
+				requirements[name] = value
+			}
+		}
+	}
+
+	for _, rule := range keywordRules {
+		if _, set := requirements[rule.Skill]; !set && rule.Matches(firstMessage) {
+			requirements[rule.Skill] = rule.Value
+		}
+	}
+
+	conversation.Requirements = requirements
+}
+
+// AgentHasSkills reports whether the agent has a matching value for every required skill
+func AgentHasSkills(agent *types.Agent, requirements map[string]string) bool {
+
+	for skill, value := range requirements {
+		found := false
+		for _, agentValue := range agent.Skills[skill] {
+			if agentValue == value {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (o *OmniChannel) GetAgentSkills(agentID string) map[string][]string {
+
+	skills := make(map[string][]string)
+
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "SELECT skill, value FROM agent_skills WHERE agent_id='"+agentID+"'")
+	defer results.Close()
+
+	for results.Next() {
+		var result db.QueryResult
+		if err := results.Scan(&result.Param1, &result.Param2); err == nil {
+			skills[result.Param1.String] = append(skills[result.Param1.String], result.Param2.String)
+		}
+	}
+
+	return skills
+}
+
+func (o *OmniChannel) SetAgentSkills(agentID string, skills map[string][]string) {
+
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "DELETE FROM agent_skills WHERE agent_id='"+agentID+"'")
+	defer results.Close()
+
+	for skill, values := range skills {
+		for _, value := range values {
+			results2 := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "INSERT INTO agent_skills(agent_id, skill, value) VALUES('"+agentID+"','"+skill+"','"+value+"')")
+			defer results2.Close()
+		}
+	}
+}
+
+func (o *OmniChannel) GetCustomerAttributes(customerID string) map[string]string {
+
+	attributes := make(map[string]string)
+
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "SELECT name, value FROM customer_attributes WHERE customer_id='"+customerID+"'")
+	defer results.Close()
+
+	for results.Next() {
+		var result db.QueryResult
+		if err := results.Scan(&result.Param1, &result.Param2); err == nil {
+			attributes[result.Param1.String] = result.Param2.String
+		}
+	}
+
+	return attributes
+}
+
+func (o *OmniChannel) SetCustomerAttribute(customerID string, name string, value string) {
+
+	if customer := o.FindCustomerByID(customerID); customer != nil {
+		if customer.Attributes == nil {
+			customer.Attributes = make(map[string]string)
+		}
+		customer.Attributes[name] = value
+	}
+
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, "REPLACE INTO customer_attributes(customer_id, name, value) VALUES('"+customerID+"','"+name+"','"+value+"')")
+	defer results.Close()
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"reflect"
+	"server/types"
+	"testing"
+)
+
+func TestKeywordRuleMatches(t *testing.T) {
+
+	rule := KeywordRule{Keywords: []string{"invoice", "Credit Card", ""}, Skill: SKILL_PRODUCT, Value: "billing"}
+
+	tests := []struct {
+		text    string
+		matches bool
+	}{
+		{"Where is my invoice?", true},
+		{"INVOICE 42", true},
+		{"my credit   card was charged twice", true},
+		{"credit-card declined", true},
+		{"I need invoices", false},
+		{"reinvoice the order", false},
+		{"credit limit on my card", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if matches := rule.Matches(test.text); matches != test.matches {
+			t.Errorf("Matches(%q) = %v, want %v", test.text, matches, test.matches)
+		}
+	}
+}
+
+func TestDeriveRequirements(t *testing.T) {
+
+	rules := []KeywordRule{
+		{Keywords: []string{"invoice"}, Skill: SKILL_PRODUCT, Value: "billing"},
+		{Keywords: []string{"router", "wifi"}, Skill: SKILL_PRODUCT, Value: "internet"},
+		{Keywords: []string{"hola"}, Skill: SKILL_LANGUAGE, Value: "es"},
+	}
+
+	o := &OmniChannel{Customers: []*types.Customer{
+		{Id: "customer-1", Attributes: map[string]string{SKILL_LANGUAGE: "de", "plan": "gold"}},
+		{Id: "customer-2"},
+	}}
+
+	tests := []struct {
+		name           string
+		customerID     string
+		message        string
+		requireChannel bool
+		requirements   map[string]string
+	}{
+		{"nothing required", "customer-2", "good morning", false, map[string]string{}},
+		{"channel only", "customer-2", "good morning", true, map[string]string{SKILL_CHANNEL: types.ChannelNames[types.WhatsApp]}},
+		{"first matching rule wins", "customer-2", "Hola, my wifi invoice is wrong", true,
+			map[string]string{SKILL_CHANNEL: types.ChannelNames[types.WhatsApp], SKILL_PRODUCT: "billing", SKILL_LANGUAGE: "es"}},
+		{"customer attribute over rule", "customer-1", "hola, my router is down", false,
+			map[string]string{SKILL_PRODUCT: "internet", SKILL_LANGUAGE: "de"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			conversation := &types.Conversation{Id: "c1", Type: types.WhatsApp, CustomerID: test.customerID}
+			o.DeriveRequirements(conversation, test.message, rules, test.requireChannel)
+
+			if !reflect.DeepEqual(conversation.Requirements, test.requirements) {
+				t.Errorf("requirements = %v, want %v", conversation.Requirements, test.requirements)
+			}
+		})
+	}
+}
+
+func TestAgentHasSkills(t *testing.T) {
+
+	agent := &types.Agent{Id: "1001", Skills: map[string][]string{
+		SKILL_LANGUAGE: {"en", "de"},
+		SKILL_CHANNEL:  {types.ChannelNames[types.WhatsApp]},
+	}}
+
+	tests := []struct {
+		name         string
+		requirements map[string]string
+		hasSkills    bool
+	}{
+		{"no requirements", nil, true},
+		{"one of several values", map[string]string{SKILL_LANGUAGE: "de"}, true},
+		{"every requirement", map[string]string{SKILL_LANGUAGE: "en", SKILL_CHANNEL: types.ChannelNames[types.WhatsApp]}, true},
+		{"missing value", map[string]string{SKILL_LANGUAGE: "es"}, false},
+		{"missing one required skill", map[string]string{SKILL_LANGUAGE: "en", SKILL_PRODUCT: "billing"}, false},
+	}
+
+	for _, test := range tests {
+		if hasSkills := AgentHasSkills(agent, test.requirements); hasSkills != test.hasSkills {
+			t.Errorf("%s: AgentHasSkills(%v) = %v, want %v", test.name, test.requirements, hasSkills, test.hasSkills)
+		}
+	}
+}
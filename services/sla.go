@@ -0,0 +1,197 @@
+package services
+
+import (
+	"log"
+	"server/db"
+	"server/types"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SLA_CHECK_INTERVAL = 15 * time.Second
+
+	SLA_FIRST_RESPONSE = "first_response"
+	SLA_RESOLUTION     = "resolution"
+
+	slaWarned   = 1
+	slaBreached = 2
+)
+
+var SLA SLATracker
+
+// SLATimestamps are the points in a conversation's life the SLA targets are measured between
+type SLATimestamps struct {
+	Created       uint
+	Assigned      uint
+	FirstResponse uint
+	Finished      uint
+}
+
+// SLATracker warns agents and supervisors before a queue's first response or resolution target is missed
+// and stores the outcome of every finished conversation in the sla_outcomes table.
+// Elapsed times only count business hours of the conversation's calendar.
+type SLATracker struct {
+	notified map[string]map[string]int //map[conversationID]map[metric]level
+	mutex    sync.Mutex
+}
+
+func (t *SLATracker) Init() {
+
+	t.notified = make(map[string]map[string]int)
+
+	for _, conversation := range Omnichannel.ActiveConversations {
+		timestamps := Omnichannel.GetSLATimestamps(conversation.Id)
+		conversation.Assigned_Timestamp = timestamps.Assigned
+		conversation.FirstResponse_Timestamp = timestamps.FirstResponse
+	}
+
+	go t.run()
+}
+
+func (t *SLATracker) run() {
+	for range time.Tick(SLA_CHECK_INTERVAL) {
+		t.checkConversations(time.Now())
+	}
+}
+
+func (t *SLATracker) checkConversations(now time.Time) {
+
+	conversations := Omnichannel.snapshotActiveConversations()
+
+	for i := range conversations {
+		conversation := &conversations[i]
+
+		firstResponseTarget, resolutionTarget, warningPercent := QueueManager.GetSLATargets(conversation.Queue)
+		elapsed := Calendars.BusinessTimeBetween(conversation, time.UnixMilli(int64(conversation.Created_Timestamp)), now)
+
+		if conversation.FirstResponse_Timestamp == 0 {
+			t.check(conversation, SLA_FIRST_RESPONSE, elapsed, firstResponseTarget, warningPercent)
+		}
+		t.check(conversation, SLA_RESOLUTION, elapsed, resolutionTarget, warningPercent)
+	}
+}
+
+func (t *SLATracker) check(conversation *types.Conversation, metric string, elapsed time.Duration, target time.Duration, warningPercent int) {
+
+	if target <= 0 {
+		return
+	}
+
+	level := 0
+	if elapsed >= target {
+		level = slaBreached
+	} else if elapsed >= target*time.Duration(warningPercent)/100 {
+		level = slaWarned
+	}
+
+	t.mutex.Lock()
+	if t.notified[conversation.Id] == nil {
+		t.notified[conversation.Id] = make(map[string]int)
+	}
+	notify := level > t.notified[conversation.Id][metric]
+	if notify {
+		t.notified[conversation.Id][metric] = level
+	}
+	t.mutex.Unlock()
+
+	if !notify {
+		return
+	}
+
+	jsonData := make(map[string]interface{})
+	jsonData["event"] = EVENT_SLA_WARNING
+	if level == slaBreached {
+		jsonData["event"] = EVENT_SLA_BREACHED
+	}
+	jsonData["conversationID"] = conversation.Id
+	jsonData["queue"] = conversation.Queue
+	jsonData["metric"] = metric
+	jsonData["target"] = int(target.Seconds())
+	jsonData["elapsed"] = int(elapsed.Seconds())
+
+	if conversation.ConnectedAgent != "" {
+		TcpServer.SendEventToAgents(jsonData, conversation.ConnectedAgent)
+	}
+	for _, agent := range TcpServer.GetLoggedAgents() {
+		if agent.HasRole(types.RoleSupervisor) && agent.Id != conversation.ConnectedAgent {
+			TcpServer.SendEventToAgents(jsonData, agent.Id)
+		}
+	}
+}
+
+// RecordOutcome stores whether the finished conversation met its queue's targets
+func (t *SLATracker) RecordOutcome(conversation *types.Conversation, finished time.Time) {
+
+	t.mutex.Lock()
+	delete(t.notified, conversation.Id)
+	t.mutex.Unlock()
+
+	created := time.UnixMilli(int64(conversation.Created_Timestamp))
+	firstResponseTarget, resolutionTarget, _ := QueueManager.GetSLATargets(conversation.Queue)
+
+	firstResponseEnd := finished
+	if conversation.FirstResponse_Timestamp != 0 {
+		firstResponseEnd = time.UnixMilli(int64(conversation.FirstResponse_Timestamp))
+	}
+
+	firstResponseTime := Calendars.BusinessTimeBetween(conversation, created, firstResponseEnd)
+	resolutionTime := Calendars.BusinessTimeBetween(conversation, created, finished)
+
+	firstResponseMet := conversation.FirstResponse_Timestamp != 0 && (firstResponseTarget <= 0 || firstResponseTime <= firstResponseTarget)
+	resolutionMet := resolutionTarget <= 0 || resolutionTime <= resolutionTarget
+
+	query := "REPLACE INTO sla_outcomes(conversation_id, type, queue, agent_id, created_timestamp, assigned_timestamp, first_response_timestamp, finished_timestamp, first_response_time, resolution_time, first_response_target, resolution_target, first_response_met, resolution_met) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	if err := db.DBConnector.Execute(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, query, conversation.Id, int(conversation.Type), conversation.Queue, conversation.ConnectedAgent,
+		uint64(conversation.Created_Timestamp), uint64(conversation.Assigned_Timestamp), uint64(conversation.FirstResponse_Timestamp), finished.UnixMilli(),
+		firstResponseTime.Milliseconds(), resolutionTime.Milliseconds(), firstResponseTarget.Milliseconds(), resolutionTarget.Milliseconds(),
+		firstResponseMet, resolutionMet); err != nil {
+		log.Println("Failed to store SLA outcome for conversation ", conversation.Id, ": ", err)
+	}
+
+	log.Println("SLA outcome for conversation ", conversation.Id, ": first response met ", firstResponseMet, ", resolution met ", resolutionMet)
+}
+
+// GetSLATimestamps computes the SLA timestamps of a conversation from the conversations and messages tables, 0 means it did not happen yet
+func (o *OmniChannel) GetSLATimestamps(conversationID string) SLATimestamps {
+
+	var timestamps SLATimestamps
+
+	eventTimestamp := func(event string) string {
+		return "(SELECT COALESCE(MIN(timestamp), 0) FROM messages WHERE conversation_id=c.id AND type=" + strconv.Itoa(int(types.Event)) + " AND event='" + event + "')"
+	}
+
+	query := "SELECT c.created_timestamp, " +
+		eventTimestamp(EVENT_CONVERSATION_ACCEPTED) + ", " +
+		"(SELECT COALESCE(MIN(timestamp), 0) FROM messages WHERE conversation_id=c.id AND type=" + strconv.Itoa(int(types.Text)) + " AND sent_from_agent=true), " +
+		eventTimestamp(EVENT_CONVERSATION_FINISHED) +
+		" FROM conversations c WHERE c.id='" + conversationID + "'"
+
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, query)
+	defer results.Close()
+
+	if results.Next() {
+		if err := results.Scan(&timestamps.Created, &timestamps.Assigned, &timestamps.FirstResponse, &timestamps.Finished); err != nil {
+			log.Println("Failed to read SLA timestamps of conversation ", conversationID, ": ", err)
+		}
+	}
+
+	return timestamps
+}
+
+// recordFirstResponse remembers when the first agent reply of an active conversation was sent
+func (o *OmniChannel) recordFirstResponse(conversationID string, message types.Message) {
+
+	if message.Type != types.Text || !message.SentFromAgent {
+		return
+	}
+
+	o.conversationsMutex.Lock()
+	defer o.conversationsMutex.Unlock()
+
+	if conversation := o.activeConversation(conversationID); conversation != nil && conversation.FirstResponse_Timestamp == 0 {
+		conversation.FirstResponse_Timestamp = message.Timestamp
+	}
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"server/types"
+	"testing"
+	"time"
+)
+
+// metricsOf returns the metric of every event of the type the agent received
+func metricsOf(agent *types.Agent, eventType string) []string {
+
+	var metrics []string
+	for _, event := range socketOf(agent).eventsOf(eventType) {
+		metrics = append(metrics, event["metric"].(string))
+	}
+
+	return metrics
+}
+
+func TestSLAWarningThenBreach(t *testing.T) {
+
+	agent, supervisor := newTestAgent("1001", "Sales"), newTestAgent("2001")
+	supervisor.Roles = append(supervisor.Roles, types.RoleSupervisor)
+
+	created := time.Now().Add(-9 * time.Minute).Truncate(time.Millisecond)
+	conversation := newTestConversation("c1", "Sales")
+	conversation.State, conversation.ConnectedAgent = types.Assigned, agent.Id
+	conversation.Created_Timestamp = uint(created.UnixMilli())
+
+	setupRouting(t, LeastBusyStrategy{}, []*types.Agent{agent, supervisor}, conversation)
+	QueueManager.Queues["Sales"] = &ChatQueue{Name: "Sales", SLAFirstResponse: 10 * time.Minute, SLAResolution: time.Hour, SLAWarningPercentage: 80}
+
+	SLA.mutex.Lock()
+	SLA.notified = make(map[string]map[string]int)
+	SLA.mutex.Unlock()
+
+	// 9 of 10 minutes is past the 80% warning, checking again does not repeat it
+	SLA.checkConversations(created.Add(9 * time.Minute))
+	SLA.checkConversations(created.Add(9*time.Minute + 30*time.Second))
+
+	for _, notified := range []*types.Agent{agent, supervisor} {
+		if warnings := metricsOf(notified, EVENT_SLA_WARNING); !reflect.DeepEqual(warnings, []string{SLA_FIRST_RESPONSE}) {
+			t.Errorf("agent %s received warnings %v, want [%s]", notified.Id, warnings, SLA_FIRST_RESPONSE)
+		}
+		if breaches := metricsOf(notified, EVENT_SLA_BREACHED); len(breaches) != 0 {
+			t.Errorf("agent %s received breaches %v before the target", notified.Id, breaches)
+		}
+	}
+
+	SLA.checkConversations(created.Add(11 * time.Minute))
+
+	for _, notified := range []*types.Agent{agent, supervisor} {
+		if breaches := metricsOf(notified, EVENT_SLA_BREACHED); !reflect.DeepEqual(breaches, []string{SLA_FIRST_RESPONSE}) {
+			t.Errorf("agent %s received breaches %v, want [%s]", notified.Id, breaches, SLA_FIRST_RESPONSE)
+		}
+	}
+
+	// the first response came late, the conversation was resolved in time
+	conversation.Assigned_Timestamp = uint(created.Add(time.Minute).UnixMilli())
+	conversation.FirstResponse_Timestamp = uint(created.Add(12 * time.Minute).UnixMilli())
+	finished := created.Add(30 * time.Minute)
+
+	SLA.RecordOutcome(conversation, finished)
+
+	outcomes := testDB.argsOf("INTO sla_outcomes")
+	if len(outcomes) != 1 {
+		t.Fatalf("stored %d SLA outcomes, want 1", len(outcomes))
+	}
+
+	want := []driver.Value{"c1", int64(types.WhatsApp), "Sales", agent.Id,
+		int64(conversation.Created_Timestamp), int64(conversation.Assigned_Timestamp), int64(conversation.FirstResponse_Timestamp), finished.UnixMilli(),
+		(12 * time.Minute).Milliseconds(), (30 * time.Minute).Milliseconds(), (10 * time.Minute).Milliseconds(), time.Hour.Milliseconds(),
+		false, true}
+	if !reflect.DeepEqual(outcomes[0], want) {
+		t.Errorf("SLA outcome stored as %v, want %v", outcomes[0], want)
+	}
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"net"
+	"server/types"
+	"sync"
+)
+
+var Monitor ConversationMonitor
+
+// ConversationMonitor forwards the live message stream of a conversation to the supervisors watching it
+type ConversationMonitor struct {
+	subscribers map[string][]string //map[conversationID]supervisorIDs
+	mutex       sync.Mutex
+}
+
+func (m *ConversationMonitor) Subscribe(conversationID string, supervisorID string) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.subscribers == nil {
+		m.subscribers = make(map[string][]string)
+	}
+
+	for _, id := range m.subscribers[conversationID] {
+		if id == supervisorID {
+			return
+		}
+	}
+
+	m.subscribers[conversationID] = append(m.subscribers[conversationID], supervisorID)
+}
+
+func (m *ConversationMonitor) Unsubscribe(conversationID string, supervisorID string) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	subscribers := m.subscribers[conversationID]
+	for i := range subscribers {
+		if subscribers[i] == supervisorID {
+			m.subscribers[conversationID] = append(subscribers[:i], subscribers[i+1:]...)
+			break
+		}
+	}
+
+	if len(m.subscribers[conversationID]) == 0 {
+		delete(m.subscribers, conversationID)
+	}
+}
+
+func (m *ConversationMonitor) UnsubscribeAll(supervisorID string) {
+
+	m.mutex.Lock()
+	var conversationIDs []string
+	for conversationID := range m.subscribers {
+		conversationIDs = append(conversationIDs, conversationID)
+	}
+	m.mutex.Unlock()
+
+	for _, conversationID := range conversationIDs {
+		m.Unsubscribe(conversationID, supervisorID)
+	}
+}
+
+func (m *ConversationMonitor) GetSubscribers(conversationID string) []string {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]string(nil), m.subscribers[conversationID]...)
+}
+
+// Publish sends the event to every supervisor monitoring the conversation
+func (m *ConversationMonitor) Publish(conversationID string, jsonData map[string]interface{}) {
+	for _, supervisorID := range m.GetSubscribers(conversationID) {
+		TcpServer.SendEventToAgents(jsonData, supervisorID)
+	}
+}
+
+func (m *ConversationMonitor) PublishMessage(conversationID string, message types.Message) {
+
+	jsonData := make(map[string]interface{})
+	jsonData["event"] = EVENT_MONITORED_MESSAGE
+	jsonData["conversationID"] = conversationID
+	jsonData["message"] = message
+
+	m.Publish(conversationID, jsonData)
+}
+
+func (s *TCPServer) GetAgentBySocket(con net.Conn) *types.Agent {
+
+	s.agentsMutex.RLock()
+	defer s.agentsMutex.RUnlock()
+
+	for i := range s.LoggedAgents {
+		if s.LoggedAgents[i].Socket == con {
+			return s.LoggedAgents[i]
+		}
+	}
+
+	return nil
+}
+
+// GetActiveConversationsInfo describes every active conversation with its state, queue and connected agent
+func (s *TCPServer) GetActiveConversationsInfo() []map[string]interface{} {
+
+	var conversationsInfo []map[string]interface{}
+
+	Omnichannel.conversationsMutex.RLock()
+	conversations := append([]*types.Conversation(nil), Omnichannel.ActiveConversations...)
+	Omnichannel.conversationsMutex.RUnlock()
+
+	for _, conversation := range conversations {
+		conversationInfo := make(map[string]interface{})
+		conversationInfo["conversationID"] = conversation.Id
+		conversationInfo["type"] = conversation.Type
+		conversationInfo["state"] = conversation.State
+		conversationInfo["queue"] = conversation.Queue
+		conversationInfo["connectedAgent"] = conversation.ConnectedAgent
+		conversationInfo["created_timestamp"] = conversation.Created_Timestamp
+		conversationInfo["customer"] = Omnichannel.FindCustomerByID(conversation.CustomerID)
+		conversationInfo["monitored_by"] = Monitor.GetSubscribers(conversation.Id)
+
+		if agent := s.GetAgent(conversation.ConnectedAgent); agent != nil {
+			conversationInfo["agentName"] = agent.Name
+		}
+
+		conversationsInfo = append(conversationsInfo, conversationInfo)
+	}
+
+	return conversationsInfo
+}
+
+// SendWhisper delivers a private note from a supervisor to the agent handling the conversation, the customer never receives it
+func (s *TCPServer) SendWhisper(conversationID string, supervisorID string, text string) bool {
+
+	conversation := Omnichannel.FindActiveConversationByID(conversationID)
+	if conversation == nil || conversation.ConnectedAgent == "" {
+		return false
+	}
+
+	jsonData := make(map[string]interface{})
+	jsonData["event"] = EVENT_WHISPER
+	jsonData["conversationID"] = conversationID
+	jsonData["supervisorID"] = supervisorID
+	jsonData["text"] = text
+
+	s.SendEventToAgents(jsonData, conversation.ConnectedAgent)
+	Monitor.Publish(conversationID, jsonData)
+
+	return true
+}
+
+// ReassignConversation lets a supervisor move a conversation to another agent without the target having to accept it,
+// a target that is away, offline or at its limit only gets it when force is set
+func (r *ConversationRouter) ReassignConversation(conversationID string, supervisorID string, toAgentID string, force bool) (failedMsg string) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	conversation := Omnichannel.FindActiveConversationByID(conversationID)
+	if conversation == nil {
+		return "Conversation is no longer available"
+	}
+
+	toAgent := TcpServer.GetAgent(toAgentID)
+	if toAgent == nil {
+		return "Target agent is not logged in"
+	}
+
+	if conversation.ConnectedAgent == toAgentID {
+		return ""
+	}
+
+	TcpServer.agentsMutex.RLock()
+	absent := toAgent.State == types.Away || toAgent.State == types.Offline
+	atLimit := toAgent.MaxConversations > 0 && toAgent.Conversations >= toAgent.MaxConversations
+	TcpServer.agentsMutex.RUnlock()
+
+	if absent && !force {
+		return "Target agent is not available"
+	}
+	if atLimit && !force {
+		return "Maximum number of concurrent conversations reached"
+	}
+
+	if offer := r.offers[conversationID]; offer != nil {
+		r.cancelOffer(offer)
+		if offer.agentID != "" {
+			r.sendOfferEvent(EVENT_CONVERSATION_OFFER_REVOKED, conversation, offer.agentID)
+		}
+	}
+
+	if transfer := r.transfers[conversationID]; transfer != nil {
+		transfer.timer.Stop()
+		delete(r.transfers, conversationID)
+	}
+
+	fromAgentID := conversation.ConnectedAgent
+
+	Omnichannel.TransferConversation(conversation, toAgentID, conversation.Queue, supervisorID+": "+fromAgentID+" -> "+toAgentID)
+
+	if fromAgentID != "" {
+		releaseAgentConversation(fromAgentID)
+	}
+	TcpServer.updateAgent(toAgentID, func(agent *types.Agent) { agent.Conversations++ })
+
+	jsonData := make(map[string]interface{})
+	jsonData["event"] = EVENT_CONVERSATION_REASSIGNED
+	jsonData["conversationID"] = conversationID
+	jsonData["fromAgentID"] = fromAgentID
+	jsonData["toAgentID"] = toAgentID
+	jsonData["supervisorID"] = supervisorID
+
+	if fromAgentID != "" {
+		TcpServer.SendEventToAgents(jsonData, fromAgentID)
+	}
+	TcpServer.SendEventToAgents(jsonData, toAgentID)
+	TcpServer.SendEventToAgents(jsonData, supervisorID)
+
+	return ""
+}
+
+func (s *TCPServer) handleSupervisorRequest(con net.Conn, action string, parsedData map[string]interface{}) {
+
+	supervisor := s.GetAgentBySocket(con)
+	if supervisor == nil {
+		parsedData["success"] = 0
+		parsedData["failed_message"] = "Agent is not logged in"
+		return
+	}
+
+	failedMsg := ""
+
+	if action == CMD_GET_ACTIVE_CONVERSATIONS {
+
+		parsedData["conversations"] = s.GetActiveConversationsInfo()
+
+	} else if action == CMD_MONITOR_CONVERSATION {
+
+		conversationId := parsedData["conversationID"].(string)
+
+		if Omnichannel.FindActiveConversationByID(conversationId) != nil {
+			Monitor.Subscribe(conversationId, supervisor.Id)
+			parsedData["messages"] = Omnichannel.GetMessages(conversationId)
+		} else {
+			failedMsg = "Conversation is no longer available"
+		}
+
+	} else if action == CMD_STOP_MONITORING {
+
+		conversationId := parsedData["conversationID"].(string)
+		Monitor.Unsubscribe(conversationId, supervisor.Id)
+
+	} else if action == CMD_WHISPER {
+
+		conversationId := parsedData["conversationID"].(string)
+		text := parsedData["text"].(string)
+
+		if !s.SendWhisper(conversationId, supervisor.Id, text) {
+			failedMsg = "Conversation has no connected agent"
+		}
+
+	} else if action == CMD_REASSIGN_CONVERSATION {
+
+		conversationId := parsedData["conversationID"].(string)
+		targetAgentId := parsedData["targetAgentID"].(string)
+		force, _ := parsedData["force"].(bool)
+
+		failedMsg = Router.ReassignConversation(conversationId, supervisor.Id, targetAgentId, force)
+
+	} else if action == CMD_FORCE_CLOSE_CONVERSATION {
+
+		conversationId := parsedData["conversationID"].(string)
+
+		if !s.CloseConversation(conversationId) {
+			failedMsg = "Conversation is no longer available"
+		}
+	}
+
+	if failedMsg == "" {
+		parsedData["success"] = 1
+	} else {
+		parsedData["success"] = 0
+		parsedData["failed_message"] = failedMsg
+	}
+}
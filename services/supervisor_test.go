@@ -0,0 +1,141 @@
+package services
+
+import (
+	"reflect"
+	"server/types"
+	"testing"
+)
+
+// setupSupervision gives the agent an assigned conversation and logs in a second agent and a supervisor
+func setupSupervision(t *testing.T) (agent *types.Agent, target *types.Agent, supervisor *types.Agent, conversation *types.Conversation) {
+
+	agent, target, supervisor = newTestAgent("1001"), newTestAgent("1002"), newTestAgent("2001")
+	supervisor.Roles = append(supervisor.Roles, types.RoleSupervisor)
+	agent.Conversations = 1
+
+	conversation = newTestConversation("c1", "")
+	conversation.State, conversation.ConnectedAgent = types.Assigned, agent.Id
+
+	setupRouting(t, LeastBusyStrategy{}, []*types.Agent{agent, target, supervisor}, conversation)
+
+	return agent, target, supervisor, conversation
+}
+
+func TestReassignConversation(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		state     types.AgentState
+		limit     int
+		force     bool
+		failedMsg string
+	}{
+		{"available target", types.Available, 0, false, ""},
+		{"busy target below its limit", types.Busy, 2, false, ""},
+		{"away target", types.Away, 0, false, "Target agent is not available"},
+		{"offline target", types.Offline, 0, false, "Target agent is not available"},
+		{"target at its limit", types.Available, 1, false, "Maximum number of concurrent conversations reached"},
+		{"forced to away target", types.Away, 0, true, ""},
+		{"forced over the limit", types.Available, 1, true, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			agent, target, supervisor, conversation := setupSupervision(t)
+			target.State, target.MaxConversations, target.Conversations = test.state, test.limit, 1
+
+			if failedMsg := Router.ReassignConversation("c1", supervisor.Id, target.Id, test.force); failedMsg != test.failedMsg {
+				t.Fatalf("ReassignConversation() = %q, want %q", failedMsg, test.failedMsg)
+			}
+
+			owner, counts := agent, []int{1, 1}
+			if test.failedMsg == "" {
+				owner, counts = target, []int{0, 2}
+			}
+
+			if conversation.ConnectedAgent != owner.Id {
+				t.Errorf("conversation is connected to %q, want %s", conversation.ConnectedAgent, owner.Id)
+			}
+			if agent.Conversations != counts[0] || target.Conversations != counts[1] {
+				t.Errorf("agents have %d and %d conversations, want %v", agent.Conversations, target.Conversations, counts)
+			}
+
+			var reassigned []string
+			if test.failedMsg == "" {
+				reassigned = []string{"c1"}
+			}
+			for _, notified := range []*types.Agent{agent, target, supervisor} {
+				if events := socketOf(notified).received(EVENT_CONVERSATION_REASSIGNED); !reflect.DeepEqual(events, reassigned) {
+					t.Errorf("agent %s received reassign events %v, want %v", notified.Id, events, reassigned)
+				}
+			}
+		})
+	}
+}
+
+func TestMonitorConversation(t *testing.T) {
+
+	_, _, supervisor, _ := setupSupervision(t)
+	t.Cleanup(func() { Monitor.UnsubscribeAll(supervisor.Id) })
+
+	request := func(action string, conversationID string) map[string]interface{} {
+		parsedData := map[string]interface{}{"action": action, "conversationID": conversationID}
+		TcpServer.handleSupervisorRequest(supervisor.Socket, action, parsedData)
+		return parsedData
+	}
+	message := types.Message{Type: types.Text, Text: "Hello", Status: types.Sent}
+
+	if reply := request(CMD_MONITOR_CONVERSATION, "c1"); reply["success"] != 1 {
+		t.Fatalf("monitor reply = %v, want success", reply)
+	}
+	if reply := request(CMD_MONITOR_CONVERSATION, "unknown"); reply["success"] != 0 {
+		t.Errorf("monitoring an unknown conversation replied %v, want a failure", reply)
+	}
+	if subscribers := Monitor.GetSubscribers("c1"); !reflect.DeepEqual(subscribers, []string{supervisor.Id}) {
+		t.Errorf("subscribers = %v, want [%s]", subscribers, supervisor.Id)
+	}
+
+	Omnichannel.AddNewMessage("c1", message)
+
+	if reply := request(CMD_STOP_MONITORING, "c1"); reply["success"] != 1 {
+		t.Fatalf("stop monitoring reply = %v, want success", reply)
+	}
+	if subscribers := Monitor.GetSubscribers("c1"); len(subscribers) != 0 {
+		t.Errorf("subscribers after stop = %v, want none", subscribers)
+	}
+
+	Omnichannel.AddNewMessage("c1", message)
+
+	if monitored := socketOf(supervisor).received(EVENT_MONITORED_MESSAGE); !reflect.DeepEqual(monitored, []string{"c1"}) {
+		t.Errorf("supervisor received monitored messages %v, want only the one sent while monitoring", monitored)
+	}
+}
+
+func TestForceCloseConversation(t *testing.T) {
+
+	agent, _, supervisor, _ := setupSupervision(t)
+
+	request := func() map[string]interface{} {
+		parsedData := map[string]interface{}{"action": CMD_FORCE_CLOSE_CONVERSATION, "conversationID": "c1"}
+		TcpServer.handleSupervisorRequest(supervisor.Socket, CMD_FORCE_CLOSE_CONVERSATION, parsedData)
+		return parsedData
+	}
+
+	if reply := request(); reply["success"] != 1 {
+		t.Fatalf("force close reply = %v, want success", reply)
+	}
+	if Omnichannel.FindActiveConversationByID("c1") != nil {
+		t.Errorf("conversation is still active after being closed")
+	}
+	if agent.Conversations != 0 {
+		t.Errorf("agent has %d conversations after the close, want 0", agent.Conversations)
+	}
+	if finished := socketOf(agent).received(EVENT_CONVERSATION_FINISHED); !reflect.DeepEqual(finished, []string{"c1"}) {
+		t.Errorf("agent received finished events %v, want [c1]", finished)
+	}
+
+	if reply := request(); reply["success"] != 0 || reply["failed_message"] != "Conversation is no longer available" {
+		t.Errorf("closing twice replied %v, want a failure", reply)
+	}
+}
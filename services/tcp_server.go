@@ -6,29 +6,97 @@ import (
 	"log"
 	"net"
 	"server/auths"
+	"server/metrics"
 	"server/types"
+	"sync"
+	"time"
+
+	"github.com/go-ini/ini"
 )
 
 const (
 	CONNECTION_TYPE = "tcp"
 	TCP_HOST        = "localhost"
 	TCP_PORT        = "8010"
+
+	AUTHENTICATOR_ASTERISK = "asterisk"
+	AUTHENTICATOR_LOCAL    = "local"
+	AUTHENTICATOR_LDAP     = "ldap"
 )
 
 const (
 	CMD_AGENT_LOGIN  = "cmd_agent_login"
 	CMD_AGENT_LOGOFF = "cmd_agent_logoff"
 
-	CMD_ACCEPT_CONVERSATION = "cmd_accept_conversation"
-	CMD_FINISH_CONVERSATION = "cmd_finish_conversation"
+	CMD_SET_AGENT_STATE       = "cmd_set_agent_state"
+	CMD_SET_MAX_CONVERSATIONS = "cmd_set_max_conversations"
+
+	CMD_ACCEPT_CONVERSATION  = "cmd_accept_conversation"
+	CMD_DECLINE_CONVERSATION = "cmd_decline_conversation"
+	CMD_FINISH_CONVERSATION  = "cmd_finish_conversation"
+
+	CMD_TRANSFER_CONVERSATION = "cmd_transfer_conversation"
+	CMD_ACCEPT_TRANSFER       = "cmd_accept_transfer"
+	CMD_DECLINE_TRANSFER      = "cmd_decline_transfer"
 
 	CMD_GET_MESSAGES         = "cmd_get_messages"
 	CMD_GET_CUSTOMER_HISTORY = "cmd_get_customer_history"
 	CMD_SEND_MESSAGE         = "cmd_send_message"
-
-	EVENT_CONVERSATION_STARTED  = "event_conversation_started"
-	EVENT_CONVERSATION_ACCEPTED = "event_conversation_accepted"
-	EVENT_CONVERSATION_FINISHED = "event_conversation_finished"
+	CMD_ADD_NOTE             = "cmd_add_note"
+
+	CMD_GET_CANNED_RESPONSES   = "cmd_get_canned_responses"
+	CMD_FIND_CANNED_RESPONSE   = "cmd_find_canned_response"
+	CMD_ADD_CANNED_RESPONSE    = "cmd_add_canned_response"
+	CMD_UPDATE_CANNED_RESPONSE = "cmd_update_canned_response"
+	CMD_DELETE_CANNED_RESPONSE = "cmd_delete_canned_response"
+
+	CMD_GET_ACTIVE_CONVERSATIONS = "cmd_get_active_conversations"
+	CMD_MONITOR_CONVERSATION     = "cmd_monitor_conversation"
+	CMD_STOP_MONITORING          = "cmd_stop_monitoring"
+	CMD_WHISPER                  = "cmd_whisper"
+	CMD_REASSIGN_CONVERSATION    = "cmd_reassign_conversation"
+	CMD_FORCE_CLOSE_CONVERSATION = "cmd_force_close_conversation"
+
+	CMD_SET_AGENT_SKILLS       = "cmd_set_agent_skills"
+	CMD_SET_CUSTOMER_ATTRIBUTE = "cmd_set_customer_attribute"
+
+	CMD_GET_QUEUES  = "cmd_get_queues"
+	CMD_JOIN_QUEUE  = "cmd_join_queue"
+	CMD_LEAVE_QUEUE = "cmd_leave_queue"
+
+	CMD_CREATE_AGENT         = "cmd_create_agent"
+	CMD_DISABLE_AGENT        = "cmd_disable_agent"
+	CMD_ENABLE_AGENT         = "cmd_enable_agent"
+	CMD_RESET_AGENT_PASSWORD = "cmd_reset_agent_password"
+	CMD_SET_AGENT_ROLES      = "cmd_set_agent_roles"
+	CMD_GET_AUDIT_LOG        = "cmd_get_audit_log"
+
+	CMD_CALL_CUSTOMER = "cmd_call_customer"
+
+	EVENT_CONVERSATION_STARTED       = "event_conversation_started"
+	EVENT_CONVERSATION_OFFERED       = "event_conversation_offered"
+	EVENT_CONVERSATION_OFFER_REVOKED = "event_conversation_offer_revoked"
+	EVENT_CONVERSATION_ACCEPTED      = "event_conversation_accepted"
+	EVENT_CONVERSATION_FINISHED      = "event_conversation_finished"
+	EVENT_CONVERSATION_TRANSFERRED   = "event_conversation_transferred"
+	EVENT_CONVERSATION_REASSIGNED    = "event_conversation_reassigned"
+	EVENT_CONVERSATION_AUTO_CLOSED   = "event_conversation_auto_closed"
+	EVENT_CUSTOMER_INACTIVE          = "event_customer_inactive"
+
+	EVENT_TRANSFER_REQUESTED = "event_transfer_requested"
+	EVENT_TRANSFER_DECLINED  = "event_transfer_declined"
+
+	EVENT_AGENT_STATE_CHANGED       = "event_agent_state_changed"
+	EVENT_AGENT_PHONE_STATE_CHANGED = "event_agent_phone_state_changed"
+
+	EVENT_MONITORED_MESSAGE = "event_monitored_message"
+	EVENT_SLA_WARNING       = "event_sla_warning"
+	EVENT_SLA_BREACHED      = "event_sla_breached"
+	EVENT_WHISPER           = "event_whisper"
+
+	EVENT_CALL_STARTED  = "event_call_started"
+	EVENT_CALL_PROGRESS = "event_call_progress"
+	EVENT_CALL_ENDED    = "event_call_ended"
 )
 
 var TcpServer TCPServer
@@ -36,12 +104,17 @@ var TcpServer TCPServer
 type TCPServer struct {
 	Listener           net.Listener
 	LoggedAgents       []*types.Agent
+	agentsMutex        sync.RWMutex //guards LoggedAgents and the agents' State, PhoneState, Conversations, MaxConversations, IdleSince, Queues and Skills
 	loginAuthenticator auths.LoginAuthenticator
+	roles              map[string][]types.AgentRole //map[agentID]configured roles
 }
 
 func (server *TCPServer) Start() {
 
 	server.InitializeLoginAuthenticator()
+	server.LoadRoles()
+	Permissions.Init()
+	Throttle.Init()
 
 	var err error
 	server.Listener, err = net.Listen(CONNECTION_TYPE, TCP_HOST+":"+TCP_PORT)
@@ -68,12 +141,18 @@ func (server *TCPServer) Stop() {
 
 func (s *TCPServer) handleClientRequest(con net.Conn) {
 
+	metrics.TCPClients.Inc()
+	defer metrics.TCPClients.Dec()
 	defer con.Close()
 
 	for {
 		data, err := bufio.NewReader(con).ReadString('\n')
 		if err != nil {
 			log.Println(err)
+			if agent := s.GetAgentBySocket(con); agent != nil {
+				Audit.Record(AUDIT_CONNECTION_LOST, agent.Id, "", remoteIP(con), err.Error())
+				s.ConnectionLost(agent)
+			}
 			return
 		}
 
@@ -84,20 +163,67 @@ func (s *TCPServer) handleClientRequest(con net.Conn) {
 
 		action := parsedData["action"].(string)
 
-		if action == CMD_AGENT_LOGIN {
+		if failedMsg := s.Authorize(con, action, parsedData); failedMsg != "" {
+
+			parsedData["success"] = 0
+			parsedData["failed_message"] = failedMsg
+
+		} else if action == CMD_AGENT_LOGIN {
 
 			username := parsedData["username"].(string)
 			password := parsedData["password"].(string)
+			ip := remoteIP(con)
+			now := time.Now()
+
+			var agent *types.Agent
+			var roles []types.AgentRole
 
-			agent, failedMsg := s.loginAuthenticator.Login(username, password)
+			failedMsg := Throttle.Check(username, ip, now)
+			lockedOut := failedMsg != ""
+			if lockedOut {
+				Audit.Record(AUDIT_LOGIN_LOCKED, "", username, ip, failedMsg)
+			} else {
+				agent, roles, failedMsg = s.loginAuthenticator.Login(username, password)
+			}
 
 			if agent != nil && failedMsg == "" {
+				Throttle.Succeeded(username)
+				Audit.Record(AUDIT_LOGIN, agent.Id, username, ip, agent.AuthBackend)
+
 				agent.Socket = con
+				agent.Roles = s.GetRoles(agent.Id, roles)
+				agent.IdleSince = uint(time.Now().UnixMilli())
+				agent.Skills = Omnichannel.GetAgentSkills(agent.Id)
+				agent.Queues = QueueManager.GetAgentQueues(agent.Id)
+				agent.MaxConversations = Omnichannel.GetAgentMaxConversations(agent.Id)
+				agent.State = types.Available
+
+				conversations := Omnichannel.GetAgentActiveConversations(agent.Id)
+				agent.Conversations = len(conversations)
+
+				s.agentsMutex.Lock()
 				s.LoggedAgents = append(s.LoggedAgents, agent)
-				parsedData["agent"] = agent
-				parsedData["conversations"] = Omnichannel.GetAgentActiveConversations(agent.Id)
+				loggedAgent := *agent
+				s.agentsMutex.Unlock()
+
+				if provider, ok := s.loginAuthenticator.(auths.PhoneStateProvider); ok {
+					PhonePresence.AgentLoggedIn(agent, provider.GetPhoneState(agent.Id))
+				}
+
+				parsedData["agent"] = loggedAgent
+				parsedData["conversations"] = conversations
+				parsedData["queues"] = QueueManager.GetQueuesInfo(agent.Id)
 				parsedData["success"] = 1
+
+				go Router.RouteWaitingConversations()
 			} else {
+				if !lockedOut {
+					details := failedMsg
+					if Throttle.Failed(username, ip, now) {
+						details += ", locked out"
+					}
+					Audit.Record(AUDIT_LOGIN_FAILED, "", username, ip, details)
+				}
 				parsedData["login_failed_message"] = failedMsg
 				parsedData["success"] = 0
 			}
@@ -105,53 +231,111 @@ func (s *TCPServer) handleClientRequest(con net.Conn) {
 		} else if action == CMD_AGENT_LOGOFF {
 
 			agentId := parsedData["id"].(string)
-			success := s.loginAuthenticator.Logout(agentId)
+			success := s.LogoffAgent(agentId)
 
 			if success {
-				for i := range s.LoggedAgents {
-					if s.LoggedAgents[i].Id == agentId {
-						s.LoggedAgents = append(s.LoggedAgents[:i], s.LoggedAgents[i+1:]...)
-					}
+				if agent := s.GetAgentBySocket(con); agent != nil && agent.Id != agentId {
+					Audit.Record(AUDIT_FORCED_LOGOUT, agentId, "", remoteIP(con), "logged off by "+agent.Id)
+				} else {
+					Audit.Record(AUDIT_LOGOUT, agentId, "", remoteIP(con), "")
 				}
 			}
-
 			parsedData["success"] = success
 
+		} else if action == CMD_SET_AGENT_STATE {
+
+			agentId := parsedData["agentID"].(string)
+			state := types.AgentState(parsedData["state"].(float64))
+
+			PhonePresence.Forget(agentId)
+			parsedData["success"] = s.SetAgentState(agentId, state)
+
+		} else if action == CMD_SET_MAX_CONVERSATIONS {
+
+			agentId := parsedData["agentID"].(string)
+			maxConversations := int(parsedData["max_conversations"].(float64))
+
+			Omnichannel.SetAgentMaxConversations(agentId, maxConversations)
+
+			s.updateAgent(agentId, func(agent *types.Agent) { agent.MaxConversations = maxConversations })
+
+			parsedData["success"] = 1
+			go Router.RouteWaitingConversations()
+
 		} else if action == CMD_ACCEPT_CONVERSATION {
 
 			conversationId := parsedData["conversationID"].(string)
 			agentId := parsedData["agentID"].(string)
 
-			Omnichannel.AcceptConversation(conversationId, agentId)
+			if failedMsg := Router.AssignConversation(conversationId, agentId); failedMsg == "" {
+				parsedData["success"] = 1
 
-			if agent := s.GetAgent(agentId); agent != nil {
-				agent.Conversations++
+				jsonData := make(map[string]interface{})
+				jsonData["event"] = EVENT_CONVERSATION_ACCEPTED
+				jsonData["conversationID"] = conversationId
+				jsonData["agentID"] = agentId
+
+				s.SendEventToAgents(jsonData, "")
+			} else {
+				parsedData["success"] = 0
+				parsedData["failed_message"] = failedMsg
 			}
 
-			jsonData := make(map[string]interface{})
-			jsonData["event"] = EVENT_CONVERSATION_ACCEPTED
-			jsonData["conversationID"] = conversationId
-			jsonData["agentID"] = agentId
+		} else if action == CMD_DECLINE_CONVERSATION {
 
-			s.SendEventToAgents(jsonData, "")
+			conversationId := parsedData["conversationID"].(string)
+			agentId := parsedData["agentID"].(string)
+
+			parsedData["success"] = Router.DeclineConversation(conversationId, agentId)
 
 		} else if action == CMD_FINISH_CONVERSATION {
 
+			conversationId := parsedData["conversationID"].(string)
+
+			if failedMsg := s.FinishConversation(conversationId, s.GetAgentBySocket(con)); failedMsg == "" {
+				parsedData["success"] = 1
+			} else {
+				parsedData["success"] = 0
+				parsedData["failed_message"] = failedMsg
+			}
+
+		} else if action == CMD_TRANSFER_CONVERSATION {
+
 			conversationId := parsedData["conversationID"].(string)
 			agentId := parsedData["agentID"].(string)
 
-			if agent := s.GetAgent(agentId); agent != nil {
-				if agent.Conversations > 0 {
-					agent.Conversations--
-				}
+			var failedMsg string
+			if targetAgentId, ok := parsedData["targetAgentID"].(string); ok && targetAgentId != "" {
+				failedMsg = Router.RequestTransfer(conversationId, agentId, targetAgentId)
+			} else if queue, ok := parsedData["queue"].(string); ok && queue != "" {
+				failedMsg = Router.TransferToQueue(conversationId, agentId, queue)
+			} else {
+				failedMsg = "Transfer target is missing"
 			}
 
-			Omnichannel.FinishConversation(conversationId)
+			if failedMsg == "" {
+				parsedData["success"] = 1
+			} else {
+				parsedData["success"] = 0
+				parsedData["failed_message"] = failedMsg
+			}
+
+		} else if action == CMD_ACCEPT_TRANSFER || action == CMD_DECLINE_TRANSFER {
 
-			jsonData := make(map[string]interface{})
-			jsonData["event"] = EVENT_CONVERSATION_FINISHED
-			jsonData["conversationID"] = conversationId
-			s.SendEventToAgents(jsonData, agentId)
+			conversationId := parsedData["conversationID"].(string)
+			agentId := parsedData["agentID"].(string)
+
+			if failedMsg := Router.RespondToTransfer(conversationId, agentId, action == CMD_ACCEPT_TRANSFER); failedMsg == "" {
+				parsedData["success"] = 1
+			} else {
+				parsedData["success"] = 0
+				parsedData["failed_message"] = failedMsg
+			}
+
+		} else if action == CMD_GET_ACTIVE_CONVERSATIONS || action == CMD_MONITOR_CONVERSATION || action == CMD_STOP_MONITORING ||
+			action == CMD_WHISPER || action == CMD_REASSIGN_CONVERSATION || action == CMD_FORCE_CLOSE_CONVERSATION {
+
+			s.handleSupervisorRequest(con, action, parsedData)
 
 		} else if action == CMD_GET_MESSAGES {
 
@@ -166,9 +350,142 @@ func (s *TCPServer) handleClientRequest(con net.Conn) {
 		} else if action == CMD_SEND_MESSAGE {
 
 			conversationId := parsedData["conversationID"].(string)
-			messageTxt := parsedData["text"].(string)
+			messageTxt, _ := parsedData["text"].(string)
+			agent := s.GetAgentBySocket(con)
+
+			var cannedResponse *types.CannedResponse
+			shortcut, useShortcut := parsedData["shortcut"].(string)
+			if useShortcut && agent != nil {
+				cannedResponse = Omnichannel.FindCannedResponse(agent, shortcut)
+			}
+
+			if useShortcut && cannedResponse == nil {
+				parsedData["success"] = 0
+				parsedData["failed_message"] = "Canned response not found"
+			} else {
+				// only canned responses are templates, text typed by the agent is sent as it is
+				if cannedResponse != nil {
+					conversation := Omnichannel.FindActiveConversationByID(conversationId)
+					if conversation == nil {
+						conversation = Omnichannel.FindConversationByID(conversationId)
+					}
+					messageTxt = Omnichannel.ExpandPlaceholders(cannedResponse.Body, conversation, agent)
+				}
+
+				message := types.Message{Type: types.Text, Text: messageTxt, Timestamp: uint(time.Now().UnixMilli()), Status: types.Sent, SentFromAgent: true}
+				if agent != nil {
+					message.Author = agent.Id
+				}
+
+				Omnichannel.sendMessage(conversationId, message, false)
+				parsedData["message"] = message
+			}
+
+		} else if action == CMD_ADD_NOTE {
+
+			conversationId := parsedData["conversationID"].(string)
+			agentId := parsedData["agentID"].(string)
+			text := parsedData["text"].(string)
+
+			if note, failedMsg := Omnichannel.AddNote(conversationId, agentId, text); failedMsg == "" {
+				parsedData["message"] = note
+				parsedData["success"] = 1
+			} else {
+				parsedData["success"] = 0
+				parsedData["failed_message"] = failedMsg
+			}
+
+		} else if action == CMD_GET_CANNED_RESPONSES || action == CMD_FIND_CANNED_RESPONSE || action == CMD_ADD_CANNED_RESPONSE ||
+			action == CMD_UPDATE_CANNED_RESPONSE || action == CMD_DELETE_CANNED_RESPONSE {
+
+			s.handleCannedResponseRequest(con, action, parsedData)
+
+		} else if action == CMD_CREATE_AGENT || action == CMD_DISABLE_AGENT || action == CMD_ENABLE_AGENT || action == CMD_RESET_AGENT_PASSWORD ||
+			action == CMD_SET_AGENT_ROLES {
 
-			Omnichannel.sendMessage(conversationId, messageTxt, false)
+			s.handleAdminRequest(con, action, parsedData)
+
+		} else if action == CMD_SET_AGENT_SKILLS {
+
+			agentId := parsedData["agentID"].(string)
+			skills := make(map[string][]string)
+
+			if skillsData, ok := parsedData["skills"].(map[string]interface{}); ok {
+				for skill, values := range skillsData {
+					if valueList, ok := values.([]interface{}); ok {
+						for _, value := range valueList {
+							skills[skill] = append(skills[skill], value.(string))
+						}
+					}
+				}
+			}
+
+			Omnichannel.SetAgentSkills(agentId, skills)
+
+			s.updateAgent(agentId, func(agent *types.Agent) { agent.Skills = skills })
+
+			parsedData["success"] = 1
+			go Router.RouteWaitingConversations()
+
+		} else if action == CMD_SET_CUSTOMER_ATTRIBUTE {
+
+			customerID := parsedData["customer_id"].(string)
+			name := parsedData["name"].(string)
+			value := parsedData["value"].(string)
+
+			Omnichannel.SetCustomerAttribute(customerID, name, value)
+			parsedData["success"] = 1
+
+		} else if action == CMD_GET_QUEUES {
+
+			agentId := parsedData["agentID"].(string)
+			parsedData["queues"] = QueueManager.GetQueuesInfo(agentId)
+
+		} else if action == CMD_JOIN_QUEUE {
+
+			agentId := parsedData["agentID"].(string)
+			queue := parsedData["queue"].(string)
+
+			parsedData["success"] = QueueManager.JoinQueue(agentId, queue)
+			go Router.RouteWaitingConversations()
+
+		} else if action == CMD_LEAVE_QUEUE {
+
+			agentId := parsedData["agentID"].(string)
+			queue := parsedData["queue"].(string)
+
+			parsedData["success"] = QueueManager.LeaveQueue(agentId, queue)
+
+		} else if action == CMD_CALL_CUSTOMER {
+
+			conversationId := parsedData["conversationID"].(string)
+			agentId := parsedData["agentID"].(string)
+
+			if callId, failedMsg := Calls.CallCustomer(conversationId, agentId); failedMsg == "" {
+				parsedData["callID"] = callId
+				parsedData["success"] = 1
+			} else {
+				parsedData["success"] = 0
+				parsedData["failed_message"] = failedMsg
+			}
+
+		} else if action == CMD_GET_AUDIT_LOG {
+
+			var filter AuditFilter
+			filter.AgentId, _ = parsedData["agent_id"].(string)
+			filter.Event, _ = parsedData["event"].(string)
+			if from, ok := parsedData["from"].(float64); ok {
+				filter.From = uint(from)
+			}
+			if to, ok := parsedData["to"].(float64); ok {
+				filter.To = uint(to)
+			}
+			if limit, ok := parsedData["limit"].(float64); ok {
+				filter.Limit = int(limit)
+			}
+
+			parsedData["entries"] = Audit.Query(filter)
+			parsedData["success"] = 1
 		}
 
 		response, err := json.Marshal(parsedData)
@@ -178,13 +495,53 @@ func (s *TCPServer) handleClientRequest(con net.Conn) {
 	}
 }
 
+// FinishConversation closes the conversation on the agent's request. Only the agent handling it may finish it,
+// unless the agent may access all conversations. Finishing an already finished conversation does nothing.
+func (s *TCPServer) FinishConversation(conversationId string, agent *types.Agent) (failedMsg string) {
+
+	conversation := Omnichannel.FindActiveConversationByID(conversationId)
+	if conversation == nil {
+		return ""
+	}
+
+	if agent == nil || (!hasAnyRole(agent, Permissions.allConversations) && (conversation.State != types.Assigned || conversation.ConnectedAgent != agent.Id)) {
+		return "Conversation is not assigned to the agent"
+	}
+
+	s.CloseConversation(conversationId)
+
+	return ""
+}
+
+// CloseConversation finishes the conversation and frees the slot of the agent handling it,
+// it reports false if the conversation was already finished
+func (s *TCPServer) CloseConversation(conversationId string) bool {
+
+	conversation := Omnichannel.FinishConversation(conversationId)
+	if conversation == nil {
+		return false
+	}
+
+	releaseAgentConversation(conversation.ConnectedAgent)
+
+	jsonData := make(map[string]interface{})
+	jsonData["event"] = EVENT_CONVERSATION_FINISHED
+	jsonData["conversationID"] = conversationId
+	s.SendEventToAgents(jsonData, conversation.ConnectedAgent)
+	Monitor.Publish(conversationId, jsonData)
+
+	Router.RouteWaitingConversations()
+
+	return true
+}
+
 func (s *TCPServer) SendEventToAgents(jsonData map[string]interface{}, agentId string) {
 
 	data, err := json.Marshal(jsonData)
 	if err == nil {
 		if agentId == "" {
-			for i := range s.LoggedAgents {
-				s.LoggedAgents[i].Socket.Write(data)
+			for _, agent := range s.GetLoggedAgents() {
+				agent.Socket.Write(data)
 			}
 		} else {
 			if agent := s.GetAgent(agentId); agent != nil {
@@ -195,6 +552,15 @@ func (s *TCPServer) SendEventToAgents(jsonData map[string]interface{}, agentId s
 }
 
 func (s *TCPServer) GetAgent(id string) *types.Agent {
+
+	s.agentsMutex.RLock()
+	defer s.agentsMutex.RUnlock()
+
+	return s.findAgent(id)
+}
+
+// findAgent looks the logged in agent up, the caller holds agentsMutex
+func (s *TCPServer) findAgent(id string) *types.Agent {
 	for i := range s.LoggedAgents {
 		if s.LoggedAgents[i].Id == id {
 			return s.LoggedAgents[i]
@@ -204,8 +570,131 @@ func (s *TCPServer) GetAgent(id string) *types.Agent {
 	return nil
 }
 
+// GetLoggedAgents returns a copy of the list of logged in agents, so callers can range over it without holding agentsMutex
+func (s *TCPServer) GetLoggedAgents() []*types.Agent {
+
+	s.agentsMutex.RLock()
+	defer s.agentsMutex.RUnlock()
+
+	return append([]*types.Agent(nil), s.LoggedAgents...)
+}
+
+// updateAgent changes the logged in agent under agentsMutex, it reports false if the agent is not logged in
+func (s *TCPServer) updateAgent(id string, update func(agent *types.Agent)) bool {
+
+	s.agentsMutex.Lock()
+	defer s.agentsMutex.Unlock()
+
+	agent := s.findAgent(id)
+	if agent == nil {
+		return false
+	}
+
+	update(agent)
+
+	return true
+}
+
+func (s *TCPServer) LogoffAgent(agentId string) bool {
+
+	success := s.loginAuthenticator.Logout(agentId)
+
+	if success {
+		if agent := s.GetAgent(agentId); agent != nil {
+			s.removeAgent(agent)
+		}
+	}
+
+	return success
+}
+
+// ConnectionLost logs off the agent whose connection dropped, so it gets no more offers and leaves its voice queues
+func (s *TCPServer) ConnectionLost(agent *types.Agent) {
+
+	if !s.removeAgent(agent) {
+		return
+	}
+
+	// a newer login of the agent keeps its voice queues
+	if s.GetAgent(agent.Id) == nil && !s.loginAuthenticator.Logout(agent.Id) {
+		log.Println("Failed to log out agent ", agent.Id, " after its connection was lost")
+	}
+}
+
+// removeAgent takes the session out of the logged in agents and tells everybody it went offline,
+// a newer login of the same agent stays. It reports false if the session was already removed.
+func (s *TCPServer) removeAgent(agent *types.Agent) bool {
+
+	removed := false
+
+	s.agentsMutex.Lock()
+	for i := range s.LoggedAgents {
+		if s.LoggedAgents[i] == agent {
+			s.LoggedAgents = append(s.LoggedAgents[:i], s.LoggedAgents[i+1:]...)
+			removed = true
+			break
+		}
+	}
+	s.agentsMutex.Unlock()
+
+	if !removed {
+		return false
+	}
+
+	Router.ReleaseAgentOffers(agent.Id)
+	Monitor.UnsubscribeAll(agent.Id)
+	PhonePresence.Forget(agent.Id)
+
+	jsonData := make(map[string]interface{})
+	jsonData["event"] = EVENT_AGENT_STATE_CHANGED
+	jsonData["agentID"] = agent.Id
+	jsonData["state"] = types.Offline
+	s.SendEventToAgents(jsonData, "")
+
+	return true
+}
+
+func newLoginAuthenticator(authenticatorType string) auths.LoginAuthenticator {
+
+	switch authenticatorType {
+	case AUTHENTICATOR_ASTERISK:
+		return &auths.AsteriskAuthenticator{OnPhoneStateChanged: PhonePresence.PhoneStateChanged, OnCallProgress: Calls.CallProgress,
+			OnVoiceAvailabilityChanged: TcpServer.VoiceAvailabilityChanged}
+	case AUTHENTICATOR_LOCAL:
+		return &auths.LocalAuthenticator{}
+	case AUTHENTICATOR_LDAP:
+		return &auths.LDAPAuthenticator{}
+	}
+
+	log.Println("Unknown login authenticator: ", authenticatorType)
+	return nil
+}
+
+// InitializeLoginAuthenticator chains the authenticators listed in TYPE, they are tried in the configured order
 func (s *TCPServer) InitializeLoginAuthenticator() {
-	s.loginAuthenticator = &auths.AsteriskAuthenticator{}
+
+	authenticatorTypes := []string{AUTHENTICATOR_ASTERISK}
+	if cfg, err := ini.Load("conf/login_auth_conf.ini"); err == nil {
+		if configured := cfg.Section("general").Key("TYPE").Strings(","); len(configured) > 0 {
+			authenticatorTypes = configured
+		}
+	} else {
+		log.Println("Failed to read login_auth_conf file: ", err)
+	}
+
+	var names []string
+	var authenticators []auths.LoginAuthenticator
+
+	for _, authenticatorType := range authenticatorTypes {
+		if authenticator := newLoginAuthenticator(authenticatorType); authenticator != nil {
+			names = append(names, authenticatorType)
+			authenticators = append(authenticators, authenticator)
+		}
+	}
+
+	log.Println("Using login authenticators: ", names)
+
+	s.loginAuthenticator = auths.NewChainAuthenticator(names, authenticators)
 	s.loginAuthenticator.Init()
 }
 
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"server/channels"
+	"server/types"
+	"testing"
+)
+
+// useFakeAuthenticator replaces the login authenticator for the test
+func useFakeAuthenticator(t *testing.T) *fakeAuthenticator {
+
+	authenticator := &fakeAuthenticator{}
+
+	previous := TcpServer.loginAuthenticator
+	TcpServer.loginAuthenticator = authenticator
+	t.Cleanup(func() { TcpServer.loginAuthenticator = previous })
+
+	return authenticator
+}
+
+// useFakeChannel replaces the WhatsApp channel for the test
+func useFakeChannel(t *testing.T) *fakeChannel {
+
+	channel := &fakeChannel{}
+
+	previous := Omnichannel.Channels
+	Omnichannel.Channels = map[types.ChannelType]channels.Channel{types.WhatsApp: channel}
+	t.Cleanup(func() { Omnichannel.Channels = previous })
+
+	return channel
+}
+
+// sendCommand runs one command on a new connection of the agent and returns the reply, the connection is closed afterwards
+func sendCommand(t *testing.T, agent *types.Agent, command map[string]interface{}) map[string]interface{} {
+
+	server, client := net.Pipe()
+
+	TcpServer.agentsMutex.Lock()
+	agent.Socket = server
+	TcpServer.agentsMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		TcpServer.handleClientRequest(server)
+		close(done)
+	}()
+
+	data, _ := json.Marshal(command)
+	client.Write(append(data, '\n'))
+
+	// events sent to the agent on the way come before the reply
+	decoder := json.NewDecoder(client)
+	var reply map[string]interface{}
+	for reply == nil || reply["action"] != command["action"] {
+		reply = nil
+		if err := decoder.Decode(&reply); err != nil {
+			t.Fatalf("failed to read the reply to %s: %v", command["action"], err)
+		}
+	}
+
+	client.Close()
+	<-done
+
+	return reply
+}
+
+func TestConnectionLostLogsOffAgent(t *testing.T) {
+
+	lost, other := newTestAgent("1001"), newTestAgent("1002")
+	lost.IdleSince, other.IdleSince = 100, 200
+	conversation := newTestConversation("c1", "")
+
+	setupRouting(t, LongestIdleStrategy{}, []*types.Agent{lost, other}, conversation)
+	authenticator := useFakeAuthenticator(t)
+
+	Router.RouteConversation(conversation)
+	if offered := socketOf(lost).received(EVENT_CONVERSATION_OFFERED); !reflect.DeepEqual(offered, []string{"c1"}) {
+		t.Fatalf("longest idle agent was offered %v, want [c1]", offered)
+	}
+
+	// the fake socket's read fails at once, like a dropped connection
+	TcpServer.handleClientRequest(lost.Socket)
+
+	if TcpServer.GetAgent(lost.Id) != nil {
+		t.Errorf("agent with a lost connection is still logged in")
+	}
+	if logouts := authenticator.loggedOut(); !reflect.DeepEqual(logouts, []string{"1001"}) {
+		t.Errorf("authenticator logged out %v, want [1001]", logouts)
+	}
+
+	events := socketOf(other).eventsOf(EVENT_AGENT_STATE_CHANGED)
+	if len(events) != 1 || events[0]["agentID"] != "1001" || events[0]["state"] != float64(types.Offline) {
+		t.Errorf("other agents were told %v, want 1001 offline", events)
+	}
+	if offered := socketOf(other).received(EVENT_CONVERSATION_OFFERED); !reflect.DeepEqual(offered, []string{"c1"}) {
+		t.Errorf("conversation offered to the lost agent was offered %v to the next one, want [c1]", offered)
+	}
+
+	// a second drop of the same session does nothing
+	TcpServer.ConnectionLost(lost)
+	if logouts := authenticator.loggedOut(); len(logouts) != 1 {
+		t.Errorf("authenticator logged out %v after the second drop, want one logout", logouts)
+	}
+}
+
+func TestConnectionLostKeepsNewerLogin(t *testing.T) {
+
+	lost, relogged := newTestAgent("1001"), newTestAgent("1001")
+	setupRouting(t, LongestIdleStrategy{}, []*types.Agent{lost, relogged})
+	authenticator := useFakeAuthenticator(t)
+
+	TcpServer.ConnectionLost(lost)
+
+	if agents := TcpServer.GetLoggedAgents(); len(agents) != 1 || agents[0] != relogged {
+		t.Errorf("logged in agents after the old session dropped = %v, want only the newer login", agents)
+	}
+	if logouts := authenticator.loggedOut(); len(logouts) != 0 {
+		t.Errorf("authenticator logged out %v, want the newer login kept in its voice queues", logouts)
+	}
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"server/db"
+	"server/types"
+	"strconv"
+	"time"
+)
+
+type conversationTransfer struct {
+	conversationID string
+	fromAgentID    string
+	toAgentID      string
+	timer          *time.Timer
+}
+
+// IsTransferredTo reports whether the conversation waits for the agent to accept its transfer
+func (r *ConversationRouter) IsTransferredTo(conversationID string, agentID string) bool {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	transfer := r.transfers[conversationID]
+
+	return transfer != nil && transfer.toAgentID == agentID
+}
+
+// RequestTransfer asks the target agent to take over a conversation from the agent currently handling it
+func (r *ConversationRouter) RequestTransfer(conversationID string, fromAgentID string, toAgentID string) (failedMsg string) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	conversation := Omnichannel.FindActiveConversationByID(conversationID)
+	if conversation == nil || conversation.State != types.Assigned || conversation.ConnectedAgent != fromAgentID {
+		return "Conversation is not assigned to the agent"
+	}
+
+	if r.transfers[conversationID] != nil {
+		return "Conversation is already being transferred"
+	}
+
+	toAgent := TcpServer.GetAgent(toAgentID)
+	if toAgent == nil || toAgentID == fromAgentID {
+		return "Target agent is not logged in"
+	}
+
+	TcpServer.agentsMutex.RLock()
+	available := AgentCanTakeConversation(toAgent)
+	TcpServer.agentsMutex.RUnlock()
+
+	if !available {
+		return "Target agent is not available"
+	}
+
+	transfer := &conversationTransfer{conversationID: conversationID, fromAgentID: fromAgentID, toAgentID: toAgentID}
+	transfer.timer = time.AfterFunc(r.OfferTimeout, func() { r.RespondToTransfer(conversationID, toAgentID, false) })
+	r.transfers[conversationID] = transfer
+
+	jsonData := make(map[string]interface{})
+	jsonData["event"] = EVENT_TRANSFER_REQUESTED
+	jsonData["conversationID"] = conversationID
+	jsonData["fromAgentID"] = fromAgentID
+	jsonData["type"] = conversation.Type
+	jsonData["queue"] = conversation.Queue
+	jsonData["customer"] = Omnichannel.FindCustomerByID(conversation.CustomerID)
+	jsonData["timeout"] = int(r.OfferTimeout.Seconds())
+	TcpServer.SendEventToAgents(jsonData, toAgentID)
+
+	return ""
+}
+
+// RespondToTransfer completes or cancels a pending transfer once the target agent accepted, declined or let it time out
+func (r *ConversationRouter) RespondToTransfer(conversationID string, agentID string, accepted bool) (failedMsg string) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	transfer := r.transfers[conversationID]
+	if transfer == nil || transfer.toAgentID != agentID {
+		return "No pending transfer for the agent"
+	}
+
+	transfer.timer.Stop()
+	delete(r.transfers, conversationID)
+
+	conversation := Omnichannel.FindActiveConversationByID(conversationID)
+	if conversation == nil || conversation.ConnectedAgent != transfer.fromAgentID {
+		return "Conversation is no longer available"
+	}
+
+	TcpServer.agentsMutex.RLock()
+	toAgent := TcpServer.findAgent(agentID)
+	atLimit := toAgent == nil || (toAgent.MaxConversations > 0 && toAgent.Conversations >= toAgent.MaxConversations)
+	TcpServer.agentsMutex.RUnlock()
+
+	if accepted && atLimit {
+		accepted = false
+		failedMsg = "Maximum number of concurrent conversations reached"
+	}
+
+	jsonData := make(map[string]interface{})
+	jsonData["conversationID"] = conversationID
+	jsonData["fromAgentID"] = transfer.fromAgentID
+	jsonData["toAgentID"] = transfer.toAgentID
+
+	if !accepted {
+		jsonData["event"] = EVENT_TRANSFER_DECLINED
+		TcpServer.SendEventToAgents(jsonData, transfer.fromAgentID)
+		return failedMsg
+	}
+
+	Omnichannel.TransferConversation(conversation, agentID, conversation.Queue, transfer.fromAgentID+" -> "+agentID)
+	releaseAgentConversation(transfer.fromAgentID)
+
+	TcpServer.updateAgent(agentID, func(agent *types.Agent) {
+		agent.Conversations++
+		agent.IdleSince = uint(time.Now().UnixMilli())
+	})
+
+	jsonData["event"] = EVENT_CONVERSATION_TRANSFERRED
+	TcpServer.SendEventToAgents(jsonData, transfer.fromAgentID)
+	TcpServer.SendEventToAgents(jsonData, agentID)
+
+	return ""
+}
+
+// TransferToQueue puts the conversation back into a queue so it is routed to another member of that queue
+func (r *ConversationRouter) TransferToQueue(conversationID string, fromAgentID string, queueName string) (failedMsg string) {
+
+	r.mutex.Lock()
+
+	conversation := Omnichannel.FindActiveConversationByID(conversationID)
+	if conversation == nil || conversation.State != types.Assigned || conversation.ConnectedAgent != fromAgentID {
+		r.mutex.Unlock()
+		return "Conversation is not assigned to the agent"
+	}
+
+	if QueueManager.Queues[queueName] == nil {
+		r.mutex.Unlock()
+		return "Unknown queue"
+	}
+
+	if transfer := r.transfers[conversationID]; transfer != nil {
+		transfer.timer.Stop()
+		delete(r.transfers, conversationID)
+	}
+
+	Omnichannel.TransferConversation(conversation, "", queueName, fromAgentID+" -> "+queueName)
+	releaseAgentConversation(fromAgentID)
+
+	// the agent handing the conversation over is skipped like an agent who declined it
+	if offer := r.offers[conversationID]; offer != nil {
+		r.cancelOffer(offer)
+	}
+	r.offers[conversationID] = &conversationOffer{conversationID: conversationID, declinedBy: map[string]bool{fromAgentID: true}}
+
+	jsonData := make(map[string]interface{})
+	jsonData["event"] = EVENT_CONVERSATION_TRANSFERRED
+	jsonData["conversationID"] = conversationID
+	jsonData["fromAgentID"] = fromAgentID
+	jsonData["queue"] = queueName
+	TcpServer.SendEventToAgents(jsonData, fromAgentID)
+
+	r.mutex.Unlock()
+
+	r.RouteConversation(conversation)
+
+	return ""
+}
+
+func releaseAgentConversation(agentID string) {
+	TcpServer.updateAgent(agentID, func(agent *types.Agent) {
+		if agent.Conversations > 0 {
+			agent.Conversations--
+		}
+		agent.IdleSince = uint(time.Now().UnixMilli())
+	})
+}
+
+// TransferConversation hands the conversation to another agent, or back to a queue when agentID is empty, and records the transfer
+func (o *OmniChannel) TransferConversation(conversation *types.Conversation, agentID string, queueName string, description string) {
+
+	state := types.Assigned
+	if agentID == "" {
+		state = types.Unassigned
+	}
+
+	o.conversationsMutex.Lock()
+	conversation.State = state
+	conversation.ConnectedAgent = agentID
+	if agentID != "" && conversation.Assigned_Timestamp == 0 {
+		conversation.Assigned_Timestamp = uint(time.Now().UnixMilli())
+	}
+	if conversation.Queue != queueName {
+		conversation.Queue = queueName
+		conversation.Queued_Timestamp = uint(time.Now().UnixMilli())
+	}
+	o.conversationsMutex.Unlock()
+
+	message := o.createEventMessage(EVENT_CONVERSATION_TRANSFERRED, uint(time.Now().UnixMilli()))
+	message.Text = description
+	o.AddNewMessage(conversation.Id, message)
+
+	query := "UPDATE conversations SET state=" + strconv.Itoa(int(state)) + ", connected_agent='" + agentID + "', queue='" + queueName + "' WHERE id='" + conversation.Id + "'"
+	results := db.DBConnector.ExecuteQuery(OMNI_DB_CREDENTIALS, OMNI_DB_NAME, query)
+	defer results.Close()
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"reflect"
+	"server/types"
+	"testing"
+	"time"
+)
+
+// setupTransfer gives the first agent an assigned conversation in the Sales queue, both agents are Sales members
+func setupTransfer(t *testing.T) (from *types.Agent, to *types.Agent, conversation *types.Conversation) {
+
+	from, to = newTestAgent("1001", "Sales"), newTestAgent("1002", "Sales")
+	from.Conversations = 1
+
+	conversation = newTestConversation("c1", "Sales")
+	conversation.State, conversation.ConnectedAgent = types.Assigned, from.Id
+
+	setupRouting(t, LeastBusyStrategy{}, []*types.Agent{from, to}, conversation)
+	QueueManager.Queues["Sales"] = &ChatQueue{Name: "Sales"}
+
+	return from, to, conversation
+}
+
+func TestTransferToAgent(t *testing.T) {
+
+	from, to, conversation := setupTransfer(t)
+
+	if failedMsg := Router.RequestTransfer("c1", from.Id, to.Id); failedMsg != "" {
+		t.Fatalf("RequestTransfer() = %q", failedMsg)
+	}
+	if failedMsg := Router.RespondToTransfer("c1", to.Id, true); failedMsg != "" {
+		t.Fatalf("RespondToTransfer() = %q", failedMsg)
+	}
+
+	if conversation.ConnectedAgent != to.Id {
+		t.Errorf("conversation is connected to %q, want %s", conversation.ConnectedAgent, to.Id)
+	}
+	if from.Conversations != 0 || to.Conversations != 1 {
+		t.Errorf("agents have %d and %d conversations, want 0 and 1", from.Conversations, to.Conversations)
+	}
+	for _, agent := range []*types.Agent{from, to} {
+		if transferred := socketOf(agent).received(EVENT_CONVERSATION_TRANSFERRED); !reflect.DeepEqual(transferred, []string{"c1"}) {
+			t.Errorf("agent %s received transfer events %v, want [c1]", agent.Id, transferred)
+		}
+	}
+}
+
+func TestTransferRequestTimesOut(t *testing.T) {
+
+	from, to, conversation := setupTransfer(t)
+	Router.OfferTimeout = 20 * time.Millisecond
+
+	if failedMsg := Router.RequestTransfer("c1", from.Id, to.Id); failedMsg != "" {
+		t.Fatalf("RequestTransfer() = %q", failedMsg)
+	}
+
+	waitUntil(t, "the transfer to time out", func() bool { return len(socketOf(from).received(EVENT_TRANSFER_DECLINED)) > 0 })
+
+	if Router.IsTransferredTo("c1", to.Id) {
+		t.Errorf("transfer is still pending after the timeout")
+	}
+	if failedMsg := Router.RespondToTransfer("c1", to.Id, true); failedMsg == "" {
+		t.Errorf("expired transfer could still be accepted")
+	}
+
+	if conversation.ConnectedAgent != from.Id {
+		t.Errorf("conversation is connected to %q, want %s", conversation.ConnectedAgent, from.Id)
+	}
+	if from.Conversations != 1 || to.Conversations != 0 {
+		t.Errorf("agents have %d and %d conversations, want 1 and 0", from.Conversations, to.Conversations)
+	}
+}
+
+func TestTransferToQueueSkipsTransferringAgent(t *testing.T) {
+
+	from, to, conversation := setupTransfer(t)
+	to.Conversations = 2 // least busy would pick the transferring agent once it handed the conversation over
+
+	if failedMsg := Router.TransferToQueue("c1", from.Id, "Sales"); failedMsg != "" {
+		t.Fatalf("TransferToQueue() = %q", failedMsg)
+	}
+
+	if offered := socketOf(from).received(EVENT_CONVERSATION_OFFERED); len(offered) != 0 {
+		t.Errorf("transferring agent was offered %v", offered)
+	}
+	if offered := socketOf(to).received(EVENT_CONVERSATION_OFFERED); !reflect.DeepEqual(offered, []string{"c1"}) {
+		t.Fatalf("queue member was offered %v, want [c1]", offered)
+	}
+
+	if failedMsg := Router.AssignConversation("c1", to.Id); failedMsg != "" {
+		t.Fatalf("AssignConversation() = %q", failedMsg)
+	}
+
+	if conversation.ConnectedAgent != to.Id {
+		t.Errorf("conversation is connected to %q, want %s", conversation.ConnectedAgent, to.Id)
+	}
+	if from.Conversations != 0 || to.Conversations != 3 {
+		t.Errorf("agents have %d and %d conversations, want 0 and 3", from.Conversations, to.Conversations)
+	}
+}
@@ -10,12 +10,28 @@ const (
 	Unknown
 )
 
+var ChannelNames = map[ChannelType]string{
+	Viber:    "viber",
+	WhatsApp: "whatsapp",
+}
+
+func GetChannelType(name string) ChannelType {
+	for channelType, channelName := range ChannelNames {
+		if channelName == name {
+			return channelType
+		}
+	}
+
+	return Unknown
+}
+
 type ConversationState int
 
 const (
 	Unassigned ConversationState = iota
 	Assigned
 	Finished
+	Deferred
 )
 
 type MessageStatus int
@@ -26,11 +42,45 @@ const (
 	Seen
 )
 
+type AgentState int
+
+const (
+	Available AgentState = iota
+	Busy
+	Away
+	Offline
+)
+
+type PhoneState int
+
+const (
+	PhoneIdle PhoneState = iota
+	PhoneRinging
+	PhoneInCall
+)
+
+type AgentRole string
+
+const (
+	RoleAgent      AgentRole = "agent"
+	RoleSupervisor AgentRole = "supervisor"
+	RoleAdmin      AgentRole = "admin"
+)
+
+type CannedResponseScope int
+
+const (
+	GlobalScope CannedResponseScope = iota
+	QueueScope
+	PersonalScope
+)
+
 type MessageType int
 
 const (
 	Text MessageType = iota
 	Event
+	Note
 )
 
 type Message struct {
@@ -40,29 +90,67 @@ type Message struct {
 	SentFromAgent bool
 	Type          MessageType
 	Event         string
+	Author        string
 }
 
 type Customer struct {
-	Id       string
-	Name     string
-	Contacts []CustomerContact
+	Id         string
+	Name       string
+	Contacts   []CustomerContact
+	Attributes map[string]string
 }
 
 type Conversation struct {
-	Id                string
-	Type              ChannelType
-	State             ConversationState
-	CustomerID        string
-	ConnectedAgent    string
-	Messages          []Message
-	Created_Timestamp uint
+	Id                      string
+	Type                    ChannelType
+	State                   ConversationState
+	CustomerID              string
+	ConnectedAgent          string
+	Messages                []Message
+	Created_Timestamp       uint
+	Queue                   string
+	Queued_Timestamp        uint
+	Assigned_Timestamp      uint
+	FirstResponse_Timestamp uint
+	Requirements            map[string]string //map[skill]value
+
+	LastActivity         uint //timestamp of the last text message in the conversation
+	LastMessageFromAgent bool
+	InactivityWarned     bool
 }
 
 type Agent struct {
-	Id            string
-	Name          string
-	Conversations int
-	Socket        net.Conn
+	Id               string
+	Name             string
+	Roles            []AgentRole
+	AuthBackend      string //authenticator that logged the agent in
+	State            AgentState
+	PhoneState       PhoneState
+	Conversations    int
+	MaxConversations int
+	IdleSince        uint
+	Skills           map[string][]string //map[skill]values
+	Queues           []string
+	Socket           net.Conn
+}
+
+func (a *Agent) HasRole(role AgentRole) bool {
+	for _, agentRole := range a.Roles {
+		if agentRole == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+type CannedResponse struct {
+	Id       int
+	Scope    CannedResponseScope
+	Owner    string
+	Shortcut string
+	Title    string
+	Body     string
 }
 
 type CustomerContact struct {